@@ -6,13 +6,13 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
-	"strings"
 	"sync"
 	"time"
 
 	_ "net/http/pprof"
 
 	"github.com/Prateek-Gupta001/AI_Gateway/cache"
+	"github.com/Prateek-Gupta001/AI_Gateway/classifier"
 	"github.com/Prateek-Gupta001/AI_Gateway/embed"
 	"github.com/Prateek-Gupta001/AI_Gateway/llm"
 	"github.com/Prateek-Gupta001/AI_Gateway/store"
@@ -20,29 +20,63 @@ import (
 	"github.com/google/uuid"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type AIGateway struct {
-	listenAddr        string
-	store             store.Storage
-	llms              llm.LLMs
-	cache             cache.Cache
-	embed             embed.Embed
-	rateLimitDuration int
-	RateLimiter       *RateLimiter
+	listenAddr  string
+	store       store.Storage
+	llms        llm.LLMs
+	cache       cache.Cache
+	embed       embed.Embed
+	RateLimiter *RateLimiter
+	// CacheReplay, CacheContextDepth, Classifier and TimeSensitivity can be swapped by a config
+	// reload, requests read them through settings().
+	CacheReplay CacheReplayConfig
+	// CacheContextDepth is how many earlier turns of a conversation go into its cache key, 0 only caches first questions.
+	CacheContextDepth int
+	Classifier        classifier.Classifier
+	TimeSensitivity   *classifier.DynamicDetector
+
+	// CacheTTL decides how long every answer is cached, it is swapped by a config reload too.
+	CacheTTL cache.TTLPolicy
+	// CacheQuarantine decides when thumbs down take an entry out of the cache, reloaded with CacheTTL.
+	CacheQuarantine cache.QuarantinePolicy
+
+	// ExactCache answers byte identical repeats before any embedding is generated.
+	ExactCache *cache.ExactCache
+
+	// RequireAPIKey turns away requests without an API key, instead of trusting their userId header.
+	RequireAPIKey bool
+	// AdminToken is the bearer token the /admin endpoints want, they are disabled when it is empty.
+	AdminToken string
+	// OpenAdmin leaves the /admin endpoints open when there is no AdminToken, for local development only.
+	OpenAdmin bool
+	keyCache  *apiKeyCache
+	coalescer *coalescer
+
+	cfgMu      sync.RWMutex
+	config     GatewayConfig
+	configPath string
+	// cancelClassifier stops the reloaded Classifier's background work once it is replaced.
+	cancelClassifier context.CancelFunc
 }
 
-func NewAIGateway(addr string, store store.Storage, llm llm.LLMs, cache cache.Cache, embed embed.Embed, rateLimitDuration int) *AIGateway {
+func NewAIGateway(addr string, store store.Storage, llm llm.LLMs, vectorCache cache.Cache, embed embed.Embed) *AIGateway {
+	//the default config always compiles
+	timeSensitivity, _ := classifier.NewDynamicDetector(classifier.DefaultDynamicConfig())
 	return &AIGateway{
-		listenAddr:        addr,
-		store:             store,
-		llms:              llm,
-		cache:             cache,
-		embed:             embed,
-		rateLimitDuration: rateLimitDuration,
-		RateLimiter: &RateLimiter{
-			Users: make(map[string]time.Time),
-		},
+		listenAddr:      addr,
+		store:           store,
+		llms:            llm,
+		cache:           vectorCache,
+		embed:           embed,
+		Classifier:      classifier.NewHeuristic(),
+		TimeSensitivity: timeSensitivity,
+		RateLimiter:     NewRateLimiter(RateLimitConfig{}),
+		keyCache:        newAPIKeyCache(time.Minute),
+		ExactCache:      cache.NewExactCache(10000, time.Hour),
+		coalescer:       newCoalescer(),
 	}
 }
 
@@ -55,11 +89,30 @@ func (s *AIGateway) Run() {
 			slog.Error("Pprof failed", "error", err)
 		}
 	}()
-	// r.HandleFunc("POST /chat", s.RateLimit(convertToHandleFunc((s.Chat))))
-	r.HandleFunc("POST /chat", convertToHandleFunc((s.Chat)))
+	r.HandleFunc("POST /chat", s.Auth(s.RateLimit(convertToHandleFunc(s.Chat))))
+	r.HandleFunc("POST /v1/chat/completions", s.Auth(s.RateLimit(convertToHandleFunc(s.ChatCompletions))))
+	r.HandleFunc("POST /feedback", s.Auth(s.RateLimit(convertToHandleFunc(s.Feedback))))
 	// r.HandleFunc("GET /getRequests", convertToHandleFunc(s.GetAllRequests))
 	r.HandleFunc("GET /stats", convertToHandleFunc(s.GetCostSaved))
 	r.HandleFunc("GET /health", convertToHandleFunc(s.HealthCheck))
+	r.HandleFunc("POST /admin/time-sensitivity/explain", s.Admin(convertToHandleFunc(s.ExplainTimeSensitivity)))
+	r.HandleFunc("GET /admin/providers", s.Admin(convertToHandleFunc(s.GetProviders)))
+	r.HandleFunc("POST /admin/config/reload", s.Admin(convertToHandleFunc(s.ReloadConfig)))
+	r.HandleFunc("GET /admin/budgets/{userId}", s.Admin(convertToHandleFunc(s.GetBudget)))
+	r.HandleFunc("PUT /admin/budgets/{userId}", s.Admin(convertToHandleFunc(s.SetBudget)))
+	r.HandleFunc("POST /admin/budgets/{userId}/reset", s.Admin(convertToHandleFunc(s.ResetBudget)))
+	r.HandleFunc("POST /admin/keys", s.Admin(convertToHandleFunc(s.CreateAPIKey)))
+	r.HandleFunc("GET /admin/keys", s.Admin(convertToHandleFunc(s.ListAPIKeys)))
+	r.HandleFunc("POST /admin/keys/{id}/rotate", s.Admin(convertToHandleFunc(s.RotateAPIKey)))
+	r.HandleFunc("DELETE /admin/keys/{id}", s.Admin(convertToHandleFunc(s.RevokeAPIKey)))
+	r.HandleFunc("GET /admin/cache/entries", s.Admin(convertToHandleFunc(s.ListCacheEntries)))
+	r.HandleFunc("GET /admin/cache/entries/{id}", s.Admin(convertToHandleFunc(s.GetCacheEntry)))
+	r.HandleFunc("PATCH /admin/cache/entries/{id}", s.Admin(convertToHandleFunc(s.UpdateCacheEntry)))
+	r.HandleFunc("DELETE /admin/cache/entries/{id}", s.Admin(convertToHandleFunc(s.DeleteCacheEntry)))
+	r.HandleFunc("POST /admin/cache/search", s.Admin(convertToHandleFunc(s.SearchCache)))
+	r.HandleFunc("POST /admin/cache/delete", s.Admin(convertToHandleFunc(s.DeleteCacheEntries)))
+	r.HandleFunc("POST /admin/cache/calibrate", s.Admin(convertToHandleFunc(s.CalibrateCache)))
+	r.HandleFunc("GET /admin/cache/near-threshold", s.Admin(convertToHandleFunc(s.SampleCacheHits)))
 	if err := http.ListenAndServe(s.listenAddr, r); err != nil {
 		slog.Info("Got this error while trying to run the server ", "error", err)
 		panic(err)
@@ -83,41 +136,6 @@ func convertToHandleFunc(f apiFunc) http.HandlerFunc {
 	}
 }
 
-// func (s *AIGateway) RateLimit(next http.Handler) http.HandlerFunc {
-// 	return func(w http.ResponseWriter, r *http.Request) {
-// 		slog.Info("Executing the rate limiter middleware!")
-// 		userId := r.Header.Get("userId")
-// 		if userId == "" {
-// 			slog.Info("No userId provided!")
-// 			http.Error(w, "Bad Request! Access Denied", http.StatusBadRequest)
-// 			return
-// 		}
-// 		//rate limit of 1 request per seconds
-// 		rateLimitDuration := time.Duration(s.rateLimitDuration) * time.Second
-// 		CurrentTime := time.Now()
-// 		s.RateLimiter.mu.Lock()
-
-// 		timeDiff := CurrentTime.Sub(s.RateLimiter.Users[userId])
-// 		fmt.Println("The time values here are", "map time", s.RateLimiter.Users[userId], "current time", CurrentTime, "time diff", timeDiff)
-
-// 		if timeDiff > rateLimitDuration {
-// 			slog.Info("Under rate limit!")
-// 			s.RateLimiter.Users[userId] = CurrentTime
-// 			s.RateLimiter.mu.Unlock()
-// 			next.ServeHTTP(w, r)
-// 			return
-// 		}
-// 		s.RateLimiter.mu.Unlock()
-// 		http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
-// 		slog.Info("Too many requests in a short period of time!")
-// 	}
-// }
-
-type RateLimiter struct {
-	Users map[string]time.Time
-	mu    sync.Mutex
-}
-
 var Tracer = otel.Tracer("ai-gateway-service")
 
 func (m *AIGateway) HealthCheck(w http.ResponseWriter, r *http.Request) error {
@@ -128,12 +146,12 @@ func (m *AIGateway) HealthCheck(w http.ResponseWriter, r *http.Request) error {
 
 func (s *AIGateway) Chat(w http.ResponseWriter, r *http.Request) error {
 	slog.Info("---------------------------------------NEW REQUEST---------------------------------------")
-	start := time.Now()
 	ctx, span := Tracer.Start(r.Context(), "Chat")
 
 	defer span.End()
+	defer r.Body.Close()
 	var req = &types.RequestStruct{}
-	userId := r.Header.Get("userId")
+	userId := userIdFromRequest(r)
 	span.SetAttributes(
 		attribute.String("user_Id", userId),
 	)
@@ -142,38 +160,180 @@ func (s *AIGateway) Chat(w http.ResponseWriter, r *http.Request) error {
 		slog.Info("Got this error while trying to decode the request struct ", "error", err)
 		return err
 	}
-	lastSlice := req.Messages[len(req.Messages)-1]
-	if lastSlice.Role != "user" {
-		http.Error(w, "The last role in the messages array cannot be either system or assistant!", http.StatusBadRequest)
-		return fmt.Errorf("request has last role other than user")
+	if err := validateMessages(req.Messages); err != nil {
+		slog.Info("Got an invalid request", "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil
+	}
+	scope, err := resolveCacheScope(r, req.CacheScope)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil
 	}
-	lenghtOfMsg := len(req.Messages)
-	if lenghtOfMsg == 0 {
-		http.Error(w, "No messages provided", http.StatusBadRequest)
-		return fmt.Errorf("no messages provided")
+	req.CacheScope = scope
+	requestId := uuid.NewString()
+	span.SetAttributes(
+		attribute.Bool("stream", req.Streaming()),
+	)
+	if !req.Streaming() {
+		return s.chatNonStreaming(ctx, w, requestId, userId, req)
 	}
+	sw := llm.NewSSEWriter(w, requestId)
+	res, err := s.processChat(ctx, requestId, userId, req, sw)
+	if err != nil {
+		if sw.Started() {
+			//headers are already out, so the error has to go in the stream itself
+			slog.Error("Got this error in the middle of the stream", "error", err)
+			return sw.WriteError(err)
+		}
+		if writeBudgetExceeded(w, err, false) {
+			return nil
+		}
+		return err
+	}
+	if res.CacheHit {
+		replay := s.settings().CacheReplay
+		if !replay.Stream {
+			slog.Info("Writing to the frontend!")
+			WriteJSON(w, http.StatusOK, res.Cached)
+			return nil
+		}
+		slog.Info("Replaying the cached answer as a stream!")
+		if err := replayCachedAnswer(ctx, sw, res.Cached, replay); err != nil {
+			return sw.WriteError(err)
+		}
+		sw.SetCacheHit(res.Cached.CachedQuery, res.Cached.Score)
+		return sw.Close()
+	}
+	sw.SetMeta(res.Response.Model, res.Response.Level)
+	return sw.Close()
+}
+
+// chatNonStreaming collects the whole answer and sends it back as one json document.
+// This is for the batch jobs and server to server callers that don't want SSE.
+func (s *AIGateway) chatNonStreaming(ctx context.Context, w http.ResponseWriter, requestId string, userId string, req *types.RequestStruct) error {
+	collector := &llm.CollectWriter{}
+	res, err := s.processChat(ctx, requestId, userId, req, collector)
+	if err != nil {
+		if writeBudgetExceeded(w, err, false) {
+			return nil
+		}
+		return err
+	}
+	if res.CacheHit {
+		return WriteJSON(w, http.StatusOK, types.ChatResponse{
+			RequestId:    requestId,
+			Response:     res.Cached.CachedAnswer,
+			InputTokens:  res.Cached.InputTokens,
+			OutputTokens: res.Cached.OutputTokens,
+			TotalTokens:  res.Cached.InputTokens + res.Cached.OutputTokens,
+			FinishReason: llm.FinishStop,
+			CacheHit:     true,
+			CachedQuery:  res.Cached.CachedQuery,
+			Score:        res.Cached.Score,
+		})
+	}
+	finishReason := collector.FinishReason
+	if finishReason == "" {
+		finishReason = llm.FinishStop
+	}
+	return WriteJSON(w, http.StatusOK, types.ChatResponse{
+		RequestId:    requestId,
+		Response:     res.Response.LLMRes.String(),
+		InputTokens:  res.Response.InputTokens,
+		OutputTokens: res.Response.OutputTokens,
+		TotalTokens:  res.Response.TotalTokens,
+		Model:        res.Response.Model,
+		Level:        res.Response.Level,
+		FinishReason: finishReason,
+	})
+}
+
+func validateMessages(messages []types.Messages) error {
+	if len(messages) == 0 {
+		return fmt.Errorf("No messages provided")
+	}
+	if messages[len(messages)-1].Role != types.RoleUser {
+		return fmt.Errorf("The last role in the messages array cannot be either system or assistant!")
+	}
+	return nil
+}
+
+// resolveCacheScope picks the cache scope of a request: the cache_scope field of the body, else
+// the X-Cache-Scope header, else the tenant scope.
+func resolveCacheScope(r *http.Request, scope types.CacheScope) (types.CacheScope, error) {
+	if scope == "" {
+		scope = types.CacheScope(r.Header.Get("X-Cache-Scope"))
+	}
+	if scope == "" {
+		return types.ScopeTenant, nil
+	}
+	if !scope.Valid() {
+		return "", fmt.Errorf("cache_scope must be private, tenant or global")
+	}
+	return scope, nil
+}
+
+// chatResult is what processChat hands back to the handler. On a cache hit nothing has been
+// written to the StreamWriter yet, so the handler decides how the cached answer gets rendered.
+type chatResult struct {
+	Response *types.LLMResponse
+	CacheHit bool
+	Cached   types.CacheResponse
+}
+
+// processChat runs a (validated) request through the cache and routing path. It is shared by
+// every chat endpoint, the endpoint only decides the wire format through the StreamWriter.
+func (s *AIGateway) processChat(ctx context.Context, requestId string, userId string, req *types.RequestStruct, sw llm.StreamWriter) (*chatResult, error) {
+	start := time.Now()
+	span := trace.SpanFromContext(ctx)
+	lastSlice := req.Messages[len(req.Messages)-1]
 	var request types.Request //this is the object that will be inserted in the db!
-	request.Id = uuid.NewString()
+	request.Id = requestId
 	embedCtx, embedCancel := context.WithTimeout(ctx, time.Millisecond*250)
-	detachedCtx := context.WithoutCancel(r.Context())
+	detachedCtx := context.WithoutCancel(ctx)
 	// STEP 2: Apply your specific 7-second logic to this valid, traced context
 	embedGenCtx, embedGenCtxCancel := context.WithTimeout(detachedCtx, 7*time.Second)
+	lazyCaching := false
+	defer func() {
+		//the lazy caching goroutine owns the cancel func once it has been started
+		if !lazyCaching {
+			embedGenCtxCancel()
+		}
+	}()
 	defer embedCancel()
-	defer r.Body.Close()
 
 	embeddingChan := make(chan types.EmbeddingResult, 1)
 
 	userQuery := lastSlice.Content
-	dynamic := checkTimeSensitivity(userQuery)
-	slog.Info("is query dynamic?", "dynamic", dynamic)
+	settings := s.settings()
+	verdict := settings.TimeSensitivity.Explain(tenantFor(ctx), userQuery)
+	dynamic := verdict.Dynamic
+	slog.Info("is query dynamic?", "dynamic", dynamic, "matches", verdict.Matches)
+	namespace := cache.Namespace(req.CacheScope, tenantFor(ctx), userId)
+	span.SetAttributes(
+		attribute.Bool("dynamic", dynamic),
+		attribute.String("cache_scope", string(req.CacheScope)),
+	)
+	cacheKey, cacheable := cache.ConversationKey(namespace, req.Messages, settings.CacheContextDepth)
+	if namespace == "" {
+		slog.Info("Private cache scope without a user, skipping the cache", "scope", req.CacheScope)
+		cacheable = false
+	}
 
-	if !dynamic && lenghtOfMsg == 1 {
+	request.UserId = userId
+	request.UserQuery = userQuery
+	if !dynamic && cacheable {
+		if cacheRes, ok := s.ExactCache.Get(cacheKey); ok {
+			slog.Info("EXACT CACHE HIT! Skipping the embedding and the vector search")
+			request.Cacheable = true
+			return s.cacheHit(ctx, request, cacheRes, types.CacheTierExact, start), nil
+		}
 		go s.embed.SubmitJob(embedGenCtx, userQuery, embeddingChan)
-		slog.Info("The query is not dynamic and its the first one! ..... being cached!")
+		slog.Info("The query is not dynamic and its context is shallow enough! ..... being cached!", "context", cacheKey.Context != "")
 		req.CacheFlag = true
 	}
 	var embedding types.Embedding
-	request.UserId = userId
 	request.Cacheable = req.CacheFlag
 	slog.Info("cacheFlag", "cacheFlag", req.CacheFlag)
 	if req.CacheFlag {
@@ -184,7 +344,7 @@ func (s *AIGateway) Chat(w http.ResponseWriter, r *http.Request) error {
 		case result := <-embeddingChan:
 			embedding = result.Embedding_Result
 			slog.Info("embedding generation was successful", "query", result.Query)
-			cacheRes, exists, err := s.cache.ExistsInCache(ctx, embedding, userQuery)
+			cacheRes, exists, err := s.cache.ExistsInCache(ctx, cacheKey, embedding)
 			request.CacheHit = exists
 
 			if err != nil {
@@ -198,58 +358,89 @@ func (s *AIGateway) Chat(w http.ResponseWriter, r *http.Request) error {
 				request.CacheHit = false
 			}
 			if exists {
-				end2 := time.Since(start)
-				store_ctx := context.WithValue(context.Background(), types.UserIdKey, userId)
-				s.store.SubmitInsertRequest(store_ctx, types.Request{
-					Id:           request.Id,
-					Cacheable:    request.Cacheable,
-					UserId:       request.UserId,
-					LLMResponse:  cacheRes.CachedAnswer,
-					UserQuery:    cacheRes.CachedQuery,
-					InputTokens:  cacheRes.InputTokens,
-					OutputTokens: cacheRes.OutputTokens,
-					Time:         end2,
-					Model:        "",
-					CacheHit:     request.CacheHit,
-					Level:        types.High, //defaulting to high on cached requests
-				})
-				if err != nil {
-					slog.Error("Got this error while trying to insert a request in the database", "error", err.Error())
+				return s.cacheHit(ctx, request, cacheRes, types.CacheTierSemantic, start), nil
+			}
+		}
+	}
+	classification := settings.Classifier.Classify(ctx, classifier.Input{
+		Query:     userQuery,
+		Messages:  req.Messages,
+		Embedding: embedding,
+	})
+	level := classification.Level
+	request.LevelReason = classification.Classifier + ": " + classification.Reason
+	slog.Info("checking the complexity of the userQuery!", "level", level, "reason", request.LevelReason)
+	span.SetAttributes(
+		attribute.String("level", string(level)),
+		attribute.String("level_reason", classification.Reason),
+		attribute.String("classifier", classification.Classifier),
+	)
+	level, downgraded, err := s.applyBudget(ctx, userId, level)
+	if err != nil {
+		return nil, err
+	}
+	if downgraded != "" {
+		request.LevelReason += " (" + downgraded + ")"
+	}
+	//identical cache misses in flight at the same time share one provider call, a request only
+	//joins once it has been routed and its budget checked like it would have been on its own
+	var landFlight func(res *types.LLMResponse, err error)
+	genCtx := ctx
+	if req.CacheFlag {
+		key := flightKey(cacheKey, level, req.GenerationOptions)
+		f, leader := s.coalescer.join(key, cache.EntryId(cacheKey))
+		if leader {
+			landed := false
+			landFlight = func(res *types.LLMResponse, err error) {
+				if !landed {
+					landed = true
+					s.coalescer.land(key, f, res, err)
 				}
-				//need to improve the writeJson here to ensure that the frontend/client knows there was a cache hit here!
-				//need to set the headers here as well .. I guess
-				slog.Info("Writing to the frontend!")
-				WriteJSON(w, http.StatusOK, cacheRes)
-				return nil
+			}
+			defer landFlight(nil, errFlightAbandoned)
+			callCtx, cancelCall := s.flightContext(ctx, f)
+			defer cancelCall()
+			genCtx = callCtx
+			sw = &teeWriter{StreamWriter: sw, flight: f, coalescer: s.coalescer}
+		} else {
+			slog.Info("Same request already in flight, attaching to its stream")
+			request.CacheEntryId = f.entryId
+			res, followed, err := s.follow(ctx, f, sw, request, start)
+			if followed {
+				return res, err
 			}
 		}
 	}
-	level := checkComplexity(userQuery)
-	slog.Info("checking the complexity of the userQuery!", "level", level)
 	llmResStruct := &types.LLMResponse{}
-	err := s.llms.GenerateResponse(ctx, w, req.Messages, level, llmResStruct) //TODO: change this to level only ... this is just for testing!
+	err = s.llms.GenerateResponse(genCtx, sw, req.Messages, req.GenerationOptions, level, llmResStruct) //TODO: change this to level only ... this is just for testing!
+	if landFlight != nil {
+		landFlight(llmResStruct, err)
+	}
 	if err != nil {
 		slog.Error("Got this error while trying to generate response from the LLM ", "error", err)
-		return err
+		return nil, err
 	}
 	store_ctx := context.WithValue(context.Background(), types.UserIdKey, userId)
-	s.store.SubmitIncrementUserTokens(store_ctx, userId, llmResStruct.TotalTokens, llmResStruct.Level)
+	s.store.SubmitIncrementUserTokens(store_ctx, userId, llmResStruct.TotalTokens, llmResStruct.Cost, llmResStruct.Level)
+	s.RateLimiter.ChargeTokens(subjectsFromContext(ctx), llmResStruct.TotalTokens)
 	slog.Info("REQEUST INFORMATION", "request.cachehit", request.CacheHit, "req.cacheflag", req.CacheFlag)
 	cache_insert_ctx := context.WithoutCancel(ctx)
+	ttl := settings.CacheTTL.TTL(tenantFor(ctx), llmResStruct.Model, classification.Level)
 	if !request.CacheHit && req.CacheFlag {
 		if embedding != nil {
 			slog.Info("INSERTING INTO THE CACHE!")
 			//embedding worker produced on time!
-			go s.cache.InsertIntoCache(cache_insert_ctx, embedding, *llmResStruct, userQuery)
+			go s.insertIntoCache(cache_insert_ctx, cacheKey, embedding, *llmResStruct, ttl)
 		} else {
 			slog.Info("inside the else")
+			lazyCaching = true
 			go func() {
 				defer embedGenCtxCancel()
 				select {
 				case result := <-embeddingChan:
 					slog.Info("The worker did not create the embedding on time but in less than 7 seconds ... now lazy caching!")
 					embedding = result.Embedding_Result
-					s.cache.InsertIntoCache(cache_insert_ctx, embedding, *llmResStruct, userQuery)
+					s.insertIntoCache(cache_insert_ctx, cacheKey, embedding, *llmResStruct, ttl)
 				case <-embedGenCtx.Done():
 					slog.Info("Embedding Generation was taking longer than 7 seconds... skipping caching even though cacheable and cache miss")
 				}
@@ -263,7 +454,10 @@ func (s *AIGateway) Chat(w http.ResponseWriter, r *http.Request) error {
 	request.Model = llmResStruct.Model
 	request.Level = llmResStruct.Level
 	request.LLMResponse = llmResStruct.LLMRes.String()
-	request.UserQuery = userQuery
+	if req.CacheFlag {
+		//the entry the answer is cached under, the feedback on it goes there
+		request.CacheEntryId = cache.EntryId(cacheKey)
+	}
 	end := time.Since(start)
 	request.Time = end
 	slog.Info("inserting this request into the database!", "request", request)
@@ -275,25 +469,63 @@ func (s *AIGateway) Chat(w http.ResponseWriter, r *http.Request) error {
 	span.SetAttributes(
 		attribute.Bool("cachehit", request.CacheHit),
 	)
-	return nil
+	return &chatResult{Response: llmResStruct}, nil
 }
 
-func checkTimeSensitivity(query string) bool {
-	words := []string{"now", "today", "weather", "latest", "time", "today's", "current"}
-	for _, value := range words {
-		if strings.Contains(query, value) {
-			return true
-		}
-	}
-	return false
+// cacheHit records a request answered from the cache and hands the cached answer back to processChat.
+func (s *AIGateway) cacheHit(ctx context.Context, request types.Request, cacheRes types.CacheResponse, tier types.CacheTier, start time.Time) *chatResult {
+	store_ctx := context.WithValue(context.Background(), types.UserIdKey, request.UserId)
+	s.store.SubmitInsertRequest(store_ctx, types.Request{
+		Id:           request.Id,
+		Cacheable:    request.Cacheable,
+		UserId:       request.UserId,
+		LLMResponse:  cacheRes.CachedAnswer,
+		UserQuery:    request.UserQuery,
+		CachedQuery:  cacheRes.CachedQuery,
+		CacheScore:   cacheRes.Score,
+		InputTokens:  cacheRes.InputTokens,
+		OutputTokens: cacheRes.OutputTokens,
+		Time:         time.Since(start),
+		Model:        "",
+		CacheHit:     true,
+		CacheTier:    tier,
+		CacheEntryId: cacheRes.Id,
+		Level:        types.High, //defaulting to high on cached requests
+	})
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.Bool("cachehit", true),
+		attribute.String("cache_tier", string(tier)),
+	)
+	return &chatResult{CacheHit: true, Cached: cacheRes}
+}
+
+// insertIntoCache stores an answer in the vector cache and in the exact match tier in front of it.
+func (s *AIGateway) insertIntoCache(ctx context.Context, key cache.Key, embedding types.Embedding, res types.LLMResponse, ttl time.Duration) {
+	s.cache.InsertIntoCache(ctx, key, embedding, res, ttl)
+	s.ExactCache.Put(key, types.CacheResponse{
+		Id:           cache.EntryId(key),
+		CachedAnswer: res.LLMRes.String(),
+		CachedQuery:  key.Query,
+		InputTokens:  res.InputTokens,
+		OutputTokens: res.OutputTokens,
+		Score:        1,
+	}, ttl)
 }
 
-func checkComplexity(query string) types.Level {
-	numWords := strings.Fields(query)
-	if len(numWords) >= 10 {
-		return types.High
+type explainTimeSensitivityRequest struct {
+	Query  string `json:"query"`
+	Tenant string `json:"tenant"`
+}
+
+// ExplainTimeSensitivity tells you why a query was (or wasn't) judged dynamic, to help tune the lists.
+func (s *AIGateway) ExplainTimeSensitivity(w http.ResponseWriter, r *http.Request) error {
+	defer r.Body.Close()
+	var req explainTimeSensitivityRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return nil
 	}
-	return types.Easy
+	return WriteJSON(w, http.StatusOK, s.settings().TimeSensitivity.Explain(req.Tenant, req.Query))
 }
 
 // func (s *AIGateway) GetAllRequests(w http.ResponseWriter, r *http.Request) error {
@@ -306,6 +538,11 @@ func checkComplexity(query string) types.Level {
 // 	return nil
 // }
 
+// GetProviders shows the circuit breaker state, error rate and latency of every model.
+func (s *AIGateway) GetProviders(w http.ResponseWriter, r *http.Request) error {
+	return WriteJSON(w, http.StatusOK, s.llms.ProviderStats())
+}
+
 func (s *AIGateway) GetCostSaved(w http.ResponseWriter, r *http.Request) error {
 	Analytics, err := s.store.GetAnalytics()
 	if err != nil {
@@ -0,0 +1,475 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Prateek-Gupta001/AI_Gateway/cache"
+	"github.com/Prateek-Gupta001/AI_Gateway/llm"
+	"github.com/Prateek-Gupta001/AI_Gateway/store"
+	"github.com/Prateek-Gupta001/AI_Gateway/types"
+)
+
+type fakeStore struct {
+	mu       sync.Mutex
+	requests []types.Request
+	tokens   int
+	cost     float64
+	budget   types.BudgetStatus
+	keys     map[string]types.APIKey //by hash
+}
+
+func (f *fakeStore) SubmitInsertRequest(ctx context.Context, r types.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, r)
+}
+
+func (f *fakeStore) SubmitIncrementUserTokens(ctx context.Context, userId string, tokens int, cost float64, level types.Level) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.tokens += tokens
+	f.cost += cost
+}
+
+func (f *fakeStore) GetAnalytics() (types.AnalyticsResponse, error) {
+	return types.AnalyticsResponse{}, nil
+}
+
+func (f *fakeStore) GetAllRequests() ([]*types.Request, error) { return nil, nil }
+
+func (f *fakeStore) GetRateLimitOverrides(ctx context.Context) (map[string]types.RateLimitOverride, error) {
+	return nil, nil
+}
+
+func (f *fakeStore) GetBudgetStatus(ctx context.Context, userId string) (types.BudgetStatus, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.budget, nil
+}
+
+func (f *fakeStore) SetBudget(ctx context.Context, b types.Budget) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.budget.Budget = b
+	return nil
+}
+
+func (f *fakeStore) ResetBudgetUsage(ctx context.Context, userId string, period string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if period == "daily" || period == "all" {
+		f.budget.Daily = types.BudgetUsage{}
+	}
+	if period == "monthly" || period == "all" {
+		f.budget.Monthly = types.BudgetUsage{}
+	}
+	return nil
+}
+
+func (f *fakeStore) CreateAPIKey(ctx context.Context, key types.APIKey, hash string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.keys == nil {
+		f.keys = map[string]types.APIKey{}
+	}
+	f.keys[hash] = key
+	return nil
+}
+
+func (f *fakeStore) GetAPIKeyByHash(ctx context.Context, hash string) (types.APIKey, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key, ok := f.keys[hash]
+	if !ok {
+		return key, store.ErrKeyNotFound
+	}
+	return key, nil
+}
+
+func (f *fakeStore) ListAPIKeys(ctx context.Context, tenantId string) ([]types.APIKey, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	keys := []types.APIKey{}
+	for _, key := range f.keys {
+		if tenantId == "" || key.TenantId == tenantId {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (f *fakeStore) revoke(id string) (types.APIKey, bool) {
+	for hash, key := range f.keys {
+		if key.Id == id && key.RevokedAt == nil {
+			now := time.Now()
+			key.RevokedAt = &now
+			f.keys[hash] = key
+			return key, true
+		}
+	}
+	return types.APIKey{}, false
+}
+
+func (f *fakeStore) RotateAPIKey(ctx context.Context, oldId string, key types.APIKey, hash string) (types.APIKey, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	old, ok := f.revoke(oldId)
+	if !ok {
+		return key, store.ErrKeyNotFound
+	}
+	key.TenantId, key.UserId, key.Name = old.TenantId, old.UserId, old.Name
+	f.keys[hash] = key
+	return key, nil
+}
+
+func (f *fakeStore) RevokeAPIKey(ctx context.Context, id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.revoke(id); !ok {
+		return store.ErrKeyNotFound
+	}
+	return nil
+}
+
+func (f *fakeStore) SetFeedback(ctx context.Context, requestId string, userId string, feedback types.Feedback) (types.Feedback, string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := range f.requests {
+		if r := &f.requests[i]; r.Id == requestId && r.UserId == userId {
+			previous := r.Feedback
+			r.Feedback = feedback
+			return previous, r.CacheEntryId, nil
+		}
+	}
+	return types.FeedbackNone, "", store.ErrRequestNotFound
+}
+
+func (f *fakeStore) SampleCacheHits(ctx context.Context, low float32, high float32, limit int) ([]types.CacheHitSample, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	samples := []types.CacheHitSample{}
+	for _, r := range f.requests {
+		if r.CacheTier == types.CacheTierSemantic && r.CacheScore >= low && r.CacheScore <= high && len(samples) < limit {
+			samples = append(samples, types.CacheHitSample{
+				RequestId:   r.Id,
+				Query:       r.UserQuery,
+				CachedQuery: r.CachedQuery,
+				Score:       r.CacheScore,
+				Answer:      r.LLMResponse,
+			})
+		}
+	}
+	return samples, nil
+}
+
+type fakeLLM struct {
+	answer   []string
+	reloaded []llm.Registry
+}
+
+func (f *fakeLLM) GenerateResponse(ctx context.Context, sw llm.StreamWriter, messages []types.Messages, opts types.GenerationOptions, level types.Level, res *types.LLMResponse) error {
+	if ms, ok := sw.(llm.ModelSetter); ok {
+		ms.SetModel("fake")
+	}
+	res.LLMRes = new(bytes.Buffer)
+	for _, a := range f.answer {
+		if err := sw.WriteDelta(a); err != nil {
+			return err
+		}
+		res.LLMRes.WriteString(a)
+	}
+	res.InputTokens, res.OutputTokens, res.TotalTokens = 3, 4, 7
+	sw.WriteUsage(3, 4, 7)
+	sw.WriteFinish(llm.FinishStop)
+	res.Model = "fake"
+	res.Level = level
+	return nil
+}
+
+func (f *fakeLLM) ProviderStats() []llm.ProviderStats { return nil }
+
+func (f *fakeLLM) Reload(reg llm.Registry) error {
+	f.reloaded = append(f.reloaded, reg)
+	return nil
+}
+
+type fakeCache struct {
+	hit       *types.CacheResponse
+	inserted  chan string
+	threshold float32
+	sliding   bool
+	lookedUp  []cache.Key
+	ttl       time.Duration //of the last insert
+	mu        sync.Mutex    //coalesced requests can look up and insert at the same time
+}
+
+func (f *fakeCache) ExistsInCache(ctx context.Context, key cache.Key, e types.Embedding) (types.CacheResponse, bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.lookedUp = append(f.lookedUp, key)
+	if f.hit != nil {
+		return *f.hit, true, nil
+	}
+	return types.CacheResponse{}, false, nil
+}
+
+func (f *fakeCache) InsertIntoCache(ctx context.Context, key cache.Key, e types.Embedding, res types.LLMResponse, ttl time.Duration) {
+	f.mu.Lock()
+	f.ttl = ttl
+	f.mu.Unlock()
+	f.inserted <- key.Query
+}
+
+func (f *fakeCache) SetThreshold(threshold float32) { f.threshold = threshold }
+
+func (f *fakeCache) SetSliding(sliding bool) { f.sliding = sliding }
+
+type fakeEmbed struct{}
+
+func (fakeEmbed) SubmitJob(ctx context.Context, input string, out chan types.EmbeddingResult) {
+	out <- types.EmbeddingResult{Embedding_Result: types.Embedding{1, 0, 0}, Query: input}
+}
+
+func newTestGateway() (*AIGateway, *fakeStore, *fakeCache) {
+	st := &fakeStore{}
+	c := &fakeCache{inserted: make(chan string, 1)}
+	return NewAIGateway(":0", st, &fakeLLM{answer: []string{"Hello", " world"}}, c, fakeEmbed{}), st, c
+}
+
+func TestChatNonStreaming(t *testing.T) {
+	gw, st, c := newTestGateway()
+	body := `{"stream": false, "messages": [{"role": "user", "content": "what is a goroutine"}]}`
+	req := httptest.NewRequest(http.MethodPost, "/chat", strings.NewReader(body))
+	req.Header.Set("userId", "u1")
+	rec := httptest.NewRecorder()
+	if err := gw.Chat(rec, req); err != nil {
+		t.Fatalf("Chat returned %v", err)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	var res types.ChatResponse
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Response != "Hello world" || res.TotalTokens != 7 || res.Model != "fake" || res.CacheHit || res.FinishReason != llm.FinishStop {
+		t.Errorf("unexpected response %+v", res)
+	}
+	select {
+	case q := <-c.inserted:
+		if q != "what is a goroutine" {
+			t.Errorf("cached query = %q", q)
+		}
+	case <-time.After(time.Second):
+		t.Error("answer was never put in the cache")
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if len(st.requests) != 1 || st.tokens != 7 {
+		t.Errorf("store got %d requests and %d tokens", len(st.requests), st.tokens)
+	}
+}
+
+func TestChatNonStreamingCacheHit(t *testing.T) {
+	gw, _, c := newTestGateway()
+	c.hit = &types.CacheResponse{CachedAnswer: "cached", CachedQuery: "what is a goroutine?", InputTokens: 1, OutputTokens: 2, Score: 0.93}
+	body := `{"stream": false, "messages": [{"role": "user", "content": "what is a goroutine"}]}`
+	rec := httptest.NewRecorder()
+	if err := gw.Chat(rec, httptest.NewRequest(http.MethodPost, "/chat", strings.NewReader(body))); err != nil {
+		t.Fatalf("Chat returned %v", err)
+	}
+	var res types.ChatResponse
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if !res.CacheHit || res.Response != "cached" || res.CachedQuery != "what is a goroutine?" || res.Score != 0.93 {
+		t.Errorf("unexpected response %+v", res)
+	}
+}
+
+func TestChatCacheScope(t *testing.T) {
+	acme := &types.Identity{KeyId: "k1", TenantId: "acme", UserId: "u1"}
+	tests := []struct {
+		name      string
+		identity  *types.Identity
+		userId    string
+		scope     string
+		header    string
+		namespace string //empty when the cache must not be looked at
+	}{
+		{name: "tenant by default", identity: acme, namespace: "tenant:acme"},
+		{name: "private", identity: acme, scope: "private", namespace: "private:acme/u1"},
+		{name: "global", identity: acme, scope: "global", namespace: "global"},
+		{name: "header", identity: acme, header: "private", namespace: "private:acme/u1"},
+		{name: "body wins over header", identity: acme, scope: "global", header: "private", namespace: "global"},
+		{name: "legacy user keeps to its own namespace", userId: "u2", namespace: "user:u2"},
+		{name: "legacy user can't claim a tenant", userId: "acme", namespace: "user:acme"},
+		{name: "legacy user private", userId: "u2", scope: "private", namespace: "user:u2"},
+		{name: "anonymous", namespace: "anonymous"},
+		{name: "anonymous private", scope: "private"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gw, _, c := newTestGateway()
+			c.hit = &types.CacheResponse{CachedAnswer: "cached"}
+			body := `{"stream": false, "cache_scope": "` + tt.scope + `", "messages": [{"role": "user", "content": "what is a goroutine"}]}`
+			req := httptest.NewRequest(http.MethodPost, "/chat", strings.NewReader(body))
+			if tt.identity != nil {
+				req = req.WithContext(context.WithValue(req.Context(), types.IdentityKey, tt.identity))
+			}
+			if tt.userId != "" {
+				req.Header.Set("userId", tt.userId)
+			}
+			if tt.header != "" {
+				req.Header.Set("X-Cache-Scope", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			if err := gw.Chat(rec, req); err != nil {
+				t.Fatalf("Chat returned %v", err)
+			}
+			if tt.namespace == "" {
+				if len(c.lookedUp) != 0 {
+					t.Errorf("cache was looked up in %v", c.lookedUp)
+				}
+				return
+			}
+			if len(c.lookedUp) != 1 || c.lookedUp[0].Namespace != tt.namespace {
+				t.Errorf("looked up %v, want %s", c.lookedUp, tt.namespace)
+			}
+		})
+	}
+}
+
+func TestChatInvalidCacheScope(t *testing.T) {
+	gw, _, _ := newTestGateway()
+	rec := postChat(t, gw, "/chat", `{"stream": false, "cache_scope": "everyone", "messages": [{"role": "user", "content": "hi"}]}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status %d", rec.Code)
+	}
+	rec = postChat(t, gw, "/v1/chat/completions", `{"model": "gpt-4o", "cache_scope": "everyone", "messages": [{"role": "user", "content": "hi"}]}`)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "invalid_request_error") {
+		t.Errorf("status %d, body %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestChatCompletionsModel(t *testing.T) {
+	//every response names the model that answered, never the one the client asked for
+	for _, tt := range []struct {
+		name   string
+		stream bool
+		hit    bool
+		want   string
+	}{
+		{"fresh", false, false, "fake"},
+		{"fresh streamed", true, false, "fake"},
+		{"cache hit", false, true, cacheModel},
+		{"cache hit streamed", true, true, cacheModel},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			gw, _, c := newTestGateway()
+			if tt.hit {
+				c.hit = &types.CacheResponse{CachedAnswer: "cached"}
+			}
+			body := `{"model": "gpt-4o", "stream": ` + strconv.FormatBool(tt.stream) + `, "messages": [{"role": "user", "content": "what is a goroutine"}]}`
+			rec := postChat(t, gw, "/v1/chat/completions", body)
+			if !tt.stream {
+				var res ChatCompletionResponse
+				if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+					t.Fatal(err)
+				}
+				if res.Model != tt.want {
+					t.Errorf("model = %q", res.Model)
+				}
+				return
+			}
+			chunks := 0
+			for _, line := range strings.Split(rec.Body.String(), "\n") {
+				data, ok := strings.CutPrefix(line, "data: ")
+				if !ok || data == "[DONE]" {
+					continue
+				}
+				var chunk llm.OpenAIChunk
+				if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+					t.Fatal(err)
+				}
+				chunks++
+				if chunk.Model != tt.want {
+					t.Errorf("chunk %d model = %q", chunks, chunk.Model)
+				}
+			}
+			if chunks == 0 {
+				t.Errorf("no chunks in %q", rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestChatMultiTurnCache(t *testing.T) {
+	body := `{"stream": false, "messages": [
+		{"role": "system", "content": "You are the onboarding bot"},
+		{"role": "user", "content": "how do I create a project"},
+		{"role": "assistant", "content": "Click New Project"},
+		{"role": "user", "content": "and then?"}]}`
+
+	gw, _, c := newTestGateway()
+	postChat(t, gw, "/chat", body)
+	if len(c.lookedUp) != 0 {
+		t.Fatalf("follow ups shouldn't be cached without a context depth, looked up %v", c.lookedUp)
+	}
+
+	gw.CacheContextDepth = 2
+	postChat(t, gw, "/chat", body)
+	select {
+	case q := <-c.inserted:
+		if q != "and then?" {
+			t.Errorf("cached query = %q", q)
+		}
+	case <-time.After(time.Second):
+		t.Error("answer was never put in the cache")
+	}
+	if len(c.lookedUp) != 1 || c.lookedUp[0].Context == "" || c.lookedUp[0].Query != "and then?" {
+		t.Errorf("looked up %+v", c.lookedUp)
+	}
+}
+
+func TestChatExactTier(t *testing.T) {
+	gw, st, c := newTestGateway()
+	body := `{"stream": false, "messages": [{"role": "user", "content": "what is a goroutine"}]}`
+	postChat(t, gw, "/chat", body)
+	select {
+	case <-c.inserted:
+	case <-time.After(time.Second):
+		t.Fatal("answer was never put in the cache")
+	}
+	//insertIntoCache fills the exact tier right after the vector cache
+	deadline := time.Now().Add(time.Second)
+	for gw.ExactCache.Len() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	rec := postChat(t, gw, "/chat", `{"stream": false, "messages": [{"role": "user", "content": "What is a   goroutine"}]}`)
+	var res types.ChatResponse
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if !res.CacheHit || res.Response != "Hello world" {
+		t.Errorf("unexpected response %+v", res)
+	}
+	if len(c.lookedUp) != 1 {
+		t.Errorf("the repeat went to the vector cache: %v", c.lookedUp)
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if len(st.requests) != 2 || st.requests[1].CacheTier != types.CacheTierExact {
+		t.Errorf("recorded %+v", st.requests)
+	}
+}
@@ -0,0 +1,293 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Prateek-Gupta001/AI_Gateway/store"
+	"github.com/Prateek-Gupta001/AI_Gateway/types"
+	"github.com/google/uuid"
+)
+
+const apiKeyPrefix = "gwk_"
+
+// generateAPIKey returns a new random key, the part of it that is safe to show in listings, and its hash.
+func generateAPIKey() (key string, prefix string, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", "", err
+	}
+	key = apiKeyPrefix + base64.RawURLEncoding.EncodeToString(b)
+	return key, key[:len(apiKeyPrefix)+6], hashAPIKey(key), nil
+}
+
+// hashAPIKey is a plain sha256, the keys are 256 bits of randomness so there is nothing to brute force.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func apiKeyFromRequest(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return ""
+}
+
+// apiKeyCache saves a Postgres round trip on every request. Only valid keys are cached, so
+// random keys can't grow it, and revoking or rotating through the admin API drops them at once.
+type apiKeyCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]apiKeyCacheEntry
+}
+
+type apiKeyCacheEntry struct {
+	key     types.APIKey
+	expires time.Time
+}
+
+func newAPIKeyCache(ttl time.Duration) *apiKeyCache {
+	return &apiKeyCache{ttl: ttl, entries: map[string]apiKeyCacheEntry{}}
+}
+
+func (c *apiKeyCache) get(hash string) (types.APIKey, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[hash]
+	if !ok || time.Now().After(e.expires) {
+		delete(c.entries, hash)
+		return types.APIKey{}, false
+	}
+	return e.key, true
+}
+
+func (c *apiKeyCache) put(hash string, key types.APIKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[hash] = apiKeyCacheEntry{key: key, expires: time.Now().Add(c.ttl)}
+}
+
+func (c *apiKeyCache) forget(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for hash, e := range c.entries {
+		if e.key.Id == id {
+			delete(c.entries, hash)
+		}
+	}
+}
+
+var errInvalidAPIKey = errors.New("invalid or revoked API key")
+
+func (s *AIGateway) verifyAPIKey(ctx context.Context, key string) (*types.Identity, error) {
+	hash := hashAPIKey(key)
+	stored, ok := s.keyCache.get(hash)
+	if !ok {
+		var err error
+		stored, err = s.store.GetAPIKeyByHash(ctx, hash)
+		if errors.Is(err, store.ErrKeyNotFound) {
+			return nil, errInvalidAPIKey
+		}
+		if err != nil {
+			return nil, err
+		}
+		if stored.RevokedAt == nil {
+			s.keyCache.put(hash, stored)
+		}
+	}
+	if stored.RevokedAt != nil {
+		return nil, errInvalidAPIKey
+	}
+	return &types.Identity{KeyId: stored.Id, TenantId: stored.TenantId, UserId: stored.UserId}, nil
+}
+
+// Auth resolves the API key into an Identity on the request context (types.IdentityKey, and the
+// user id under types.UserIdKey). Without RequireAPIKey, requests with no key at all still get
+// through and are identified by the old userId header.
+func (s *AIGateway) Auth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		openAI := strings.HasPrefix(r.URL.Path, "/v1/")
+		key := apiKeyFromRequest(r)
+		if key == "" {
+			if s.RequireAPIKey {
+				writeAuthError(w, http.StatusUnauthorized, "missing API key", openAI)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		identity, err := s.verifyAPIKey(r.Context(), key)
+		if errors.Is(err, errInvalidAPIKey) {
+			writeAuthError(w, http.StatusUnauthorized, err.Error(), openAI)
+			return
+		}
+		if err != nil {
+			slog.Error("Got this error while trying to verify an API key", "error", err)
+			writeAuthError(w, http.StatusServiceUnavailable, "could not verify the API key", openAI)
+			return
+		}
+		ctx := context.WithValue(r.Context(), types.IdentityKey, identity)
+		ctx = context.WithValue(ctx, types.UserIdKey, identity.UserId)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	}
+}
+
+func writeAuthError(w http.ResponseWriter, status int, message string, openAI bool) {
+	if openAI {
+		writeOpenAIError(w, status, message, "invalid_request_error")
+		return
+	}
+	http.Error(w, message, status)
+}
+
+// userIdFromRequest is the verified user when there is an API key, the userId header otherwise.
+func userIdFromRequest(r *http.Request) string {
+	if identity := types.IdentityFromContext(r.Context()); identity != nil {
+		return identity.UserId
+	}
+	return r.Header.Get("userId")
+}
+
+// tenantFor is the tenant of the request's API key. Requests that came in without one have
+// no tenant, their userId header is whatever the client sent and can't vouch for one.
+func tenantFor(ctx context.Context) string {
+	if identity := types.IdentityFromContext(ctx); identity != nil {
+		return identity.TenantId
+	}
+	return ""
+}
+
+// Admin guards the /admin endpoints with AdminToken. Without one set they are closed, unless
+// OpenAdmin says they may be left open for local development.
+func (s *AIGateway) Admin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.AdminToken == "" && !s.OpenAdmin {
+			http.Error(w, "The admin endpoints are disabled, set ADMIN_TOKEN", http.StatusServiceUnavailable)
+			return
+		}
+		if s.AdminToken != "" {
+			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(token), []byte(s.AdminToken)) != 1 {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+type createAPIKeyRequest struct {
+	TenantId string `json:"tenant_id"`
+	UserId   string `json:"user_id"`
+	Name     string `json:"name"`
+}
+
+// apiKeyResponse is the only time the key itself is ever sent back.
+type apiKeyResponse struct {
+	Key    string       `json:"key"`
+	APIKey types.APIKey `json:"api_key"`
+}
+
+func newAPIKey() (types.APIKey, string, string, error) {
+	key, prefix, hash, err := generateAPIKey()
+	if err != nil {
+		return types.APIKey{}, "", "", err
+	}
+	return types.APIKey{
+		Id:        uuid.NewString(),
+		Prefix:    prefix,
+		CreatedAt: time.Now().UTC(),
+	}, key, hash, nil
+}
+
+// CreateAPIKey issues a key for a user of a tenant, creating both if they don't exist yet.
+func (s *AIGateway) CreateAPIKey(w http.ResponseWriter, r *http.Request) error {
+	defer r.Body.Close()
+	var req createAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return nil
+	}
+	if req.TenantId == "" || req.UserId == "" || len(req.TenantId) > 50 || len(req.UserId) > 50 {
+		http.Error(w, "tenant_id and user_id are required (50 characters at most)", http.StatusBadRequest)
+		return nil
+	}
+	apiKey, key, hash, err := newAPIKey()
+	if err != nil {
+		return err
+	}
+	apiKey.TenantId, apiKey.UserId, apiKey.Name = req.TenantId, req.UserId, req.Name
+	if err := s.store.CreateAPIKey(r.Context(), apiKey, hash); err != nil {
+		return err
+	}
+	slog.Info("API key created", "id", apiKey.Id, "tenant", apiKey.TenantId, "user", apiKey.UserId)
+	return WriteJSON(w, http.StatusCreated, apiKeyResponse{Key: key, APIKey: apiKey})
+}
+
+// ListAPIKeys lists the keys (never the keys themselves), optionally of one tenant with ?tenant_id=.
+func (s *AIGateway) ListAPIKeys(w http.ResponseWriter, r *http.Request) error {
+	keys, err := s.store.ListAPIKeys(r.Context(), r.URL.Query().Get("tenant_id"))
+	if err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, keys)
+}
+
+// RotateAPIKey revokes a key and hands back its replacement.
+func (s *AIGateway) RotateAPIKey(w http.ResponseWriter, r *http.Request) error {
+	apiKey, key, hash, err := newAPIKey()
+	if err != nil {
+		return err
+	}
+	id := r.PathValue("id")
+	if uuid.Validate(id) != nil {
+		http.Error(w, "No active key with this id", http.StatusNotFound)
+		return nil
+	}
+	apiKey, err = s.store.RotateAPIKey(r.Context(), id, apiKey, hash)
+	if errors.Is(err, store.ErrKeyNotFound) {
+		http.Error(w, "No active key with this id", http.StatusNotFound)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	s.keyCache.forget(id)
+	slog.Info("API key rotated", "old", id, "new", apiKey.Id)
+	return WriteJSON(w, http.StatusOK, apiKeyResponse{Key: key, APIKey: apiKey})
+}
+
+func (s *AIGateway) RevokeAPIKey(w http.ResponseWriter, r *http.Request) error {
+	id := r.PathValue("id")
+	if uuid.Validate(id) != nil {
+		http.Error(w, "No active key with this id", http.StatusNotFound)
+		return nil
+	}
+	err := s.store.RevokeAPIKey(r.Context(), id)
+	if errors.Is(err, store.ErrKeyNotFound) {
+		http.Error(w, "No active key with this id", http.StatusNotFound)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	s.keyCache.forget(id)
+	slog.Info("API key revoked", "id", id)
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
@@ -0,0 +1,186 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Prateek-Gupta001/AI_Gateway/types"
+)
+
+func createKey(t *testing.T, gw *AIGateway, body string) apiKeyResponse {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	if err := gw.CreateAPIKey(rec, httptest.NewRequest(http.MethodPost, "/admin/keys", strings.NewReader(body))); err != nil {
+		t.Fatalf("CreateAPIKey returned %v", err)
+	}
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status %d, body %s", rec.Code, rec.Body.String())
+	}
+	var res apiKeyResponse
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+// authed sends a request with key through the Auth middleware and returns the identity the handler saw.
+func authed(gw *AIGateway, path string, key string, userId string) (*httptest.ResponseRecorder, *types.Identity) {
+	var identity *types.Identity
+	h := gw.Auth(func(w http.ResponseWriter, r *http.Request) {
+		identity = types.IdentityFromContext(r.Context())
+		if got := userIdFromRequest(r); identity != nil && got != identity.UserId {
+			http.Error(w, "user id "+got, http.StatusInternalServerError)
+		}
+	})
+	req := httptest.NewRequest(http.MethodPost, path, nil)
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	if userId != "" {
+		req.Header.Set("userId", userId)
+	}
+	rec := httptest.NewRecorder()
+	h(rec, req)
+	return rec, identity
+}
+
+func TestAuthValidKey(t *testing.T) {
+	gw, st, _ := newTestGateway()
+	res := createKey(t, gw, `{"tenant_id": "acme", "user_id": "u1", "name": "ci"}`)
+	if !strings.HasPrefix(res.Key, apiKeyPrefix) || !strings.HasPrefix(res.Key, res.APIKey.Prefix) {
+		t.Errorf("key %q, prefix %q", res.Key, res.APIKey.Prefix)
+	}
+	for hash := range st.keys {
+		if strings.Contains(hash, res.Key) || hash != hashAPIKey(res.Key) {
+			t.Errorf("stored hash %q", hash)
+		}
+	}
+
+	//the userId header can't override the key's user
+	rec, identity := authed(gw, "/chat", res.Key, "someone-else")
+	if rec.Code != http.StatusOK || identity == nil {
+		t.Fatalf("status %d, body %s", rec.Code, rec.Body.String())
+	}
+	if identity.TenantId != "acme" || identity.UserId != "u1" || identity.KeyId != res.APIKey.Id {
+		t.Errorf("identity = %+v", identity)
+	}
+}
+
+func TestAuthRejectsUnknownAndRevokedKeys(t *testing.T) {
+	gw, _, _ := newTestGateway()
+	if rec, _ := authed(gw, "/chat", "gwk_nope", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("unknown key: status %d", rec.Code)
+	}
+
+	res := createKey(t, gw, `{"tenant_id": "acme", "user_id": "u1"}`)
+	if rec, _ := authed(gw, "/chat", res.Key, ""); rec.Code != http.StatusOK {
+		t.Fatalf("status %d", rec.Code)
+	}
+	req := httptest.NewRequest(http.MethodDelete, "/admin/keys/"+res.APIKey.Id, nil)
+	req.SetPathValue("id", res.APIKey.Id)
+	rec := httptest.NewRecorder()
+	if err := gw.RevokeAPIKey(rec, req); err != nil || rec.Code != http.StatusNoContent {
+		t.Fatalf("revoke: status %d, err %v", rec.Code, err)
+	}
+	//the key was cached by the first request, revoking has to drop it
+	rec, _ = authed(gw, "/v1/chat/completions", res.Key, "")
+	var errRes OpenAIError
+	if err := json.NewDecoder(rec.Body).Decode(&errRes); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusUnauthorized || errRes.Error.Message == "" {
+		t.Errorf("revoked key: status %d, error %+v", rec.Code, errRes.Error)
+	}
+}
+
+func TestAuthWithoutKey(t *testing.T) {
+	gw, _, _ := newTestGateway()
+	rec, identity := authed(gw, "/chat", "", "u1")
+	if rec.Code != http.StatusOK || identity != nil {
+		t.Errorf("legacy request: status %d, identity %+v", rec.Code, identity)
+	}
+
+	gw.RequireAPIKey = true
+	if rec, _ := authed(gw, "/chat", "", "u1"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("missing key: status %d", rec.Code)
+	}
+}
+
+func TestRotateAPIKey(t *testing.T) {
+	gw, _, _ := newTestGateway()
+	old := createKey(t, gw, `{"tenant_id": "acme", "user_id": "u1", "name": "ci"}`)
+	rotate := func(id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/admin/keys/"+id+"/rotate", nil)
+		req.SetPathValue("id", id)
+		rec := httptest.NewRecorder()
+		if err := gw.RotateAPIKey(rec, req); err != nil {
+			t.Fatalf("RotateAPIKey returned %v", err)
+		}
+		return rec
+	}
+	rec := rotate(old.APIKey.Id)
+	var res apiKeyResponse
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Key == old.Key || res.APIKey.TenantId != "acme" || res.APIKey.UserId != "u1" || res.APIKey.Name != "ci" {
+		t.Errorf("rotated key %+v", res.APIKey)
+	}
+	if rec, _ := authed(gw, "/chat", old.Key, ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("old key: status %d", rec.Code)
+	}
+	if rec, _ := authed(gw, "/chat", res.Key, ""); rec.Code != http.StatusOK {
+		t.Errorf("new key: status %d", rec.Code)
+	}
+	if rec := rotate(old.APIKey.Id); rec.Code != http.StatusNotFound {
+		t.Errorf("rotating a revoked key: status %d", rec.Code)
+	}
+	if rec := rotate("not-a-uuid"); rec.Code != http.StatusNotFound {
+		t.Errorf("rotating a bad id: status %d", rec.Code)
+	}
+}
+
+func TestCreateAPIKeyValidation(t *testing.T) {
+	gw, _, _ := newTestGateway()
+	rec := httptest.NewRecorder()
+	if err := gw.CreateAPIKey(rec, httptest.NewRequest(http.MethodPost, "/admin/keys", strings.NewReader(`{"user_id": "u1"}`))); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status %d", rec.Code)
+	}
+}
+
+func TestAdminToken(t *testing.T) {
+	gw, _, _ := newTestGateway()
+	h := gw.Admin(func(w http.ResponseWriter, r *http.Request) {})
+	send := func(token string) int {
+		req := httptest.NewRequest(http.MethodGet, "/admin/keys", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		h(rec, req)
+		return rec.Code
+	}
+	if code := send(""); code != http.StatusServiceUnavailable {
+		t.Errorf("no admin token set: status %d", code)
+	}
+	gw.OpenAdmin = true
+	if code := send(""); code != http.StatusOK {
+		t.Errorf("no admin token set and open: status %d", code)
+	}
+	gw.AdminToken = "s3cret"
+	if code := send(""); code != http.StatusUnauthorized {
+		t.Errorf("missing token: status %d", code)
+	}
+	if code := send("wrong"); code != http.StatusUnauthorized {
+		t.Errorf("wrong token: status %d", code)
+	}
+	if code := send("s3cret"); code != http.StatusOK {
+		t.Errorf("right token: status %d", code)
+	}
+}
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/Prateek-Gupta001/AI_Gateway/types"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// BudgetExceededError is returned by processChat when a user with a "reject" budget has used it up.
+type BudgetExceededError struct {
+	UserId string
+	Limit  string
+}
+
+func (e *BudgetExceededError) Error() string {
+	return fmt.Sprintf("%s exhausted for user %s", e.Limit, e.UserId)
+}
+
+// writeBudgetExceeded answers with a 429 if err is a BudgetExceededError, and reports if it did.
+func writeBudgetExceeded(w http.ResponseWriter, err error, openAI bool) bool {
+	var budgetErr *BudgetExceededError
+	if !errors.As(err, &budgetErr) {
+		return false
+	}
+	if openAI {
+		writeOpenAIError(w, http.StatusTooManyRequests, budgetErr.Error(), "insufficient_quota")
+	} else {
+		http.Error(w, budgetErr.Error(), http.StatusTooManyRequests)
+	}
+	return true
+}
+
+// applyBudget checks the user's budget before a provider gets called. Once it is used up the request
+// is either rejected or downgraded to the easy level, depending on the budget's action. Like the
+// embedding lookup it fails open: if the store can't answer in time the request goes through.
+func (s *AIGateway) applyBudget(ctx context.Context, userId string, level types.Level) (types.Level, string, error) {
+	if userId == "" {
+		return level, "", nil
+	}
+	span := trace.SpanFromContext(ctx)
+	budgetCtx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	status, err := s.store.GetBudgetStatus(budgetCtx, userId)
+	if err != nil {
+		slog.Error("Got this error while trying to check the budget, letting the request through", "userId", userId, "error", err)
+		return level, "", nil
+	}
+	exceeded, limit := status.Exceeded()
+	if !exceeded {
+		return level, "", nil
+	}
+	span.SetAttributes(
+		attribute.String("budget_exceeded", limit),
+		attribute.String("budget_action", string(status.Budget.Action)),
+	)
+	if status.Budget.Action == types.BudgetDowngrade {
+		slog.Info("Budget used up, downgrading the request", "userId", userId, "limit", limit, "from", level)
+		return types.Easy, fmt.Sprintf("downgraded from %s, %s exhausted", level, limit), nil
+	}
+	slog.Info("Budget used up, rejecting the request", "userId", userId, "limit", limit)
+	return level, "", &BudgetExceededError{UserId: userId, Limit: limit}
+}
+
+// GetBudget returns a user's budget and what they have spent in the current day and month.
+func (s *AIGateway) GetBudget(w http.ResponseWriter, r *http.Request) error {
+	status, err := s.store.GetBudgetStatus(r.Context(), r.PathValue("userId"))
+	if err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, status)
+}
+
+// SetBudget replaces a user's budget. Limits left out (or 0) mean no limit.
+func (s *AIGateway) SetBudget(w http.ResponseWriter, r *http.Request) error {
+	defer r.Body.Close()
+	var budget types.Budget
+	if err := json.NewDecoder(r.Body).Decode(&budget); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return nil
+	}
+	budget.UserId = r.PathValue("userId")
+	if budget.Action == "" {
+		budget.Action = types.BudgetReject
+	}
+	if budget.Action != types.BudgetReject && budget.Action != types.BudgetDowngrade {
+		http.Error(w, "action must be reject or downgrade", http.StatusBadRequest)
+		return nil
+	}
+	if budget.DailyTokens < 0 || budget.MonthlyTokens < 0 || budget.DailyDollars < 0 || budget.MonthlyDollars < 0 {
+		http.Error(w, "budgets can't be negative", http.StatusBadRequest)
+		return nil
+	}
+	if err := s.store.SetBudget(r.Context(), budget); err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, budget)
+}
+
+type resetBudgetRequest struct {
+	Period string `json:"period"` // daily | monthly | all
+}
+
+// ResetBudget zeroes what a user has spent in the current day, month or both.
+func (s *AIGateway) ResetBudget(w http.ResponseWriter, r *http.Request) error {
+	defer r.Body.Close()
+	var req resetBudgetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return nil
+	}
+	if req.Period != "daily" && req.Period != "monthly" && req.Period != "all" {
+		http.Error(w, "period must be daily, monthly or all", http.StatusBadRequest)
+		return nil
+	}
+	if err := s.store.ResetBudgetUsage(r.Context(), r.PathValue("userId"), req.Period); err != nil {
+		return err
+	}
+	return s.GetBudget(w, r)
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Prateek-Gupta001/AI_Gateway/types"
+)
+
+func postChat(t *testing.T, gw *AIGateway, path string, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set("userId", "u1")
+	rec := httptest.NewRecorder()
+	handler := gw.Chat
+	if path == "/v1/chat/completions" {
+		handler = gw.ChatCompletions
+	}
+	if err := handler(rec, req); err != nil {
+		t.Fatalf("handler returned %v", err)
+	}
+	return rec
+}
+
+// the query is long enough for the heuristic classifier to pick high
+const longQuery = `{"stream": false, "messages": [{"role": "user", "content": "today please explain in detail how the go scheduler decides which goroutine runs next"}]}`
+
+func TestBudgetReject(t *testing.T) {
+	gw, st, _ := newTestGateway()
+	st.budget = types.BudgetStatus{
+		Budget: types.Budget{DailyTokens: 100, Action: types.BudgetReject},
+		Daily:  types.BudgetUsage{Tokens: 100},
+	}
+	rec := postChat(t, gw, "/chat", longQuery)
+	if rec.Code != http.StatusTooManyRequests || !strings.Contains(rec.Body.String(), "daily token budget") {
+		t.Errorf("status %d, body %s", rec.Code, rec.Body.String())
+	}
+
+	rec = postChat(t, gw, "/v1/chat/completions", `{"model": "gpt-4o", "messages": [{"role": "user", "content": "hi today"}]}`)
+	var res OpenAIError
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusTooManyRequests || res.Error.Type != "insufficient_quota" {
+		t.Errorf("status %d, error %+v", rec.Code, res.Error)
+	}
+	if len(st.requests) != 0 || st.tokens != 0 {
+		t.Errorf("nothing should have been generated")
+	}
+}
+
+func TestBudgetDowngrade(t *testing.T) {
+	gw, st, _ := newTestGateway()
+	st.budget = types.BudgetStatus{
+		Budget:  types.Budget{MonthlyDollars: 5, Action: types.BudgetDowngrade},
+		Monthly: types.BudgetUsage{Dollars: 5.2},
+	}
+	rec := postChat(t, gw, "/chat", longQuery)
+	var res types.ChatResponse
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK || res.Level != types.Easy {
+		t.Errorf("status %d, level %q", rec.Code, res.Level)
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if len(st.requests) != 1 || !strings.Contains(st.requests[0].LevelReason, "downgraded from high, monthly dollar budget exhausted") {
+		t.Errorf("requests = %+v", st.requests)
+	}
+}
+
+func TestBudgetAdmin(t *testing.T) {
+	gw, st, _ := newTestGateway()
+	mux := http.NewServeMux()
+	mux.HandleFunc("PUT /admin/budgets/{userId}", convertToHandleFunc(gw.SetBudget))
+	mux.HandleFunc("POST /admin/budgets/{userId}/reset", convertToHandleFunc(gw.ResetBudget))
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/admin/budgets/u1", strings.NewReader(`{"action": "refund"}`)))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown action got status %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/admin/budgets/u1", strings.NewReader(`{"daily_tokens": 5000}`)))
+	if rec.Code != http.StatusOK || st.budget.Budget.UserId != "u1" || st.budget.Budget.Action != types.BudgetReject {
+		t.Errorf("status %d, budget %+v", rec.Code, st.budget.Budget)
+	}
+
+	st.budget.Daily = types.BudgetUsage{Tokens: 6000}
+	st.budget.Monthly = types.BudgetUsage{Tokens: 9000}
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/admin/budgets/u1/reset", strings.NewReader(`{"period": "daily"}`)))
+	var status types.BudgetStatus
+	if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	if status.Daily.Tokens != 0 || status.Monthly.Tokens != 9000 {
+		t.Errorf("status = %+v", status)
+	}
+}
@@ -0,0 +1,238 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Prateek-Gupta001/AI_Gateway/cache"
+	"github.com/Prateek-Gupta001/AI_Gateway/types"
+	"github.com/google/uuid"
+)
+
+// cacheAdmin is the vector cache if its backend can be browsed, otherwise it answers 501 itself.
+func (s *AIGateway) cacheAdmin(w http.ResponseWriter) (cache.Admin, bool) {
+	admin, ok := s.cache.(cache.Admin)
+	if !ok {
+		http.Error(w, "This cache backend can't be browsed", http.StatusNotImplemented)
+	}
+	return admin, ok
+}
+
+// ListCacheEntries pages through the cache, ?namespace=, ?limit= (50 by default, 500 at most)
+// and ?cursor= with the next_cursor of the previous page.
+func (s *AIGateway) ListCacheEntries(w http.ResponseWriter, r *http.Request) error {
+	admin, ok := s.cacheAdmin(w)
+	if !ok {
+		return nil
+	}
+	opts := cache.ListOptions{
+		Namespace: r.URL.Query().Get("namespace"),
+		Cursor:    r.URL.Query().Get("cursor"),
+		Limit:     50,
+	}
+	if limit := r.URL.Query().Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > 500 {
+			http.Error(w, "limit must be between 1 and 500", http.StatusBadRequest)
+			return nil
+		}
+		opts.Limit = n
+	}
+	if opts.Cursor != "" && uuid.Validate(opts.Cursor) != nil {
+		http.Error(w, "Invalid cursor", http.StatusBadRequest)
+		return nil
+	}
+	page, err := admin.ListEntries(r.Context(), opts)
+	if err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, page)
+}
+
+func (s *AIGateway) GetCacheEntry(w http.ResponseWriter, r *http.Request) error {
+	admin, ok := s.cacheAdmin(w)
+	if !ok {
+		return nil
+	}
+	id := r.PathValue("id")
+	if uuid.Validate(id) != nil {
+		http.Error(w, "No cache entry with this id", http.StatusNotFound)
+		return nil
+	}
+	entry, err := admin.GetEntry(r.Context(), id)
+	if errors.Is(err, cache.ErrEntryNotFound) {
+		http.Error(w, "No cache entry with this id", http.StatusNotFound)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, entry)
+}
+
+type searchCacheRequest struct {
+	Text      string `json:"text"`
+	Namespace string `json:"namespace"`
+	Limit     int    `json:"limit"`
+}
+
+// SearchCache embeds the text and lists its nearest entries with their scores, so you can see
+// what a query would be answered with and how close the runners up are.
+func (s *AIGateway) SearchCache(w http.ResponseWriter, r *http.Request) error {
+	defer r.Body.Close()
+	admin, ok := s.cacheAdmin(w)
+	if !ok {
+		return nil
+	}
+	var req searchCacheRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return nil
+	}
+	if req.Text == "" {
+		http.Error(w, "text is required", http.StatusBadRequest)
+		return nil
+	}
+	if req.Limit == 0 {
+		req.Limit = 10
+	}
+	if req.Limit < 1 || req.Limit > 100 {
+		http.Error(w, "limit must be between 1 and 100", http.StatusBadRequest)
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	embeddingChan := make(chan types.EmbeddingResult, 1)
+	go s.embed.SubmitJob(ctx, req.Text, embeddingChan)
+	var embedding types.Embedding
+	select {
+	case result := <-embeddingChan:
+		if result.Err != nil {
+			return result.Err
+		}
+		embedding = result.Embedding_Result
+	case <-ctx.Done():
+		http.Error(w, "The embedding service didn't answer in time", http.StatusServiceUnavailable)
+		return nil
+	}
+	entries, err := admin.SearchEntries(ctx, req.Namespace, embedding, req.Limit)
+	if err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, entries)
+}
+
+type deleteCacheRequest struct {
+	Ids              []string `json:"ids"`
+	Namespace        string   `json:"namespace"`
+	Query            string   `json:"query"`
+	OlderThanSeconds int      `json:"older_than_seconds"`
+}
+
+type deleteCacheResponse struct {
+	Deleted int `json:"deleted"`
+}
+
+// DeleteCacheEntries deletes the entries matching every criterion given, at least one is needed
+// so an empty body can't wipe the cache.
+func (s *AIGateway) DeleteCacheEntries(w http.ResponseWriter, r *http.Request) error {
+	defer r.Body.Close()
+	admin, ok := s.cacheAdmin(w)
+	if !ok {
+		return nil
+	}
+	var req deleteCacheRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return nil
+	}
+	for _, id := range req.Ids {
+		if uuid.Validate(id) != nil {
+			http.Error(w, "Invalid id "+strconv.Quote(id), http.StatusBadRequest)
+			return nil
+		}
+	}
+	if req.OlderThanSeconds < 0 {
+		http.Error(w, "older_than_seconds can't be negative", http.StatusBadRequest)
+		return nil
+	}
+	filter := cache.DeleteFilter{Ids: req.Ids, Namespace: req.Namespace, Query: req.Query}
+	if req.OlderThanSeconds > 0 {
+		filter.Before = time.Now().Add(-time.Duration(req.OlderThanSeconds) * time.Second)
+	}
+	if filter.Empty() {
+		http.Error(w, "one of ids, namespace, query or older_than_seconds is required", http.StatusBadRequest)
+		return nil
+	}
+	deleted, err := admin.DeleteEntries(r.Context(), filter)
+	if err != nil {
+		return err
+	}
+	s.ExactCache.Purge()
+	slog.Info("Cache entries deleted", "deleted", deleted, "ids", len(req.Ids), "namespace", req.Namespace, "query", req.Query, "older_than_seconds", req.OlderThanSeconds)
+	return WriteJSON(w, http.StatusOK, deleteCacheResponse{Deleted: deleted})
+}
+
+func (s *AIGateway) DeleteCacheEntry(w http.ResponseWriter, r *http.Request) error {
+	admin, ok := s.cacheAdmin(w)
+	if !ok {
+		return nil
+	}
+	id := r.PathValue("id")
+	if uuid.Validate(id) != nil {
+		http.Error(w, "No cache entry with this id", http.StatusNotFound)
+		return nil
+	}
+	deleted, err := admin.DeleteEntries(r.Context(), cache.DeleteFilter{Ids: []string{id}})
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		http.Error(w, "No cache entry with this id", http.StatusNotFound)
+		return nil
+	}
+	s.ExactCache.Purge()
+	slog.Info("Cache entry deleted", "id", id)
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// UpdateCacheEntry edits the answer of an entry in place, pins it (pinned entries are never expired)
+// and/or quarantines or releases it.
+func (s *AIGateway) UpdateCacheEntry(w http.ResponseWriter, r *http.Request) error {
+	defer r.Body.Close()
+	admin, ok := s.cacheAdmin(w)
+	if !ok {
+		return nil
+	}
+	id := r.PathValue("id")
+	if uuid.Validate(id) != nil {
+		http.Error(w, "No cache entry with this id", http.StatusNotFound)
+		return nil
+	}
+	var update cache.EntryUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return nil
+	}
+	if update.Answer != nil && *update.Answer == "" {
+		http.Error(w, "answer can't be empty, delete the entry instead", http.StatusBadRequest)
+		return nil
+	}
+	entry, err := admin.UpdateEntry(r.Context(), id, update)
+	if errors.Is(err, cache.ErrEntryNotFound) {
+		http.Error(w, "No cache entry with this id", http.StatusNotFound)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	s.ExactCache.Purge()
+	slog.Info("Cache entry updated", "id", id, "answer", update.Answer != nil, "pinned", entry.Pinned, "quarantined", entry.Quarantined)
+	return WriteJSON(w, http.StatusOK, entry)
+}
@@ -0,0 +1,133 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Prateek-Gupta001/AI_Gateway/cache"
+	"github.com/Prateek-Gupta001/AI_Gateway/types"
+)
+
+func newCacheAdminGateway() (*AIGateway, *cache.MemoryCache, *http.ServeMux) {
+	mem := cache.NewMemoryCache(100)
+	gw := NewAIGateway(":0", &fakeStore{}, &fakeLLM{}, mem, fakeEmbed{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /admin/cache/entries", convertToHandleFunc(gw.ListCacheEntries))
+	mux.HandleFunc("GET /admin/cache/entries/{id}", convertToHandleFunc(gw.GetCacheEntry))
+	mux.HandleFunc("PATCH /admin/cache/entries/{id}", convertToHandleFunc(gw.UpdateCacheEntry))
+	mux.HandleFunc("DELETE /admin/cache/entries/{id}", convertToHandleFunc(gw.DeleteCacheEntry))
+	mux.HandleFunc("POST /admin/cache/search", convertToHandleFunc(gw.SearchCache))
+	mux.HandleFunc("POST /admin/cache/delete", convertToHandleFunc(gw.DeleteCacheEntries))
+	return gw, mem, mux
+}
+
+func cachedAnswer(text string) types.LLMResponse {
+	return types.LLMResponse{LLMRes: bytes.NewBufferString(text)}
+}
+
+func serve(mux *http.ServeMux, method string, path string, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return rec
+}
+
+func TestCacheAdminBrowseAndEdit(t *testing.T) {
+	gw, mem, mux := newCacheAdminGateway()
+	ctx := context.Background()
+	goroutine := cache.Key{Namespace: "tenant:acme", Query: "what is a goroutine"}
+	mem.InsertIntoCache(ctx, goroutine, types.Embedding{1, 0, 0}, cachedAnswer("a thread"), time.Hour)
+	mem.InsertIntoCache(ctx, cache.Key{Namespace: "tenant:acme", Query: "what is a channel"}, types.Embedding{0, 1, 0}, cachedAnswer("a pipe"), time.Hour)
+	gw.ExactCache.Put(goroutine, types.CacheResponse{CachedAnswer: "a thread"}, time.Hour)
+
+	rec := serve(mux, http.MethodGet, "/admin/cache/entries?namespace=tenant:acme&limit=1", "")
+	var page cache.EntryPage
+	json.NewDecoder(rec.Body).Decode(&page)
+	if rec.Code != http.StatusOK || len(page.Entries) != 1 || page.NextCursor == "" {
+		t.Fatalf("status %d, page %+v", rec.Code, page)
+	}
+	if rec := serve(mux, http.MethodGet, "/admin/cache/entries?limit=1000", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("limit 1000 got status %d", rec.Code)
+	}
+
+	//fakeEmbed embeds everything as {1, 0, 0}
+	rec = serve(mux, http.MethodPost, "/admin/cache/search", `{"text": "goroutines?", "limit": 2}`)
+	var found []cache.Entry
+	json.NewDecoder(rec.Body).Decode(&found)
+	if rec.Code != http.StatusOK || len(found) != 2 || found[0].Query != "what is a goroutine" || found[0].Score < 0.99 || found[1].Score > 0.01 {
+		t.Fatalf("status %d, search %+v", rec.Code, found)
+	}
+
+	id := found[0].Id
+	rec = serve(mux, http.MethodPatch, "/admin/cache/entries/"+id, `{"answer": "a lightweight thread", "pinned": true}`)
+	var entry cache.Entry
+	json.NewDecoder(rec.Body).Decode(&entry)
+	if rec.Code != http.StatusOK || entry.Answer != "a lightweight thread" || !entry.Pinned {
+		t.Errorf("status %d, entry %+v", rec.Code, entry)
+	}
+	if _, ok := gw.ExactCache.Get(goroutine); ok {
+		t.Error("the exact tier still has the old answer")
+	}
+	rec = serve(mux, http.MethodGet, "/admin/cache/entries/"+id, "")
+	json.NewDecoder(rec.Body).Decode(&entry)
+	if entry.Answer != "a lightweight thread" {
+		t.Errorf("entry after the edit %+v", entry)
+	}
+	if rec := serve(mux, http.MethodPatch, "/admin/cache/entries/"+id, `{"answer": ""}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("empty answer got status %d", rec.Code)
+	}
+	if rec := serve(mux, http.MethodGet, "/admin/cache/entries/6ba7b810-9dad-11d1-80b4-00c04fd430c8", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("missing entry got status %d", rec.Code)
+	}
+}
+
+func TestCacheAdminDelete(t *testing.T) {
+	gw, mem, mux := newCacheAdminGateway()
+	ctx := context.Background()
+	for _, q := range []string{"a", "b"} {
+		mem.InsertIntoCache(ctx, cache.Key{Namespace: "tenant:acme", Query: q}, types.Embedding{1, 0, 0}, cachedAnswer(q), time.Hour)
+		mem.InsertIntoCache(ctx, cache.Key{Namespace: "global", Query: q}, types.Embedding{1, 0, 0}, cachedAnswer(q), time.Hour)
+	}
+	gw.ExactCache.Put(cache.Key{Namespace: "global", Query: "a"}, types.CacheResponse{}, time.Hour)
+
+	if rec := serve(mux, http.MethodPost, "/admin/cache/delete", `{}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("an empty filter got status %d", rec.Code)
+	}
+	if rec := serve(mux, http.MethodPost, "/admin/cache/delete", `{"ids": ["nope"]}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("an invalid id got status %d", rec.Code)
+	}
+	rec := serve(mux, http.MethodPost, "/admin/cache/delete", `{"namespace": "global", "query": "a"}`)
+	var res deleteCacheResponse
+	json.NewDecoder(rec.Body).Decode(&res)
+	if rec.Code != http.StatusOK || res.Deleted != 1 || gw.ExactCache.Len() != 0 {
+		t.Errorf("status %d, deleted %d, exact tier len %d", rec.Code, res.Deleted, gw.ExactCache.Len())
+	}
+	if rec := serve(mux, http.MethodPost, "/admin/cache/delete", `{"older_than_seconds": 3600}`); rec.Code != http.StatusOK || mem.Len() != 3 {
+		t.Errorf("nothing is an hour old yet, status %d, len %d", rec.Code, mem.Len())
+	}
+
+	page, _ := mem.ListEntries(ctx, cache.ListOptions{Namespace: "global", Limit: 10})
+	if rec := serve(mux, http.MethodDelete, "/admin/cache/entries/"+page.Entries[0].Id, ""); rec.Code != http.StatusNoContent {
+		t.Errorf("delete by id got status %d", rec.Code)
+	}
+	if rec := serve(mux, http.MethodDelete, "/admin/cache/entries/"+page.Entries[0].Id, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("deleting it twice got status %d", rec.Code)
+	}
+	if mem.Len() != 2 {
+		t.Errorf("len = %d", mem.Len())
+	}
+}
+
+func TestCacheAdminUnsupportedBackend(t *testing.T) {
+	gw, _, _ := newTestGateway()
+	rec := httptest.NewRecorder()
+	gw.ListCacheEntries(rec, httptest.NewRequest(http.MethodGet, "/admin/cache/entries", nil))
+	if rec.Code != http.StatusNotImplemented {
+		t.Errorf("status %d", rec.Code)
+	}
+}
@@ -0,0 +1,157 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Prateek-Gupta001/AI_Gateway/cache"
+	"github.com/Prateek-Gupta001/AI_Gateway/types"
+)
+
+// cacheThreshold is the threshold the cache is running with.
+func (s *AIGateway) cacheThreshold() float32 {
+	s.cfgMu.RLock()
+	defer s.cfgMu.RUnlock()
+	if s.config.Cache != nil {
+		return s.config.Cache.Threshold
+	}
+	return cache.DefaultThreshold
+}
+
+type calibrateRequest struct {
+	Pairs      []cache.CalibrationPair `json:"pairs"`
+	Thresholds []float32               `json:"thresholds"`
+}
+
+type calibrateResponse struct {
+	CurrentThreshold float32                 `json:"current_threshold"`
+	Current          cache.ThresholdStats    `json:"current"`
+	Best             cache.ThresholdStats    `json:"best"`
+	Thresholds       []cache.ThresholdStats  `json:"thresholds"`
+	Pairs            []cache.CalibrationPair `json:"pairs"`
+}
+
+// CalibrateCache embeds a labeled set of query pairs with the configured embedder and reports
+// the precision and recall of every threshold on it, along with the one running now and the
+// one with the best F1.
+func (s *AIGateway) CalibrateCache(w http.ResponseWriter, r *http.Request) error {
+	defer r.Body.Close()
+	var req calibrateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return nil
+	}
+	if len(req.Pairs) == 0 || len(req.Pairs) > 500 {
+		http.Error(w, "pairs must have between 1 and 500 pairs", http.StatusBadRequest)
+		return nil
+	}
+	for i, p := range req.Pairs {
+		if p.A == "" || p.B == "" {
+			http.Error(w, "pair "+strconv.Itoa(i)+": a and b are required", http.StatusBadRequest)
+			return nil
+		}
+	}
+	if len(req.Thresholds) == 0 {
+		req.Thresholds = cache.CalibrationThresholds()
+	}
+	if err := cache.ValidateThresholds(req.Thresholds); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil
+	}
+
+	embeddings := map[string]types.Embedding{}
+	for _, p := range req.Pairs {
+		for _, text := range []string{p.A, p.B} {
+			if _, ok := embeddings[text]; ok {
+				continue
+			}
+			embedding, ok, err := s.embedOne(r.Context(), text)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				http.Error(w, "The embedding service didn't answer in time", http.StatusServiceUnavailable)
+				return nil
+			}
+			embeddings[text] = embedding
+		}
+	}
+	for i := range req.Pairs {
+		req.Pairs[i].Similarity = embeddings[req.Pairs[i].A].CosineSimilarity(embeddings[req.Pairs[i].B])
+	}
+
+	threshold := s.cacheThreshold()
+	res := calibrateResponse{
+		CurrentThreshold: threshold,
+		Current:          cache.Calibrate(req.Pairs, []float32{threshold})[0],
+		Thresholds:       cache.Calibrate(req.Pairs, req.Thresholds),
+		Pairs:            req.Pairs,
+	}
+	res.Best, _ = cache.BestThreshold(res.Thresholds)
+	return WriteJSON(w, http.StatusOK, res)
+}
+
+// embedOne embeds text with the workers the chat traffic uses. The texts of a calibration go
+// one at a time so they never fill the queue in front of live requests.
+func (s *AIGateway) embedOne(ctx context.Context, text string) (types.Embedding, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	resultChan := make(chan types.EmbeddingResult, 1)
+	go s.embed.SubmitJob(ctx, text, resultChan)
+	select {
+	case result := <-resultChan:
+		return result.Embedding_Result, true, result.Err
+	case <-ctx.Done():
+		return nil, false, nil
+	}
+}
+
+type cacheHitSamplesResponse struct {
+	Threshold float32                `json:"threshold"`
+	Min       float32                `json:"min"`
+	Max       float32                `json:"max"`
+	Samples   []types.CacheHitSample `json:"samples"`
+}
+
+// SampleCacheHits picks production semantic hits whose score was between ?min and ?max for
+// review, by default the ones up to 0.05 over the current threshold, the first to go if it is
+// raised. ?limit= is 50 by default and 500 at most.
+func (s *AIGateway) SampleCacheHits(w http.ResponseWriter, r *http.Request) error {
+	threshold := s.cacheThreshold()
+	res := cacheHitSamplesResponse{Threshold: threshold, Min: threshold}
+	limit := 50
+	for name, dst := range map[string]*float32{"min": &res.Min, "max": &res.Max} {
+		if v := r.URL.Query().Get(name); v != "" {
+			f, err := strconv.ParseFloat(v, 32)
+			if err != nil || f < 0 || f > 1 {
+				http.Error(w, name+" must be between 0 and 1", http.StatusBadRequest)
+				return nil
+			}
+			*dst = float32(f)
+		}
+	}
+	if res.Max == 0 {
+		res.Max = min(res.Min+0.05, 1)
+	}
+	if res.Min > res.Max {
+		http.Error(w, "min can't be over max", http.StatusBadRequest)
+		return nil
+	}
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 500 {
+			http.Error(w, "limit must be between 1 and 500", http.StatusBadRequest)
+			return nil
+		}
+		limit = n
+	}
+	samples, err := s.store.SampleCacheHits(r.Context(), res.Min, res.Max, limit)
+	if err != nil {
+		return err
+	}
+	res.Samples = samples
+	return WriteJSON(w, http.StatusOK, res)
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/Prateek-Gupta001/AI_Gateway/cache"
+	"github.com/Prateek-Gupta001/AI_Gateway/types"
+)
+
+// mapEmbed embeds the texts it knows as given and everything else as {0, 0, 1}.
+type mapEmbed map[string]types.Embedding
+
+func (m mapEmbed) SubmitJob(ctx context.Context, input string, out chan types.EmbeddingResult) {
+	embedding, ok := m[input]
+	if !ok {
+		embedding = types.Embedding{0, 0, 1}
+	}
+	out <- types.EmbeddingResult{Embedding_Result: embedding, Query: input}
+}
+
+func TestCalibrateCache(t *testing.T) {
+	embedder := mapEmbed{
+		"what is a goroutine":        {1, 0, 0},
+		"explain goroutines":         {0.95, 0.31, 0},
+		"what is a channel":          {0.8, 0.6, 0},
+		"how do I close a channel":   {0, 1, 0},
+		"how do you close a channel": {0.1, 0.99, 0},
+	}
+	gw := NewAIGateway(":0", &fakeStore{}, &fakeLLM{}, &fakeCache{}, embedder)
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /admin/cache/calibrate", convertToHandleFunc(gw.CalibrateCache))
+
+	body := `{"thresholds": [0.75, 0.9, 0.99], "pairs": [
+		{"a": "what is a goroutine", "b": "explain goroutines", "same": true},
+		{"a": "how do I close a channel", "b": "how do you close a channel", "same": true},
+		{"a": "what is a goroutine", "b": "what is a channel", "same": false}
+	]}`
+	rec := serve(mux, http.MethodPost, "/admin/cache/calibrate", body)
+	var res calibrateResponse
+	json.NewDecoder(rec.Body).Decode(&res)
+	if rec.Code != http.StatusOK || len(res.Thresholds) != 3 || len(res.Pairs) != 3 {
+		t.Fatalf("status %d, response %+v", rec.Code, res)
+	}
+	//similarities are 0.95, 0.99 and 0.8
+	if s := res.Thresholds[0]; s.TruePositives != 2 || s.FalsePositives != 1 || s.Recall != 1 {
+		t.Errorf("0.75: %+v", s)
+	}
+	if s := res.Thresholds[2]; s.TruePositives != 1 || s.FalseNegatives != 1 || s.Precision != 1 {
+		t.Errorf("0.99: %+v", s)
+	}
+	if res.Best.Threshold != 0.9 || res.Best.F1 != 1 {
+		t.Errorf("best %+v", res.Best)
+	}
+	if res.CurrentThreshold != cache.DefaultThreshold || res.Current.Threshold != cache.DefaultThreshold || res.Current.F1 != 1 {
+		t.Errorf("current %v %+v", res.CurrentThreshold, res.Current)
+	}
+
+	if rec := serve(mux, http.MethodPost, "/admin/cache/calibrate", `{"pairs": []}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("no pairs got status %d", rec.Code)
+	}
+	if rec := serve(mux, http.MethodPost, "/admin/cache/calibrate", `{"pairs": [{"a": "x", "b": "y"}], "thresholds": [1.5]}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("a threshold over 1 got status %d", rec.Code)
+	}
+}
+
+func TestSampleCacheHits(t *testing.T) {
+	st := &fakeStore{}
+	for i, score := range []float32{0.86, 0.88, 0.95, 1} {
+		st.requests = append(st.requests, types.Request{
+			Id:          string(rune('a' + i)),
+			CacheHit:    true,
+			CacheTier:   types.CacheTierSemantic,
+			CacheScore:  score,
+			UserQuery:   "explain goroutines",
+			CachedQuery: "what is a goroutine",
+		})
+	}
+	gw := NewAIGateway(":0", st, &fakeLLM{}, &fakeCache{}, fakeEmbed{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /admin/cache/near-threshold", convertToHandleFunc(gw.SampleCacheHits))
+
+	rec := serve(mux, http.MethodGet, "/admin/cache/near-threshold", "")
+	var res cacheHitSamplesResponse
+	json.NewDecoder(rec.Body).Decode(&res)
+	if rec.Code != http.StatusOK || len(res.Samples) != 2 || res.Samples[0].CachedQuery != "what is a goroutine" {
+		t.Errorf("status %d, response %+v", rec.Code, res)
+	}
+	rec = serve(mux, http.MethodGet, "/admin/cache/near-threshold?min=0.9&max=1&limit=1", "")
+	json.NewDecoder(rec.Body).Decode(&res)
+	if len(res.Samples) != 1 || res.Samples[0].Score != 0.95 {
+		t.Errorf("samples %+v", res.Samples)
+	}
+	if rec := serve(mux, http.MethodGet, "/admin/cache/near-threshold?min=0.9&max=0.8", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("min over max got status %d", rec.Code)
+	}
+}
@@ -0,0 +1,266 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Prateek-Gupta001/AI_Gateway/cache"
+	"github.com/Prateek-Gupta001/AI_Gateway/llm"
+	"github.com/Prateek-Gupta001/AI_Gateway/types"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// errFlightAbandoned is what followers get when the leader returned without landing its
+// flight, they then go ahead on their own.
+var errFlightAbandoned = errors.New("the coalesced request was abandoned")
+
+// flight is one LLM call that identical cache misses share. The leader makes the call and
+// records everything it streams, followers replay it to their own clients as it comes in.
+type flight struct {
+	mu       sync.Mutex
+	deltas   []string
+	usage    *[3]int
+	finish   string
+	done     bool
+	res      *types.LLMResponse
+	err      error
+	changed  chan struct{} //closed and replaced on every update
+	captured bool          //set once the leader has written anything
+	model    string        //the model answering, followers' chunks name it too
+	entryId  string        //the cache entry the leader's answer goes into
+
+	followers int //guarded by the coalescer's mu
+}
+
+func newFlight(entryId string) *flight {
+	return &flight{changed: make(chan struct{}), entryId: entryId}
+}
+
+// update changes the flight under its lock and wakes the followers up.
+func (f *flight) update(fn func()) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fn()
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+// coalescer keeps the flights in progress, keyed by flightKey so only requests that would have
+// shared a cache entry (same namespace, context and normalized query) and made the same call share one.
+type coalescer struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+func newCoalescer() *coalescer {
+	return &coalescer{flights: map[string]*flight{}}
+}
+
+// flightKey is the cache key's hash plus the rest of what goes into the provider call, the level
+// the request was routed at and its generation options.
+func flightKey(key cache.Key, level types.Level, opts types.GenerationOptions) string {
+	temperature := "default"
+	if opts.Temperature != nil {
+		temperature = strconv.FormatFloat(*opts.Temperature, 'g', -1, 64)
+	}
+	return fmt.Sprintf("%s|%s|%d|%s", key.Hash(), level, opts.MaxTokens, temperature)
+}
+
+// join returns the flight for key, and if the caller is its leader. entryId is only used
+// when it starts a new flight.
+func (c *coalescer) join(key string, entryId string) (*flight, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if f, ok := c.flights[key]; ok {
+		f.followers++
+		return f, false
+	}
+	f := newFlight(entryId)
+	c.flights[key] = f
+	return f, true
+}
+
+// land ends the flight. Requests that come in after this go through the cache again.
+func (c *coalescer) land(key string, f *flight, res *types.LLMResponse, err error) {
+	c.mu.Lock()
+	delete(c.flights, key)
+	followers := f.followers
+	c.mu.Unlock()
+	if followers > 0 {
+		slog.Info("Coalesced request landed", "followers", followers, "failed", err != nil)
+	}
+	f.update(func() {
+		f.done, f.res, f.err = true, res, err
+	})
+}
+
+// flightTimeout bounds a shared call once it no longer runs on the leader's request context.
+const flightTimeout = 5 * time.Minute
+
+// followersOf is how many requests are attached to f.
+func (c *coalescer) followersOf(f *flight) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return f.followers
+}
+
+// flightContext is what the leader makes the shared call with. It outlives the leader's client,
+// so the followers still get their answer when it hangs up, and is only cancelled then if
+// nobody is attached.
+func (s *AIGateway) flightContext(ctx context.Context, f *flight) (context.Context, func()) {
+	callCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), flightTimeout)
+	stop := context.AfterFunc(ctx, func() {
+		if s.coalescer.followersOf(f) == 0 {
+			cancel()
+		}
+	})
+	return callCtx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// teeWriter is the leader's StreamWriter, everything it writes is also recorded on the flight.
+// When the leader's client goes away the recording carries on for the followers.
+type teeWriter struct {
+	llm.StreamWriter
+	flight    *flight
+	coalescer *coalescer
+	gone      bool //the leader's writer failed, only the flight is written to
+}
+
+func (t *teeWriter) WriteDelta(text string) error {
+	t.flight.update(func() {
+		t.flight.deltas = append(t.flight.deltas, text)
+		t.flight.captured = true
+	})
+	if t.gone {
+		return nil
+	}
+	err := t.StreamWriter.WriteDelta(text)
+	if err != nil && t.coalescer.followersOf(t.flight) > 0 {
+		slog.Info("The coalesced request's client is gone, finishing the call for the others", "error", err)
+		t.gone = true
+		return nil
+	}
+	return err
+}
+
+func (t *teeWriter) WriteUsage(inputTokens, outputTokens, totalTokens int) {
+	t.flight.update(func() {
+		t.flight.usage = &[3]int{inputTokens, outputTokens, totalTokens}
+		t.flight.captured = true
+	})
+	if !t.gone {
+		t.StreamWriter.WriteUsage(inputTokens, outputTokens, totalTokens)
+	}
+}
+
+func (t *teeWriter) WriteFinish(reason string) {
+	t.flight.update(func() {
+		t.flight.finish = reason
+		t.flight.captured = true
+	})
+	if !t.gone {
+		t.StreamWriter.WriteFinish(reason)
+	}
+}
+
+func (t *teeWriter) SetModel(model string) {
+	t.flight.update(func() {
+		t.flight.model = model
+	})
+	if ms, ok := t.StreamWriter.(llm.ModelSetter); ok {
+		ms.SetModel(model)
+	}
+}
+
+// Started is true once the flight has recorded anything, even when the leader's own writer
+// only collects. Falling back to another model then would stream the followers two answers.
+func (t *teeWriter) Started() bool {
+	t.flight.mu.Lock()
+	captured := t.flight.captured
+	t.flight.mu.Unlock()
+	return captured || t.StreamWriter.Started()
+}
+
+// follow replays the leader's stream to sw as it comes in. It reports false when the leader
+// failed before writing anything, the follower then has to make the call itself.
+func (s *AIGateway) follow(ctx context.Context, f *flight, sw llm.StreamWriter, request types.Request, start time.Time) (*chatResult, bool, error) {
+	sent := 0
+	for {
+		f.mu.Lock()
+		deltas, done, changed := f.deltas[sent:], f.done, f.changed
+		res, err, usage, finish, captured, model := f.res, f.err, f.usage, f.finish, f.captured, f.model
+		f.mu.Unlock()
+
+		if ms, ok := sw.(llm.ModelSetter); ok && model != "" {
+			ms.SetModel(model)
+		}
+
+		for _, d := range deltas {
+			if werr := sw.WriteDelta(d); werr != nil {
+				return nil, true, werr
+			}
+		}
+		sent += len(deltas)
+		if done {
+			if err != nil {
+				if !captured {
+					slog.Info("The coalesced request failed before answering, going on alone", "error", err)
+					return nil, false, nil
+				}
+				return nil, true, err
+			}
+			if usage != nil {
+				sw.WriteUsage(usage[0], usage[1], usage[2])
+			}
+			sw.WriteFinish(finish)
+			return s.coalescedHit(ctx, request, res, start), true, nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, true, ctx.Err()
+		}
+	}
+}
+
+// coalescedHit records a follower like a cache hit, the provider call was paid for by the leader.
+// The tokens still count against the follower's own rate limits, or identical requests would be
+// a way around them.
+func (s *AIGateway) coalescedHit(ctx context.Context, request types.Request, leader *types.LLMResponse, start time.Time) *chatResult {
+	answer := leader.LLMRes.String()
+	s.RateLimiter.ChargeTokens(subjectsFromContext(ctx), leader.TotalTokens)
+	store_ctx := context.WithValue(context.Background(), types.UserIdKey, request.UserId)
+	s.store.SubmitInsertRequest(store_ctx, types.Request{
+		Id:           request.Id,
+		Cacheable:    request.Cacheable,
+		UserId:       request.UserId,
+		LLMResponse:  answer,
+		UserQuery:    request.UserQuery,
+		InputTokens:  leader.InputTokens,
+		OutputTokens: leader.OutputTokens,
+		TotalToken:   leader.TotalTokens,
+		Time:         time.Since(start),
+		Model:        leader.Model,
+		CacheHit:     true,
+		CacheTier:    types.CacheTierCoalesced,
+		CacheEntryId: request.CacheEntryId,
+		Level:        leader.Level,
+	})
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.Bool("cachehit", true),
+		attribute.String("cache_tier", string(types.CacheTierCoalesced)),
+	)
+	res := *leader
+	res.LLMRes = bytes.NewBufferString(answer)
+	return &chatResult{Response: &res}
+}
@@ -0,0 +1,283 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Prateek-Gupta001/AI_Gateway/llm"
+	"github.com/Prateek-Gupta001/AI_Gateway/types"
+)
+
+// gatedLLM writes its first delta and then waits for release, so tests can line requests up
+// behind it. The calls listed in fail return an error before writing anything.
+type gatedLLM struct {
+	fakeLLM
+	calls   atomic.Int32
+	started chan struct{}
+	release chan struct{}
+	fail    map[int32]bool
+}
+
+func (g *gatedLLM) GenerateResponse(ctx context.Context, sw llm.StreamWriter, messages []types.Messages, opts types.GenerationOptions, level types.Level, res *types.LLMResponse) error {
+	call := g.calls.Add(1)
+	g.started <- struct{}{}
+	<-g.release
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if g.fail[call] {
+		return errors.New("provider down")
+	}
+	res.LLMRes = new(bytes.Buffer)
+	for _, a := range []string{"Hello", " world"} {
+		if err := sw.WriteDelta(a); err != nil {
+			return err
+		}
+		res.LLMRes.WriteString(a)
+	}
+	res.InputTokens, res.OutputTokens, res.TotalTokens = 3, 4, 7
+	sw.WriteUsage(3, 4, 7)
+	sw.WriteFinish(llm.FinishStop)
+	res.Model = "fake"
+	res.Level = level
+	return nil
+}
+
+func newGatedGateway(fail map[int32]bool) (*AIGateway, *fakeStore, *gatedLLM) {
+	st := &fakeStore{}
+	g := &gatedLLM{started: make(chan struct{}, 8), release: make(chan struct{}), fail: fail}
+	gw := NewAIGateway(":0", st, g, &fakeCache{inserted: make(chan string, 8)}, fakeEmbed{})
+	return gw, st, g
+}
+
+// waitForFollowers blocks until n requests are attached to the only flight in progress.
+func waitForFollowers(t *testing.T, gw *AIGateway, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		gw.coalescer.mu.Lock()
+		followers := 0
+		for _, f := range gw.coalescer.flights {
+			followers = f.followers
+		}
+		gw.coalescer.mu.Unlock()
+		if followers >= n {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("%d followers never joined", n)
+}
+
+func TestChatCoalescesIdenticalMisses(t *testing.T) {
+	gw, st, g := newGatedGateway(nil)
+	body := `{"stream": false, "messages": [{"role": "user", "content": "what is a goroutine"}]}`
+
+	var wg sync.WaitGroup
+	recs := make([]int, 3)
+	answers := make([]string, 3)
+	run := func(i int) {
+		defer wg.Done()
+		rec := postChat(t, gw, "/chat", body)
+		recs[i] = rec.Code
+		var res types.ChatResponse
+		json.NewDecoder(rec.Body).Decode(&res)
+		answers[i] = res.Response
+	}
+	wg.Add(1)
+	go run(0)
+	<-g.started
+	wg.Add(2)
+	go run(1)
+	go run(2)
+	waitForFollowers(t, gw, 2)
+	close(g.release)
+	wg.Wait()
+
+	if n := g.calls.Load(); n != 1 {
+		t.Errorf("provider was called %d times", n)
+	}
+	for i := range recs {
+		if recs[i] != http.StatusOK || answers[i] != "Hello world" {
+			t.Errorf("request %d: status %d, body %q", i, recs[i], answers[i])
+		}
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	coalesced := 0
+	for _, r := range st.requests {
+		if r.CacheTier == types.CacheTierCoalesced {
+			coalesced++
+			if !r.CacheHit || r.TotalToken != 7 || r.Model != "fake" || r.UserQuery != "what is a goroutine" {
+				t.Errorf("coalesced request recorded as %+v", r)
+			}
+		}
+	}
+	if coalesced != 2 {
+		t.Errorf("%d coalesced requests recorded, requests = %+v", coalesced, st.requests)
+	}
+}
+
+func TestChatCoalescedLeaderFailure(t *testing.T) {
+	gw, _, g := newGatedGateway(map[int32]bool{1: true})
+	body := `{"stream": false, "messages": [{"role": "user", "content": "what is a goroutine"}]}`
+
+	var wg sync.WaitGroup
+	var leaderErr error
+	var followerCode int
+	var followerBody string
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		req := httptest.NewRequest(http.MethodPost, "/chat", strings.NewReader(body))
+		req.Header.Set("userId", "u1")
+		leaderErr = gw.Chat(httptest.NewRecorder(), req)
+	}()
+	<-g.started
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		rec := postChat(t, gw, "/chat", body)
+		followerCode, followerBody = rec.Code, rec.Body.String()
+	}()
+	waitForFollowers(t, gw, 1)
+	g.release <- struct{}{}
+	//the follower makes its own call once the leader failed without writing anything
+	<-g.started
+	close(g.release)
+	wg.Wait()
+
+	if leaderErr == nil {
+		t.Error("the leader should have failed")
+	}
+	if n := g.calls.Load(); n != 2 {
+		t.Errorf("provider was called %d times", n)
+	}
+	if followerCode != http.StatusOK || !strings.Contains(followerBody, "Hello world") {
+		t.Errorf("follower got %d %q", followerCode, followerBody)
+	}
+}
+
+func TestChatCoalescedLeaderHangsUp(t *testing.T) {
+	gw, _, g := newGatedGateway(nil)
+	body := `{"stream": false, "messages": [{"role": "user", "content": "what is a goroutine"}]}`
+
+	var wg sync.WaitGroup
+	leaderCtx, hangUp := context.WithCancel(context.Background())
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		req := httptest.NewRequest(http.MethodPost, "/chat", strings.NewReader(body)).WithContext(leaderCtx)
+		req.Header.Set("userId", "u1")
+		gw.Chat(httptest.NewRecorder(), req)
+	}()
+	<-g.started
+	var followerCode int
+	var followerBody string
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		rec := postChat(t, gw, "/chat", body)
+		followerCode, followerBody = rec.Code, rec.Body.String()
+	}()
+	waitForFollowers(t, gw, 1)
+	hangUp()
+	close(g.release)
+	wg.Wait()
+
+	if n := g.calls.Load(); n != 1 {
+		t.Errorf("provider was called %d times", n)
+	}
+	if followerCode != http.StatusOK || !strings.Contains(followerBody, "Hello world") {
+		t.Errorf("follower got %d %q", followerCode, followerBody)
+	}
+}
+
+func TestTeeWriterStarted(t *testing.T) {
+	f := newFlight("entry")
+	tw := &teeWriter{StreamWriter: &llm.CollectWriter{}, flight: f, coalescer: newCoalescer()}
+	if tw.Started() {
+		t.Fatal("nothing was written yet")
+	}
+	//a non streaming leader's writer never starts, but the followers already got this
+	tw.WriteDelta("Hel")
+	if !tw.Started() {
+		t.Error("falling back to another model now would stream the followers two answers")
+	}
+}
+
+// limitedChat posts body to /chat as userId, counted against the user's rate limit bucket like
+// the RateLimit middleware would.
+func limitedChat(t *testing.T, gw *AIGateway, userId string, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/chat", strings.NewReader(body))
+	req.Header.Set("userId", userId)
+	req = req.WithContext(context.WithValue(req.Context(), rateLimitCtxKey{}, []string{"user:" + userId}))
+	rec := httptest.NewRecorder()
+	if err := gw.Chat(rec, req); err != nil {
+		t.Fatalf("Chat returned %v", err)
+	}
+	return rec
+}
+
+func TestChatCoalescingChecksFollowers(t *testing.T) {
+	gw, st, g := newGatedGateway(nil)
+	limiter, _ := newTestLimiter(RateLimitConfig{TokensPerMinute: 100})
+	gw.RateLimiter = limiter
+	body := `{"stream": false, "cache_scope": "global", "messages": [{"role": "user", "content": "what is a goroutine"}]}`
+
+	var wg sync.WaitGroup
+	codes := make([]int, 3)
+	run := func(i int, userId string, body string) {
+		defer wg.Done()
+		codes[i] = limitedChat(t, gw, userId, body).Code
+	}
+	wg.Add(1)
+	go run(0, "u1", body)
+	<-g.started
+
+	//a user over their budget is turned away instead of riding along on someone else's call
+	st.mu.Lock()
+	st.budget = types.BudgetStatus{
+		Budget: types.Budget{DailyTokens: 100, Action: types.BudgetReject},
+		Daily:  types.BudgetUsage{Tokens: 100},
+	}
+	st.mu.Unlock()
+	if rec := limitedChat(t, gw, "u2", body); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("over budget follower: status %d", rec.Code)
+	}
+	st.mu.Lock()
+	st.budget = types.BudgetStatus{}
+	st.mu.Unlock()
+
+	wg.Add(1)
+	go run(1, "u3", body)
+	waitForFollowers(t, gw, 1)
+	//other generation options are another call
+	wg.Add(1)
+	go run(2, "u4", `{"stream": false, "cache_scope": "global", "max_tokens": 10, "messages": [{"role": "user", "content": "what is a goroutine"}]}`)
+	<-g.started
+	close(g.release)
+	wg.Wait()
+
+	if n := g.calls.Load(); n != 2 {
+		t.Errorf("provider was called %d times", n)
+	}
+	for i, code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("request %d: status %d", i, code)
+		}
+	}
+	if d := limiter.Allow([]string{"user:u3"}); d.RemainingTokens != 93 {
+		t.Errorf("the follower's tokens weren't charged, %+v", d)
+	}
+}
@@ -0,0 +1,345 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/Prateek-Gupta001/AI_Gateway/cache"
+	"github.com/Prateek-Gupta001/AI_Gateway/classifier"
+	"github.com/Prateek-Gupta001/AI_Gateway/llm"
+)
+
+// GatewayConfig is the file GATEWAY_CONFIG points to (see gateway.example.json). It can be reloaded
+// while the gateway is running, either by editing the file or with POST /admin/config/reload.
+// Sections left out of the file are left as they are.
+type GatewayConfig struct {
+	Models          *llm.Registry             `json:"models,omitempty"`
+	Cache           *CacheConfig              `json:"cache,omitempty"`
+	CacheReplay     *CacheReplayFileConfig    `json:"cache_replay,omitempty"`
+	Classifier      *classifier.Config        `json:"classifier,omitempty"`
+	TimeSensitivity *classifier.DynamicConfig `json:"time_sensitivity,omitempty"`
+	RateLimits      *RateLimitConfig          `json:"rate_limits,omitempty"`
+}
+
+type CacheConfig struct {
+	// Threshold is the minimum similarity score for a cache hit.
+	Threshold float32 `json:"threshold"`
+	// ContextDepth is how many earlier turns of a conversation are part of its cache key.
+	ContextDepth int `json:"context_depth,omitempty"`
+	// TTL is how long answers are cached. When it is left out the running policy stays, which
+	// is CACHE_TTL_SECONDS, or a day for all of them, until a file sets one.
+	TTL *cache.TTLPolicy `json:"ttl,omitempty"`
+	// Quarantine is when thumbs down take an entry out. When it is left out the running policy
+	// stays, which is half of at least 3 votes until a file sets one.
+	Quarantine *cache.QuarantinePolicy `json:"quarantine,omitempty"`
+}
+
+type CacheReplayFileConfig struct {
+	Stream     bool `json:"stream"`
+	ChunkWords int  `json:"chunk_words"`
+	DelayMs    int  `json:"delay_ms"`
+}
+
+func LoadGatewayConfig(path string) (GatewayConfig, error) {
+	var cfg GatewayConfig
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	//a typo in a key would otherwise silently leave the old value in place
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		return cfg, fmt.Errorf("could not parse gateway config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// gatewaySettings are the parts of the AIGateway that can be swapped by a reload. Requests
+// take a copy when they start, so a reload never changes things under a running stream.
+type gatewaySettings struct {
+	CacheReplay       CacheReplayConfig
+	CacheContextDepth int
+	CacheTTL          cache.TTLPolicy
+	CacheQuarantine   cache.QuarantinePolicy
+	Classifier        classifier.Classifier
+	TimeSensitivity   *classifier.DynamicDetector
+}
+
+func (s *AIGateway) settings() gatewaySettings {
+	s.cfgMu.RLock()
+	defer s.cfgMu.RUnlock()
+	return gatewaySettings{
+		CacheReplay:       s.CacheReplay,
+		CacheContextDepth: s.CacheContextDepth,
+		CacheTTL:          s.CacheTTL,
+		CacheQuarantine:   s.CacheQuarantine,
+		Classifier:        s.Classifier,
+		TimeSensitivity:   s.TimeSensitivity,
+	}
+}
+
+// ApplyConfig validates the whole config first and only then swaps it in, so a bad file never
+// leaves the gateway half reloaded. It returns what changed.
+func (s *AIGateway) ApplyConfig(cfg GatewayConfig) ([]string, error) {
+	var errs []error
+	if cfg.Models != nil {
+		if err := cfg.Models.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("models: %w", err))
+		}
+	}
+	if cfg.Cache != nil && (cfg.Cache.Threshold <= 0 || cfg.Cache.Threshold > 1) {
+		errs = append(errs, fmt.Errorf("cache: threshold must be in (0, 1], got %v", cfg.Cache.Threshold))
+	}
+	if cfg.Cache != nil && cfg.Cache.ContextDepth < 0 {
+		errs = append(errs, errors.New("cache: context_depth can't be negative"))
+	}
+	if cfg.Cache != nil && cfg.Cache.TTL != nil {
+		if err := cfg.Cache.TTL.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("cache: ttl: %w", err))
+		}
+	}
+	if cfg.Cache != nil && cfg.Cache.Quarantine != nil {
+		if err := cfg.Cache.Quarantine.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("cache: quarantine: %w", err))
+		}
+	}
+	if cfg.CacheReplay != nil && (cfg.CacheReplay.ChunkWords < 0 || cfg.CacheReplay.DelayMs < 0) {
+		errs = append(errs, errors.New("cache_replay: chunk_words and delay_ms can't be negative"))
+	}
+	if cfg.RateLimits != nil {
+		if err := cfg.RateLimits.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("rate_limits: %w", err))
+		}
+	}
+	var newClassifier classifier.Classifier
+	//not the request context, the embedding classifier keeps embedding its examples after we
+	//return. It is cancelled when the config doesn't apply or the classifier is replaced.
+	classifierCtx, cancelClassifier := context.WithCancel(context.Background())
+	if cfg.Classifier != nil {
+		c, err := classifier.New(classifierCtx, *cfg.Classifier, s.embed)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("classifier: %w", err))
+		}
+		newClassifier = c
+	}
+	var newDetector *classifier.DynamicDetector
+	if cfg.TimeSensitivity != nil {
+		d, err := classifier.NewDynamicDetector(*cfg.TimeSensitivity)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("time_sensitivity: %w", err))
+		}
+		newDetector = d
+	}
+	if err := errors.Join(errs...); err != nil {
+		cancelClassifier()
+		return nil, err
+	}
+
+	s.cfgMu.Lock()
+	defer s.cfgMu.Unlock()
+	old := s.config
+	if cfg.Models != nil {
+		if err := s.llms.Reload(*cfg.Models); err != nil {
+			cancelClassifier()
+			return nil, fmt.Errorf("models: %w", err)
+		}
+	} else {
+		cfg.Models = old.Models
+	}
+	if cfg.Cache != nil {
+		s.cache.SetThreshold(cfg.Cache.Threshold)
+		s.CacheContextDepth = cfg.Cache.ContextDepth
+		//ttl and quarantine are sections of their own, leaving one out keeps what is running
+		merged := *cfg.Cache
+		if merged.TTL != nil {
+			s.CacheTTL = *merged.TTL
+			s.cache.SetSliding(s.CacheTTL.Sliding)
+		} else if old.Cache != nil {
+			merged.TTL = old.Cache.TTL
+		}
+		if merged.Quarantine != nil {
+			s.CacheQuarantine = *merged.Quarantine
+		} else if old.Cache != nil {
+			merged.Quarantine = old.Cache.Quarantine
+		}
+		cfg.Cache = &merged
+	} else {
+		cfg.Cache = old.Cache
+	}
+	if cfg.CacheReplay != nil {
+		s.CacheReplay = CacheReplayConfig{
+			Stream:     cfg.CacheReplay.Stream,
+			ChunkWords: cfg.CacheReplay.ChunkWords,
+			Delay:      time.Duration(cfg.CacheReplay.DelayMs) * time.Millisecond,
+		}
+	} else {
+		cfg.CacheReplay = old.CacheReplay
+	}
+	if newClassifier != nil {
+		if s.cancelClassifier != nil {
+			s.cancelClassifier()
+		}
+		s.Classifier = newClassifier
+		s.cancelClassifier = cancelClassifier
+	} else {
+		cancelClassifier()
+		cfg.Classifier = old.Classifier
+	}
+	if newDetector != nil {
+		s.TimeSensitivity = newDetector
+	} else {
+		cfg.TimeSensitivity = old.TimeSensitivity
+	}
+	if cfg.RateLimits != nil {
+		s.RateLimiter.SetConfig(*cfg.RateLimits)
+	} else {
+		cfg.RateLimits = old.RateLimits
+	}
+	s.config = cfg
+	return diffConfig(old, cfg), nil
+}
+
+// LoadConfigFile applies the config file at path and remembers it for later reloads.
+func (s *AIGateway) LoadConfigFile(path string) error {
+	s.cfgMu.Lock()
+	s.configPath = path
+	s.cfgMu.Unlock()
+	_, err := s.reloadConfigFile()
+	return err
+}
+
+func (s *AIGateway) reloadConfigFile() ([]string, error) {
+	s.cfgMu.RLock()
+	path := s.configPath
+	s.cfgMu.RUnlock()
+	if path == "" {
+		return nil, errors.New("no config file set, start the gateway with GATEWAY_CONFIG")
+	}
+	cfg, err := LoadGatewayConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	changes, err := s.ApplyConfig(cfg)
+	if err != nil {
+		slog.Error("Config was not reloaded, the old one is still in use", "path", path, "error", err)
+		return nil, err
+	}
+	for _, change := range changes {
+		slog.Info("Config changed", "change", change)
+	}
+	slog.Info("Config reloaded", "path", path, "changes", len(changes))
+	return changes, nil
+}
+
+// WatchConfig reloads the config file whenever its modification time changes. A file that
+// doesn't validate is logged and skipped, the running config stays in place.
+func (s *AIGateway) WatchConfig(ctx context.Context, interval time.Duration) {
+	s.cfgMu.RLock()
+	path := s.configPath
+	s.cfgMu.RUnlock()
+	var lastMod time.Time
+	if info, err := os.Stat(path); err == nil {
+		lastMod = info.ModTime()
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			info, err := os.Stat(path)
+			if err != nil {
+				slog.Error("Got this error while trying to stat the config file", "path", path, "error", err)
+				continue
+			}
+			if info.ModTime().Equal(lastMod) {
+				continue
+			}
+			lastMod = info.ModTime()
+			slog.Info("Config file changed, reloading", "path", path)
+			s.reloadConfigFile()
+		}
+	}
+}
+
+type reloadConfigResponse struct {
+	Changes []string `json:"changes"`
+}
+
+// ReloadConfig re-reads the config file and swaps it in. Nothing is changed when it doesn't validate.
+func (s *AIGateway) ReloadConfig(w http.ResponseWriter, r *http.Request) error {
+	changes, err := s.reloadConfigFile()
+	if err != nil {
+		return WriteJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if changes == nil {
+		changes = []string{}
+	}
+	return WriteJSON(w, http.StatusOK, reloadConfigResponse{Changes: changes})
+}
+
+// diffConfig flattens both configs into json paths and lists every path whose value changed.
+func diffConfig(old GatewayConfig, new GatewayConfig) []string {
+	before, after := flattenConfig(old), flattenConfig(new)
+	var changes []string
+	for path, a := range after {
+		b, ok := before[path]
+		switch {
+		case !ok:
+			changes = append(changes, fmt.Sprintf("%s: added %s", path, a))
+		case a != b:
+			changes = append(changes, fmt.Sprintf("%s: %s -> %s", path, b, a))
+		}
+	}
+	for path, b := range before {
+		if _, ok := after[path]; !ok {
+			changes = append(changes, fmt.Sprintf("%s: removed %s", path, b))
+		}
+	}
+	sort.Strings(changes)
+	return changes
+}
+
+func flattenConfig(cfg GatewayConfig) map[string]string {
+	out := map[string]string{}
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return out
+	}
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return out
+	}
+	flatten("", v, out)
+	return out
+}
+
+func flatten(prefix string, v any, out map[string]string) {
+	switch t := v.(type) {
+	case map[string]any:
+		for k, child := range t {
+			path := k
+			if prefix != "" {
+				path = prefix + "." + k
+			}
+			flatten(path, child, out)
+		}
+	case []any:
+		for i, child := range t {
+			flatten(fmt.Sprintf("%s[%d]", prefix, i), child, out)
+		}
+	default:
+		data, _ := json.Marshal(t)
+		out[prefix] = string(data)
+	}
+}
@@ -0,0 +1,193 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Prateek-Gupta001/AI_Gateway/cache"
+	"github.com/Prateek-Gupta001/AI_Gateway/classifier"
+	"github.com/Prateek-Gupta001/AI_Gateway/types"
+)
+
+func writeConfig(t *testing.T, path string, cfg string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(cfg), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	gw, _, c := newTestGateway()
+	path := filepath.Join(t.TempDir(), "gateway.json")
+	writeConfig(t, path, `{
+		"cache": {"threshold": 0.9},
+		"cache_replay": {"stream": true, "chunk_words": 2, "delay_ms": 5},
+		"models": {"models": [{"name": "mock", "provider": "mock", "levels": ["easy"]}]}
+	}`)
+	if err := gw.LoadConfigFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if c.threshold != 0.9 {
+		t.Errorf("threshold = %v", c.threshold)
+	}
+	if replay := gw.settings().CacheReplay; !replay.Stream || replay.ChunkWords != 2 || replay.Delay != 5*time.Millisecond {
+		t.Errorf("cache replay = %+v", replay)
+	}
+	if len(gw.llms.(*fakeLLM).reloaded) != 1 {
+		t.Errorf("models were not reloaded")
+	}
+
+	//only the threshold changes, the rest of the sections are left out and stay as they are
+	writeConfig(t, path, `{"cache": {"threshold": 0.8}}`)
+	rec := httptest.NewRecorder()
+	if err := gw.ReloadConfig(rec, httptest.NewRequest(http.MethodPost, "/admin/config/reload", nil)); err != nil {
+		t.Fatal(err)
+	}
+	var res reloadConfigResponse
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK || len(res.Changes) != 1 || !strings.HasPrefix(res.Changes[0], "cache.threshold: 0.9 -> 0.8") {
+		t.Errorf("status %d, changes %v", rec.Code, res.Changes)
+	}
+	if !gw.settings().CacheReplay.Stream {
+		t.Errorf("cache replay should have been left alone")
+	}
+}
+
+func TestReloadConfigInvalid(t *testing.T) {
+	gw, _, c := newTestGateway()
+	path := filepath.Join(t.TempDir(), "gateway.json")
+	writeConfig(t, path, `{"cache": {"threshold": 0.9}}`)
+	if err := gw.LoadConfigFile(path); err != nil {
+		t.Fatal(err)
+	}
+	before := gw.settings().Classifier
+
+	//a valid classifier next to a broken model list, nothing may be applied
+	writeConfig(t, path, `{
+		"cache": {"threshold": 0.7},
+		"classifier": {"type": "heuristic"},
+		"models": {"models": [{"name": "x", "provider": "cohere", "model": "command", "levels": ["easy"]}]}
+	}`)
+	rec := httptest.NewRecorder()
+	if err := gw.ReloadConfig(rec, httptest.NewRequest(http.MethodPost, "/admin/config/reload", nil)); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "cohere") {
+		t.Errorf("status %d, body %s", rec.Code, rec.Body.String())
+	}
+	if c.threshold != 0.9 || gw.settings().Classifier != before {
+		t.Errorf("a bad config was partly applied")
+	}
+
+	writeConfig(t, path, `{"cache": {"treshold": 0.7}}`)
+	if _, err := gw.reloadConfigFile(); err == nil || !strings.Contains(err.Error(), "treshold") {
+		t.Errorf("unknown keys should be rejected, got %v", err)
+	}
+}
+
+// waitingEmbed never answers, it reports when the job's context is cancelled.
+type waitingEmbed struct{ cancelled chan struct{} }
+
+func (e waitingEmbed) SubmitJob(ctx context.Context, input string, out chan types.EmbeddingResult) {
+	<-ctx.Done()
+	e.cancelled <- struct{}{}
+}
+
+func TestReloadConfigCancelsClassifier(t *testing.T) {
+	gw, _, _ := newTestGateway()
+	embed := waitingEmbed{cancelled: make(chan struct{}, 1)}
+	gw.embed = embed
+	embedding := &classifier.Config{Type: "embedding", Embedding: &classifier.EmbeddingConfig{
+		Examples: []classifier.Example{{Text: "what is a goroutine", Level: types.Easy}},
+	}}
+	expectCancel := func(what string) {
+		t.Helper()
+		select {
+		case <-embed.cancelled:
+		case <-time.After(time.Second):
+			t.Fatalf("%s: the classifier's examples are still being embedded", what)
+		}
+	}
+
+	if _, err := gw.ApplyConfig(GatewayConfig{Classifier: embedding, Cache: &CacheConfig{Threshold: 2}}); err == nil {
+		t.Fatal("expected the threshold to be rejected")
+	}
+	expectCancel("invalid config")
+
+	if _, err := gw.ApplyConfig(GatewayConfig{Classifier: embedding}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gw.ApplyConfig(GatewayConfig{Classifier: &classifier.Config{Type: "heuristic"}}); err != nil {
+		t.Fatal(err)
+	}
+	expectCancel("replaced classifier")
+}
+
+func TestDiffConfig(t *testing.T) {
+	old := GatewayConfig{Cache: &CacheConfig{Threshold: 0.85}}
+	new := GatewayConfig{CacheReplay: &CacheReplayFileConfig{Stream: true}}
+	changes := diffConfig(old, new)
+	want := []string{
+		"cache.threshold: removed 0.85",
+		"cache_replay.chunk_words: added 0",
+		"cache_replay.delay_ms: added 0",
+		"cache_replay.stream: added true",
+	}
+	if strings.Join(changes, "\n") != strings.Join(want, "\n") {
+		t.Errorf("changes = %v", changes)
+	}
+}
+
+func TestReloadConfigCacheTTL(t *testing.T) {
+	gw, _, c := newTestGateway()
+	_, err := gw.ApplyConfig(GatewayConfig{Cache: &CacheConfig{
+		Threshold: 0.85,
+		TTL: &cache.TTLPolicy{
+			DefaultSeconds: 3600,
+			Rules:          []cache.TTLRule{{Model: "fake", Level: types.Easy, TTLSeconds: 120}},
+			Sliding:        true,
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.sliding {
+		t.Error("sliding expiration wasn't passed on to the cache")
+	}
+	postChat(t, gw, "/chat", `{"stream": false, "messages": [{"role": "user", "content": "what is a goroutine"}]}`)
+	<-c.inserted
+	if c.ttl != 2*time.Minute {
+		t.Errorf("ttl = %v", c.ttl)
+	}
+
+	_, err = gw.ApplyConfig(GatewayConfig{Cache: &CacheConfig{
+		Threshold: 0.85,
+		TTL:       &cache.TTLPolicy{Rules: []cache.TTLRule{{Level: "extreme", TTLSeconds: 60}}},
+	}})
+	if err == nil || !strings.Contains(err.Error(), "cache: ttl") {
+		t.Errorf("invalid ttl policy got %v", err)
+	}
+	//a cache section without a ttl or quarantine leaves the running ones alone
+	if _, err := gw.ApplyConfig(GatewayConfig{Cache: &CacheConfig{Threshold: 0.85, Quarantine: &cache.QuarantinePolicy{MinVotes: 5}}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gw.ApplyConfig(GatewayConfig{Cache: &CacheConfig{Threshold: 0.8}}); err != nil {
+		t.Fatal(err)
+	}
+	settings := gw.settings()
+	if !c.sliding || settings.CacheTTL.TTL("", "fake", types.Easy) != 2*time.Minute || settings.CacheQuarantine.MinVotes != 5 {
+		t.Errorf("ttl or quarantine policy was reset, sliding = %v, quarantine = %+v", c.sliding, settings.CacheQuarantine)
+	}
+	if gw.config.Cache.TTL == nil || gw.config.Cache.Quarantine == nil {
+		t.Errorf("the kept policies should stay in the config for the next diff")
+	}
+}
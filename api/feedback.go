@@ -0,0 +1,121 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/Prateek-Gupta001/AI_Gateway/cache"
+	"github.com/Prateek-Gupta001/AI_Gateway/store"
+	"github.com/Prateek-Gupta001/AI_Gateway/types"
+	"github.com/google/uuid"
+)
+
+type feedbackRequest struct {
+	RequestId string `json:"request_id"`
+	Rating    string `json:"rating"` //up, down, or none to take a vote back
+}
+
+type feedbackResponse struct {
+	RequestId    string `json:"request_id"`
+	Rating       string `json:"rating"`
+	CacheEntryId string `json:"cache_entry_id,omitempty"`
+	// Action is "quarantined" or "evicted" when this vote took the cache entry out.
+	Action string `json:"action,omitempty"`
+}
+
+var ratings = map[string]types.Feedback{
+	"up":   types.FeedbackUp,
+	"down": types.FeedbackDown,
+	"none": types.FeedbackNone,
+}
+
+// Feedback records a thumbs up or down on one of the caller's own requests, /v1 completion ids
+// work too. A vote on an answer that came from or went into the cache counts on that entry, and
+// enough thumbs down take it out of the cache as CacheQuarantine says.
+func (s *AIGateway) Feedback(w http.ResponseWriter, r *http.Request) error {
+	defer r.Body.Close()
+	var req feedbackRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return nil
+	}
+	requestId := strings.TrimPrefix(req.RequestId, "chatcmpl-")
+	if uuid.Validate(requestId) != nil {
+		http.Error(w, "Invalid request_id", http.StatusBadRequest)
+		return nil
+	}
+	feedback, ok := ratings[req.Rating]
+	if !ok {
+		http.Error(w, `rating must be "up", "down" or "none"`, http.StatusBadRequest)
+		return nil
+	}
+	previous, entryId, err := s.store.SetFeedback(r.Context(), requestId, userIdFromRequest(r), feedback)
+	if errors.Is(err, store.ErrRequestNotFound) {
+		//requests are written in the background, one that just finished may not be there yet
+		http.Error(w, "No request with this id", http.StatusNotFound)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	res := feedbackResponse{RequestId: req.RequestId, Rating: req.Rating, CacheEntryId: entryId}
+	if entryId != "" && previous != feedback {
+		res.Action = s.recordCacheFeedback(r.Context(), entryId, previous, feedback)
+	}
+	slog.Info("Feedback recorded", "request_id", requestId, "rating", req.Rating, "cache_entry_id", entryId, "action", res.Action)
+	return WriteJSON(w, http.StatusOK, res)
+}
+
+// recordCacheFeedback moves a vote on the cache entry from previous to feedback and takes the
+// entry out when the quarantine policy says so. The vote is already stored on the request, so
+// failures here are only logged.
+func (s *AIGateway) recordCacheFeedback(ctx context.Context, entryId string, previous types.Feedback, feedback types.Feedback) string {
+	admin, ok := s.cache.(cache.Admin)
+	if !ok {
+		return ""
+	}
+	up, down := votes(feedback)
+	previousUp, previousDown := votes(previous)
+	entry, err := admin.RecordFeedback(ctx, entryId, up-previousUp, down-previousDown)
+	if errors.Is(err, cache.ErrEntryNotFound) {
+		//it expired or was deleted since
+		return ""
+	}
+	if err != nil {
+		slog.Error("Got this error while recording feedback on a cache entry", "id", entryId, "error", err)
+		return ""
+	}
+	policy := s.settings().CacheQuarantine
+	if !policy.Triggered(entry) {
+		return ""
+	}
+	action := "quarantined"
+	if policy.Evict {
+		action = "evicted"
+		_, err = admin.DeleteEntries(ctx, cache.DeleteFilter{Ids: []string{entryId}})
+	} else {
+		quarantined := true
+		_, err = admin.UpdateEntry(ctx, entryId, cache.EntryUpdate{Quarantined: &quarantined})
+	}
+	if err != nil {
+		slog.Error("Got this error while taking a disliked entry out of the cache", "id", entryId, "error", err)
+		return ""
+	}
+	s.ExactCache.Purge()
+	slog.Info("Cache entry taken out after negative feedback", "id", entryId, "action", action, "up", entry.FeedbackUp, "down", entry.FeedbackDown)
+	return action
+}
+
+func votes(f types.Feedback) (int, int) {
+	switch f {
+	case types.FeedbackUp:
+		return 1, 0
+	case types.FeedbackDown:
+		return 0, 1
+	}
+	return 0, 0
+}
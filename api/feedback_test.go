@@ -0,0 +1,102 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Prateek-Gupta001/AI_Gateway/cache"
+	"github.com/Prateek-Gupta001/AI_Gateway/types"
+)
+
+func chatAs(t *testing.T, gw *AIGateway, userId string, query string) types.ChatResponse {
+	t.Helper()
+	body := `{"stream": false, "cache_scope": "global", "messages": [{"role": "user", "content": "` + query + `"}]}`
+	req := httptest.NewRequest(http.MethodPost, "/chat", strings.NewReader(body))
+	req.Header.Set("userId", userId)
+	rec := httptest.NewRecorder()
+	if err := gw.Chat(rec, req); err != nil {
+		t.Fatalf("Chat returned %v", err)
+	}
+	var res types.ChatResponse
+	json.NewDecoder(rec.Body).Decode(&res)
+	return res
+}
+
+func sendFeedback(gw *AIGateway, userId string, body string) (*httptest.ResponseRecorder, feedbackResponse) {
+	req := httptest.NewRequest(http.MethodPost, "/feedback", strings.NewReader(body))
+	req.Header.Set("userId", userId)
+	rec := httptest.NewRecorder()
+	convertToHandleFunc(gw.Feedback)(rec, req)
+	var res feedbackResponse
+	json.NewDecoder(rec.Body).Decode(&res)
+	return rec, res
+}
+
+func TestFeedbackQuarantinesDislikedEntries(t *testing.T) {
+	mem := cache.NewMemoryCache(100)
+	gw := NewAIGateway(":0", &fakeStore{}, &fakeLLM{answer: []string{"Hello", " world"}}, mem, fakeEmbed{})
+
+	first := chatAs(t, gw, "u1", "what is a goroutine")
+	deadline := time.Now().Add(2 * time.Second)
+	for mem.Len() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	second := chatAs(t, gw, "u2", "what is a goroutine")
+	third := chatAs(t, gw, "u3", "what is a goroutine")
+	if !second.CacheHit || !third.CacheHit {
+		t.Fatalf("the repeats should be cache hits, %+v %+v", second, third)
+	}
+
+	rec, res := sendFeedback(gw, "u1", `{"request_id": "`+first.RequestId+`", "rating": "down"}`)
+	entryId := cache.EntryId(cache.Key{Namespace: "global", Query: "what is a goroutine"})
+	if rec.Code != http.StatusOK || res.CacheEntryId != entryId || res.Action != "" {
+		t.Fatalf("status %d, response %+v", rec.Code, res)
+	}
+	if rec, _ := sendFeedback(gw, "u2", `{"request_id": "`+first.RequestId+`", "rating": "down"}`); rec.Code != http.StatusNotFound {
+		t.Errorf("voting on someone else's request got status %d", rec.Code)
+	}
+	if rec, _ := sendFeedback(gw, "u1", `{"request_id": "`+first.RequestId+`", "rating": "meh"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("an invalid rating got status %d", rec.Code)
+	}
+	//voting again only moves the vote
+	sendFeedback(gw, "u2", `{"request_id": "`+second.RequestId+`", "rating": "up"}`)
+	sendFeedback(gw, "u2", `{"request_id": "`+second.RequestId+`", "rating": "down"}`)
+	if e, _ := mem.GetEntry(context.Background(), entryId); e.FeedbackUp != 0 || e.FeedbackDown != 2 || e.Quarantined {
+		t.Fatalf("entry after 2 votes %+v", e)
+	}
+
+	_, res = sendFeedback(gw, "u3", `{"request_id": "`+third.RequestId+`", "rating": "down"}`)
+	if res.Action != "quarantined" {
+		t.Errorf("third thumbs down %+v", res)
+	}
+	if e, _ := mem.GetEntry(context.Background(), entryId); !e.Quarantined {
+		t.Errorf("entry %+v", e)
+	}
+	if gw.ExactCache.Len() != 0 {
+		t.Error("the exact tier still serves the disliked answer")
+	}
+	if again := chatAs(t, gw, "u4", "what is a goroutine"); again.CacheHit {
+		t.Error("a quarantined entry was served")
+	}
+}
+
+func TestFeedbackEvicts(t *testing.T) {
+	mem := cache.NewMemoryCache(100)
+	gw := NewAIGateway(":0", &fakeStore{}, &fakeLLM{answer: []string{"Hello"}}, mem, fakeEmbed{})
+	gw.CacheQuarantine = cache.QuarantinePolicy{MinVotes: 1, Evict: true}
+	res := chatAs(t, gw, "u1", "what is a goroutine")
+	deadline := time.Now().Add(2 * time.Second)
+	for mem.Len() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	//the /v1 completion id works as well
+	rec, fb := sendFeedback(gw, "u1", `{"request_id": "chatcmpl-`+res.RequestId+`", "rating": "down"}`)
+	if rec.Code != http.StatusOK || fb.Action != "evicted" || mem.Len() != 0 {
+		t.Errorf("status %d, response %+v, len %d", rec.Code, fb, mem.Len())
+	}
+}
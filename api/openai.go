@@ -0,0 +1,219 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/Prateek-Gupta001/AI_Gateway/llm"
+	"github.com/Prateek-Gupta001/AI_Gateway/types"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// These are the OpenAI Chat Completions wire types, so existing OpenAI SDKs can be pointed at the gateway.
+
+type ChatCompletionRequest struct {
+	Model         string                  `json:"model"`
+	Messages      []ChatCompletionMessage `json:"messages"`
+	Stream        bool                    `json:"stream"`
+	StreamOptions *StreamOptions          `json:"stream_options,omitempty"`
+	Temperature   *float64                `json:"temperature,omitempty"`
+	MaxTokens     int                     `json:"max_tokens,omitempty"`
+	User          string                  `json:"user,omitempty"`
+	// CacheScope is not part of the OpenAI API, SDKs can send it through their extra body option.
+	CacheScope types.CacheScope `json:"cache_scope,omitempty"`
+}
+
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+type ChatCompletionMessage struct {
+	Role    types.Role     `json:"role"`
+	Content MessageContent `json:"content"`
+}
+
+// MessageContent accepts both the plain string content and the array of content parts
+// that the newer SDKs send. Only the text parts are kept.
+type MessageContent string
+
+func (m *MessageContent) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*m = MessageContent(text)
+		return nil
+	}
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(data, &parts); err != nil {
+		return fmt.Errorf("message content must be a string or an array of content parts")
+	}
+	for _, part := range parts {
+		if part.Type == "text" {
+			text += part.Text
+		}
+	}
+	*m = MessageContent(text)
+	return nil
+}
+
+type ChatCompletionResponse struct {
+	ID      string                 `json:"id"`
+	Object  string                 `json:"object"`
+	Created int64                  `json:"created"`
+	Model   string                 `json:"model"`
+	Choices []ChatCompletionChoice `json:"choices"`
+	Usage   llm.OpenAIUsage        `json:"usage"`
+}
+
+type ChatCompletionChoice struct {
+	Index        int            `json:"index"`
+	Message      types.Messages `json:"message"`
+	FinishReason string         `json:"finish_reason"`
+}
+
+type OpenAIError struct {
+	Error OpenAIErrorBody `json:"error"`
+}
+
+type OpenAIErrorBody struct {
+	Message string  `json:"message"`
+	Type    string  `json:"type"`
+	Code    *string `json:"code"`
+}
+
+func writeOpenAIError(w http.ResponseWriter, status int, message string, errType string) {
+	WriteJSON(w, status, OpenAIError{Error: OpenAIErrorBody{Message: message, Type: errType}})
+}
+
+func (c *ChatCompletionRequest) toRequestStruct(userId string) *types.RequestStruct {
+	messages := make([]types.Messages, 0, len(c.Messages))
+	for _, m := range c.Messages {
+		role := m.Role
+		if role == "developer" {
+			//developer is just the newer name for the system role
+			role = types.RoleSystem
+		}
+		messages = append(messages, types.Messages{Role: role, Content: string(m.Content)})
+	}
+	return &types.RequestStruct{
+		UserId:   userId,
+		Messages: messages,
+		GenerationOptions: types.GenerationOptions{
+			Temperature: c.Temperature,
+			MaxTokens:   c.MaxTokens,
+		},
+	}
+}
+
+func (s *AIGateway) ChatCompletions(w http.ResponseWriter, r *http.Request) error {
+	slog.Info("---------------------------------------NEW CHAT COMPLETION REQUEST---------------------------------------")
+	ctx, span := Tracer.Start(r.Context(), "ChatCompletions")
+	defer span.End()
+	defer r.Body.Close()
+
+	var body ChatCompletionRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		slog.Info("Got this error while trying to decode the chat completion request", "error", err)
+		writeOpenAIError(w, http.StatusBadRequest, err.Error(), "invalid_request_error")
+		return nil
+	}
+	userId := userIdFromRequest(r)
+	if userId == "" {
+		userId = body.User
+	}
+	span.SetAttributes(
+		attribute.String("user_Id", userId),
+		attribute.String("requested_model", body.Model),
+		attribute.Bool("stream", body.Stream),
+	)
+	req := body.toRequestStruct(userId)
+	if err := validateMessages(req.Messages); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, err.Error(), "invalid_request_error")
+		return nil
+	}
+	scope, err := resolveCacheScope(r, body.CacheScope)
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, err.Error(), "invalid_request_error")
+		return nil
+	}
+	req.CacheScope = scope
+
+	requestId := uuid.NewString()
+	completionId := "chatcmpl-" + requestId
+	var sw llm.StreamWriter
+	collector := &llm.CollectWriter{}
+	if body.Stream {
+		includeUsage := body.StreamOptions != nil && body.StreamOptions.IncludeUsage
+		sw = llm.NewOpenAIStreamWriter(w, completionId, body.Model, includeUsage)
+	} else {
+		sw = collector
+	}
+
+	res, err := s.processChat(ctx, requestId, userId, req, sw)
+	if err != nil {
+		slog.Error("Got this error while trying to serve the chat completion", "error", err)
+		if sw.Started() {
+			return sw.WriteError(err)
+		}
+		if writeBudgetExceeded(w, err, true) {
+			return nil
+		}
+		writeOpenAIError(w, http.StatusBadGateway, err.Error(), "upstream_error")
+		return nil
+	}
+
+	if res.CacheHit {
+		if body.Stream {
+			if ms, ok := sw.(llm.ModelSetter); ok {
+				ms.SetModel(cacheModel)
+			}
+			if err := replayCachedAnswer(ctx, sw, res.Cached, s.settings().CacheReplay); err != nil {
+				return sw.WriteError(err)
+			}
+			return sw.Close()
+		}
+		return WriteJSON(w, http.StatusOK, newChatCompletionResponse(completionId, cacheModel, res.Cached.CachedAnswer, llm.FinishStop, llm.OpenAIUsage{
+			PromptTokens:     res.Cached.InputTokens,
+			CompletionTokens: res.Cached.OutputTokens,
+			TotalTokens:      res.Cached.InputTokens + res.Cached.OutputTokens,
+		}))
+	}
+
+	if body.Stream {
+		return sw.Close()
+	}
+	finishReason := collector.FinishReason
+	if finishReason == "" {
+		finishReason = llm.FinishStop
+	}
+	return WriteJSON(w, http.StatusOK, newChatCompletionResponse(completionId, res.Response.Model, res.Response.LLMRes.String(), finishReason, llm.OpenAIUsage{
+		PromptTokens:     res.Response.InputTokens,
+		CompletionTokens: res.Response.OutputTokens,
+		TotalTokens:      res.Response.TotalTokens,
+	}))
+}
+
+// cacheModel is the model responses answered from the cache name. Every response names what
+// actually produced it, not the model the client asked for.
+const cacheModel = "cache"
+
+func newChatCompletionResponse(id string, model string, content string, finishReason string, usage llm.OpenAIUsage) ChatCompletionResponse {
+	return ChatCompletionResponse{
+		ID:      id,
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   model,
+		Choices: []ChatCompletionChoice{{
+			Index:        0,
+			Message:      types.Messages{Role: types.RoleAssistant, Content: content},
+			FinishReason: finishReason,
+		}},
+		Usage: usage,
+	}
+}
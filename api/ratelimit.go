@@ -0,0 +1,334 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Prateek-Gupta001/AI_Gateway/types"
+)
+
+// RateLimitConfig sets the token bucket sizes, all per minute. 0 means no limit.
+// Users and keys are told apart by the identity the Auth middleware put on the request, or by the
+// userId header for the requests that came in without an API key.
+type RateLimitConfig struct {
+	RequestsPerMinute       int `json:"requests_per_minute"`
+	TokensPerMinute         int `json:"tokens_per_minute"`
+	KeyRequestsPerMinute    int `json:"key_requests_per_minute"`
+	KeyTokensPerMinute      int `json:"key_tokens_per_minute"`
+	GlobalRequestsPerMinute int `json:"global_requests_per_minute"`
+	GlobalTokensPerMinute   int `json:"global_tokens_per_minute"`
+	// IdleSeconds is how long a bucket that is back to full can go unused before it is dropped, 600 by default.
+	IdleSeconds int `json:"idle_seconds,omitempty"`
+}
+
+func (c RateLimitConfig) validate() error {
+	if c.RequestsPerMinute < 0 || c.TokensPerMinute < 0 || c.KeyRequestsPerMinute < 0 || c.KeyTokensPerMinute < 0 ||
+		c.GlobalRequestsPerMinute < 0 || c.GlobalTokensPerMinute < 0 || c.IdleSeconds < 0 {
+		return errors.New("limits can't be negative")
+	}
+	return nil
+}
+
+// bucket is a token bucket refilled at capacity per minute. The token limits can only be
+// charged once the answer is done, so their bucket is allowed to go into debt.
+type bucket struct {
+	capacity float64
+	level    float64
+	last     time.Time
+}
+
+func newBucket(perMinute int, now time.Time) *bucket {
+	return &bucket{capacity: float64(perMinute), level: float64(perMinute), last: now}
+}
+
+func (b *bucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.level = math.Min(b.capacity, b.level+elapsed*b.capacity/60)
+	}
+	b.last = now
+}
+
+// resize changes the limit without handing out a fresh bucket, only the excess gets cut off.
+func (b *bucket) resize(perMinute int) {
+	b.capacity = float64(perMinute)
+	b.level = math.Min(b.level, b.capacity)
+}
+
+// wait is how long until n are available.
+func (b *bucket) wait(n float64) time.Duration {
+	if b.level >= n {
+		return 0
+	}
+	return time.Duration((n - b.level) / (b.capacity / 60) * float64(time.Second))
+}
+
+func (b *bucket) resetIn() time.Duration { return b.wait(b.capacity) }
+
+type limitEntry struct {
+	requests *bucket //nil when there is no limit
+	tokens   *bucket
+	lastSeen time.Time
+}
+
+// RateLimiter keeps a request and a token bucket for every user, API key and the gateway as a whole.
+type RateLimiter struct {
+	mu        sync.Mutex
+	cfg       RateLimitConfig
+	overrides map[string]types.RateLimitOverride
+	entries   map[string]*limitEntry
+	now       func() time.Time
+}
+
+func NewRateLimiter(cfg RateLimitConfig) *RateLimiter {
+	return &RateLimiter{
+		cfg:       cfg,
+		overrides: map[string]types.RateLimitOverride{},
+		entries:   map[string]*limitEntry{},
+		now:       time.Now,
+	}
+}
+
+func (l *RateLimiter) SetConfig(cfg RateLimitConfig) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.cfg = cfg
+}
+
+// SetOverrides replaces the per user limits, keyed by user id.
+func (l *RateLimiter) SetOverrides(overrides map[string]types.RateLimitOverride) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.overrides = overrides
+}
+
+const globalSubject = "global"
+
+// rateLimitSubjects are the buckets a request counts against.
+func rateLimitSubjects(r *http.Request) []string {
+	subjects := []string{globalSubject}
+	if userId := userIdFromRequest(r); userId != "" {
+		subjects = append(subjects, "user:"+userId)
+	}
+	if identity := types.IdentityFromContext(r.Context()); identity != nil {
+		subjects = append(subjects, "key:"+identity.KeyId)
+	}
+	return subjects
+}
+
+func (l *RateLimiter) limitsFor(subject string) (requests int, tokens int) {
+	switch {
+	case subject == globalSubject:
+		return l.cfg.GlobalRequestsPerMinute, l.cfg.GlobalTokensPerMinute
+	case strings.HasPrefix(subject, "key:"):
+		return l.cfg.KeyRequestsPerMinute, l.cfg.KeyTokensPerMinute
+	}
+	requests, tokens = l.cfg.RequestsPerMinute, l.cfg.TokensPerMinute
+	if o, ok := l.overrides[strings.TrimPrefix(subject, "user:")]; ok {
+		if o.RequestsPerMinute != nil {
+			requests = *o.RequestsPerMinute
+		}
+		if o.TokensPerMinute != nil {
+			tokens = *o.TokensPerMinute
+		}
+	}
+	return requests, tokens
+}
+
+func syncBucket(b *bucket, limit int, now time.Time) *bucket {
+	if limit <= 0 {
+		return nil
+	}
+	if b == nil {
+		return newBucket(limit, now)
+	}
+	b.refill(now)
+	if b.capacity != float64(limit) {
+		b.resize(limit)
+	}
+	return b
+}
+
+// entry returns the up to date buckets of a subject. Must be called with mu held.
+func (l *RateLimiter) entry(subject string, now time.Time) *limitEntry {
+	e, ok := l.entries[subject]
+	if !ok {
+		e = &limitEntry{}
+		l.entries[subject] = e
+	}
+	requests, tokens := l.limitsFor(subject)
+	e.requests = syncBucket(e.requests, requests, now)
+	e.tokens = syncBucket(e.tokens, tokens, now)
+	e.lastSeen = now
+	return e
+}
+
+// RateLimitDecision is the outcome of Allow, with what goes in the X-RateLimit-* headers.
+// The header values are for the tightest bucket the request counted against.
+type RateLimitDecision struct {
+	Allowed           bool
+	RetryAfter        time.Duration
+	LimitRequests     int
+	RemainingRequests int
+	ResetRequests     time.Duration
+	LimitTokens       int
+	RemainingTokens   int
+	ResetTokens       time.Duration
+}
+
+// Allow takes one request from every subject's bucket, but only if all of them have room
+// (and none of their token buckets are in debt). Nothing is taken when the request is refused.
+func (l *RateLimiter) Allow(subjects []string) RateLimitDecision {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	d := RateLimitDecision{Allowed: true, RemainingRequests: -1, RemainingTokens: -1}
+	entries := make([]*limitEntry, 0, len(subjects))
+	for _, subject := range subjects {
+		e := l.entry(subject, now)
+		entries = append(entries, e)
+		if e.requests != nil {
+			if wait := e.requests.wait(1); wait > 0 {
+				d.Allowed = false
+				d.RetryAfter = max(d.RetryAfter, wait)
+			}
+		}
+		if e.tokens != nil {
+			if wait := e.tokens.wait(1); wait > 0 {
+				d.Allowed = false
+				d.RetryAfter = max(d.RetryAfter, wait)
+			}
+		}
+	}
+	for _, e := range entries {
+		if d.Allowed && e.requests != nil {
+			e.requests.level--
+		}
+		if b := e.requests; b != nil && (d.RemainingRequests == -1 || int(b.level) < d.RemainingRequests) {
+			d.LimitRequests, d.RemainingRequests, d.ResetRequests = int(b.capacity), max(int(b.level), 0), b.resetIn()
+		}
+		if b := e.tokens; b != nil && (d.RemainingTokens == -1 || int(b.level) < d.RemainingTokens) {
+			d.LimitTokens, d.RemainingTokens, d.ResetTokens = int(b.capacity), max(int(b.level), 0), b.resetIn()
+		}
+	}
+	return d
+}
+
+// ChargeTokens takes the tokens an answer used from every subject's token bucket.
+func (l *RateLimiter) ChargeTokens(subjects []string, tokens int) {
+	if tokens <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	for _, subject := range subjects {
+		if e := l.entry(subject, now); e.tokens != nil {
+			e.tokens.level -= float64(tokens)
+		}
+	}
+}
+
+// EvictIdle drops the buckets that have been full and unused for longer than IdleSeconds,
+// so the map doesn't grow with every user ever seen.
+func (l *RateLimiter) EvictIdle() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	idle := time.Duration(l.cfg.IdleSeconds) * time.Second
+	if idle == 0 {
+		idle = 10 * time.Minute
+	}
+	now := l.now()
+	evicted := 0
+	for subject, e := range l.entries {
+		if now.Sub(e.lastSeen) < idle {
+			continue
+		}
+		full := true
+		for _, b := range []*bucket{e.requests, e.tokens} {
+			if b != nil {
+				b.refill(now)
+				full = full && b.level >= b.capacity
+			}
+		}
+		if full {
+			delete(l.entries, subject)
+			evicted++
+		}
+	}
+	return evicted
+}
+
+type rateLimitCtxKey struct{}
+
+// subjectsFromContext returns the buckets the RateLimit middleware counted the request against.
+func subjectsFromContext(ctx context.Context) []string {
+	subjects, _ := ctx.Value(rateLimitCtxKey{}).([]string)
+	return subjects
+}
+
+func setRateLimitHeaders(w http.ResponseWriter, d RateLimitDecision) {
+	h := w.Header()
+	if d.RemainingRequests >= 0 {
+		h.Set("X-RateLimit-Limit-Requests", strconv.Itoa(d.LimitRequests))
+		h.Set("X-RateLimit-Remaining-Requests", strconv.Itoa(d.RemainingRequests))
+		h.Set("X-RateLimit-Reset-Requests", d.ResetRequests.Round(time.Millisecond).String())
+	}
+	if d.RemainingTokens >= 0 {
+		h.Set("X-RateLimit-Limit-Tokens", strconv.Itoa(d.LimitTokens))
+		h.Set("X-RateLimit-Remaining-Tokens", strconv.Itoa(d.RemainingTokens))
+		h.Set("X-RateLimit-Reset-Tokens", d.ResetTokens.Round(time.Millisecond).String())
+	}
+}
+
+// RateLimit refuses requests over the limits with a 429 and a Retry-After header. The tokens
+// of the answer are charged by processChat once it is done.
+func (s *AIGateway) RateLimit(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		subjects := rateLimitSubjects(r)
+		d := s.RateLimiter.Allow(subjects)
+		setRateLimitHeaders(w, d)
+		if !d.Allowed {
+			retryAfter := int(math.Ceil(d.RetryAfter.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			slog.Info("Too many requests in a short period of time!", "subjects", subjects, "retryAfter", retryAfter)
+			message := fmt.Sprintf("Rate limit reached, retry in %ds", retryAfter)
+			if strings.HasPrefix(r.URL.Path, "/v1/") {
+				writeOpenAIError(w, http.StatusTooManyRequests, message, "rate_limit_error")
+				return
+			}
+			http.Error(w, message, http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), rateLimitCtxKey{}, subjects)))
+	}
+}
+
+// RunRateLimiter loads the per user overrides from the store and evicts idle buckets, every interval.
+func (s *AIGateway) RunRateLimiter(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		overrides, err := s.store.GetRateLimitOverrides(ctx)
+		if err != nil {
+			slog.Error("Got this error while trying to load the rate limit overrides", "error", err)
+		} else {
+			s.RateLimiter.SetOverrides(overrides)
+		}
+		if evicted := s.RateLimiter.EvictIdle(); evicted > 0 {
+			slog.Info("Evicted idle rate limit buckets", "evicted", evicted)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
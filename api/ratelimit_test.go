@@ -0,0 +1,121 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Prateek-Gupta001/AI_Gateway/types"
+)
+
+func newTestLimiter(cfg RateLimitConfig) (*RateLimiter, *time.Time) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := NewRateLimiter(cfg)
+	l.now = func() time.Time { return now }
+	return l, &now
+}
+
+func TestRateLimiterRequests(t *testing.T) {
+	l, now := newTestLimiter(RateLimitConfig{RequestsPerMinute: 2})
+	user := []string{globalSubject, "user:u1"}
+	for i := 0; i < 2; i++ {
+		if d := l.Allow(user); !d.Allowed || d.RemainingRequests != 1-i {
+			t.Fatalf("request %d: %+v", i, d)
+		}
+	}
+	d := l.Allow(user)
+	if d.Allowed || d.RetryAfter != 30*time.Second {
+		t.Fatalf("third request: %+v", d)
+	}
+	//other users have their own bucket
+	if d := l.Allow([]string{globalSubject, "user:u2"}); !d.Allowed {
+		t.Errorf("u2 was limited: %+v", d)
+	}
+	*now = now.Add(30 * time.Second)
+	if d := l.Allow(user); !d.Allowed {
+		t.Errorf("bucket should have refilled one request: %+v", d)
+	}
+}
+
+func TestRateLimiterTokens(t *testing.T) {
+	l, now := newTestLimiter(RateLimitConfig{TokensPerMinute: 600})
+	user := []string{globalSubject, "user:u1"}
+	if d := l.Allow(user); !d.Allowed {
+		t.Fatal(d)
+	}
+	//the answer turned out bigger than the bucket, the debt has to be paid off first
+	l.ChargeTokens(user, 700)
+	d := l.Allow(user)
+	if d.Allowed || d.RetryAfter != 10100*time.Millisecond || d.RemainingTokens != 0 {
+		t.Fatalf("%+v", d)
+	}
+	*now = now.Add(11 * time.Second)
+	if d := l.Allow(user); !d.Allowed {
+		t.Errorf("%+v", d)
+	}
+}
+
+func TestRateLimiterGlobalAndOverrides(t *testing.T) {
+	l, _ := newTestLimiter(RateLimitConfig{RequestsPerMinute: 1, GlobalRequestsPerMinute: 3})
+	five := 5
+	l.SetOverrides(map[string]types.RateLimitOverride{"vip": {RequestsPerMinute: &five}})
+	if d := l.Allow([]string{globalSubject, "user:vip"}); !d.Allowed {
+		t.Fatal(d)
+	}
+	if d := l.Allow([]string{globalSubject, "user:vip"}); !d.Allowed {
+		t.Fatalf("the override should allow more than one request: %+v", d)
+	}
+	if d := l.Allow([]string{globalSubject, "user:u1"}); !d.Allowed {
+		t.Fatal(d)
+	}
+	//the global bucket is empty now, even for the vip
+	if d := l.Allow([]string{globalSubject, "user:vip"}); d.Allowed {
+		t.Errorf("global limit was not applied: %+v", d)
+	}
+}
+
+func TestRateLimiterEvictIdle(t *testing.T) {
+	l, now := newTestLimiter(RateLimitConfig{RequestsPerMinute: 10, IdleSeconds: 60})
+	l.Allow([]string{"user:u1"})
+	*now = now.Add(30 * time.Second)
+	l.Allow([]string{"user:u2"})
+	*now = now.Add(45 * time.Second)
+	if evicted := l.EvictIdle(); evicted != 1 {
+		t.Errorf("evicted %d", evicted)
+	}
+	if _, ok := l.entries["user:u2"]; !ok {
+		t.Errorf("u2 was used recently and should still be there")
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	gw, _, _ := newTestGateway()
+	gw.RateLimiter = NewRateLimiter(RateLimitConfig{KeyRequestsPerMinute: 1})
+	calls := 0
+	h := gw.RateLimit(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if len(subjectsFromContext(r.Context())) != 3 {
+			t.Errorf("subjects = %v", subjectsFromContext(r.Context()))
+		}
+	})
+	send := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+		identity := &types.Identity{KeyId: "k1", TenantId: "acme", UserId: "u1"}
+		req = req.WithContext(context.WithValue(req.Context(), types.IdentityKey, identity))
+		rec := httptest.NewRecorder()
+		h(rec, req)
+		return rec
+	}
+	if rec := send(); rec.Code != http.StatusOK || rec.Header().Get("X-RateLimit-Remaining-Requests") != "0" {
+		t.Fatalf("status %d, headers %v", rec.Code, rec.Header())
+	}
+	rec := send()
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "60" || rec.Header().Get("X-RateLimit-Limit-Requests") != "1" {
+		t.Errorf("status %d, headers %v", rec.Code, rec.Header())
+	}
+	if calls != 1 {
+		t.Errorf("handler was called %d times", calls)
+	}
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/Prateek-Gupta001/AI_Gateway/llm"
+	"github.com/Prateek-Gupta001/AI_Gateway/types"
+)
+
+// CacheReplayConfig decides how cache hits get sent back on /chat. With Stream off the cached
+// answer is returned as plain JSON (types.CacheResponse) like before.
+type CacheReplayConfig struct {
+	Stream     bool
+	ChunkWords int           //words per delta event, 0 sends the whole answer in one delta
+	Delay      time.Duration //pause between two delta events
+}
+
+// replayCachedAnswer writes a cached answer through the StreamWriter, so it looks exactly
+// like a live answer to the client. It doesn't Close the writer.
+func replayCachedAnswer(ctx context.Context, sw llm.StreamWriter, cached types.CacheResponse, cfg CacheReplayConfig) error {
+	for i, chunk := range chunkAnswer(cached.CachedAnswer, cfg.ChunkWords) {
+		if i > 0 && cfg.Delay > 0 {
+			select {
+			case <-time.After(cfg.Delay):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		if err := sw.WriteDelta(chunk); err != nil {
+			return err
+		}
+	}
+	sw.WriteUsage(cached.InputTokens, cached.OutputTokens, cached.InputTokens+cached.OutputTokens)
+	sw.WriteFinish(llm.FinishStop)
+	return nil
+}
+
+// chunkAnswer splits the answer into chunks of n words, keeping the whitespace so that
+// the chunks put back together give the exact same answer.
+func chunkAnswer(answer string, n int) []string {
+	if n <= 0 || answer == "" {
+		return []string{answer}
+	}
+	var chunks []string
+	var current strings.Builder
+	words := 0
+	inWord := false
+	for _, r := range answer {
+		isSpace := r == ' ' || r == '\n' || r == '\t' || r == '\r'
+		if !isSpace && !inWord {
+			if words == n {
+				chunks = append(chunks, current.String())
+				current.Reset()
+				words = 0
+			}
+			words++
+		}
+		inWord = !isSpace
+		current.WriteRune(r)
+	}
+	if current.Len() > 0 {
+		chunks = append(chunks, current.String())
+	}
+	return chunks
+}
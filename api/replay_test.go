@@ -0,0 +1,26 @@
+package api
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestChunkAnswer(t *testing.T) {
+	answer := "The quick  brown fox\njumps over the lazy dog."
+	for _, n := range []int{0, 1, 2, 3, 100} {
+		chunks := chunkAnswer(answer, n)
+		if got := strings.Join(chunks, ""); got != answer {
+			t.Errorf("n=%d: chunks put back together = %q", n, got)
+		}
+		if n > 0 {
+			for _, c := range chunks {
+				if words := len(strings.Fields(c)); words > n {
+					t.Errorf("n=%d: chunk %q has %d words", n, c, words)
+				}
+			}
+		}
+	}
+	if got := len(chunkAnswer(answer, 3)); got != 3 {
+		t.Errorf("expected 3 chunks of 3 words, got %d", got)
+	}
+}
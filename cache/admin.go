@@ -0,0 +1,112 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Prateek-Gupta001/AI_Gateway/types"
+)
+
+var ErrEntryNotFound = errors.New("cache entry not found")
+
+// Entry is a cached answer as the admin endpoints show it.
+type Entry struct {
+	Id           string    `json:"id"`
+	Namespace    string    `json:"namespace"`
+	Context      string    `json:"context,omitempty"`
+	Query        string    `json:"query"`
+	Answer       string    `json:"answer"`
+	InputTokens  int       `json:"input_tokens"`
+	OutputTokens int       `json:"output_tokens"`
+	CreatedAt    time.Time `json:"created_at,omitempty"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	TTLSeconds   int       `json:"ttl_seconds"`
+	Pinned       bool      `json:"pinned"`
+	FeedbackUp   int       `json:"feedback_up"`
+	FeedbackDown int       `json:"feedback_down"`
+	Quarantined  bool      `json:"quarantined"`
+	Score        float32   `json:"score,omitempty"` //only set by SearchEntries
+}
+
+// live tells if the entry can still be served, pinned ones never expire.
+func (e Entry) live(now time.Time) bool {
+	return e.Pinned || now.Before(e.ExpiresAt)
+}
+
+// replacement is the entry a fresh answer to key is stored as, or false when old (nil if the
+// query isn't cached) has to stay. Every backend inserts through it, pgvector does the same in
+// its upsert. A pinned, edited or still live entry is worth more than a fresh answer to the
+// same query, and a quarantined one keeps the query out of the cache until an admin releases
+// or deletes it. An expired entry is replaced but its votes are carried over, the ones short
+// of min_votes must add up across answers.
+func replacement(old *Entry, key Key, res types.LLMResponse, ttl time.Duration, now time.Time) (Entry, bool) {
+	if old != nil && (old.Quarantined || old.live(now)) {
+		return Entry{}, false
+	}
+	e := Entry{
+		Id:           EntryId(key),
+		Namespace:    key.Namespace,
+		Context:      key.Context,
+		Query:        key.Query,
+		Answer:       res.LLMRes.String(),
+		InputTokens:  res.InputTokens,
+		OutputTokens: res.OutputTokens,
+		CreatedAt:    now,
+		ExpiresAt:    now.Add(ttl),
+		TTLSeconds:   int(ttl / time.Second),
+	}
+	if old != nil {
+		e.FeedbackUp, e.FeedbackDown = old.FeedbackUp, old.FeedbackDown
+	}
+	return e, true
+}
+
+// ListOptions pages through the entries in id order. Cursor is the next_cursor of the previous
+// page, empty for the first one.
+type ListOptions struct {
+	Namespace string
+	Cursor    string
+	Limit     int
+}
+
+type EntryPage struct {
+	Entries    []Entry `json:"entries"`
+	NextCursor string  `json:"next_cursor,omitempty"`
+}
+
+// DeleteFilter selects the entries to delete, an entry has to match every field that is set.
+// Pinned entries are deleted as well, pinning only protects them from expiring.
+type DeleteFilter struct {
+	Ids       []string
+	Namespace string
+	Query     string    //the query exactly as it was cached
+	Before    time.Time //created before
+}
+
+func (f DeleteFilter) Empty() bool {
+	return len(f.Ids) == 0 && f.Namespace == "" && f.Query == "" && f.Before.IsZero()
+}
+
+// EntryUpdate changes the fields that are set and leaves the others alone.
+type EntryUpdate struct {
+	Answer *string `json:"answer,omitempty"`
+	Pinned *bool   `json:"pinned,omitempty"`
+	// Quarantined entries are kept but never served, and their query isn't cached again
+	// until they are released or deleted.
+	Quarantined *bool `json:"quarantined,omitempty"`
+}
+
+// Admin is implemented by the backends that can be inspected and fixed through /admin/cache.
+type Admin interface {
+	ListEntries(ctx context.Context, opts ListOptions) (EntryPage, error)
+	GetEntry(ctx context.Context, id string) (Entry, error)
+	// SearchEntries returns the limit nearest entries to the embedding with their scores, whatever
+	// the threshold. An empty namespace searches all of them.
+	SearchEntries(ctx context.Context, namespace string, Embedding types.Embedding, limit int) ([]Entry, error)
+	DeleteEntries(ctx context.Context, filter DeleteFilter) (int, error)
+	UpdateEntry(ctx context.Context, id string, update EntryUpdate) (Entry, error)
+	// RecordFeedback adds up and down to the votes of an entry, they are negative when a user
+	// takes a vote back.
+	RecordFeedback(ctx context.Context, id string, up int, down int) (Entry, error)
+}
@@ -4,10 +4,10 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"sync"
 	"time"
 
 	"github.com/Prateek-Gupta001/AI_Gateway/types"
-	"github.com/google/uuid"
 	"github.com/qdrant/go-client/qdrant"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
@@ -16,14 +16,72 @@ import (
 
 var Tracer = otel.Tracer("ai-gateway-service")
 
+// Every entry belongs to a namespace (see Namespace) and lookups only ever match entries of their own
+// namespace and conversation context (see Key), so one tenant's answers are never served to another.
 type Cache interface {
-	ExistsInCache(ctx context.Context, Embedding types.Embedding, userQuery string) (types.CacheResponse, bool, error) //if found then "query answer", true, nil ..If not found then "", false, nil ..
-	InsertIntoCache(ctx context.Context, Embedding types.Embedding, llmResStruct types.LLMResponse, userQuery string)  //LLMAnswer will be stored in qdrant metadata!
+	ExistsInCache(ctx context.Context, key Key, Embedding types.Embedding) (types.CacheResponse, bool, error)                   //if found then "query answer", true, nil ..If not found then "", false, nil ..
+	InsertIntoCache(ctx context.Context, key Key, Embedding types.Embedding, llmResStruct types.LLMResponse, ttl time.Duration) //LLMAnswer will be stored in qdrant metadata!
+	SetThreshold(threshold float32)                                                                                             //used by the config reload
+	SetSliding(sliding bool)                                                                                                    //when set a hit pushes the entry's expiry back by its ttl
+}
+
+// Namespace is where the answers of a request are looked up and stored for the given scope.
+// Requests without a tenant (no API key) never get a tenant: namespace, a client could name
+// any tenant in its userId header. They keep to their user's own namespace in both the tenant
+// and private scopes, and share "anonymous" when they have no user either. Private scope needs
+// a user though, without one Namespace is empty and the request should skip the cache.
+func Namespace(scope types.CacheScope, tenantId string, userId string) string {
+	if scope == types.ScopeGlobal {
+		return "global"
+	}
+	if tenantId == "" {
+		switch {
+		case userId != "":
+			return "user:" + userId
+		case scope == types.ScopePrivate:
+			return ""
+		}
+		return "anonymous"
+	}
+	if scope == types.ScopePrivate {
+		if userId == "" {
+			return ""
+		}
+		return "private:" + tenantId + "/" + userId
+	}
+	return "tenant:" + tenantId
 }
 
 type QdrantCache struct {
 	Client    *qdrant.Client
 	Threshold float32
+	Sliding   bool
+	mu        sync.RWMutex
+}
+
+// SetThreshold changes the minimum similarity score for a cache hit, searches already running keep the old one.
+func (q *QdrantCache) SetThreshold(threshold float32) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.Threshold = threshold
+}
+
+func (q *QdrantCache) threshold() float32 {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	return q.Threshold
+}
+
+func (q *QdrantCache) SetSliding(sliding bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.Sliding = sliding
+}
+
+func (q *QdrantCache) sliding() bool {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	return q.Sliding
 }
 
 func NewQdrantCache() *QdrantCache {
@@ -65,26 +123,65 @@ func NewQdrantCache() *QdrantCache {
 			slog.Error("Got this error while creating the qdrant cache!", "error", err)
 		}
 	}
+	//every search filters on these, collections created before they existed need the indexes too
+	for _, field := range []string{"Namespace", "Context"} {
+		_, err = client.CreateFieldIndex(context.Background(), &qdrant.CreateFieldIndexCollection{
+			CollectionName: "AI_Gateway_Cache_1",
+			FieldName:      field,
+			FieldType:      qdrant.FieldType_FieldTypeKeyword.Enum(),
+		})
+		if err != nil {
+			slog.Error("Got this error while creating a payload index!", "field", field, "error", err)
+		}
+	}
+	//deleting by age from the admin api filters on it
+	_, err = client.CreateFieldIndex(context.Background(), &qdrant.CreateFieldIndexCollection{
+		CollectionName: "AI_Gateway_Cache_1",
+		FieldName:      "CreatedAt",
+		FieldType:      qdrant.FieldType_FieldTypeDatetime.Enum(),
+	})
+	if err != nil {
+		slog.Error("Got this error while creating a payload index!", "field", "CreatedAt", "error", err)
+	}
 	return &QdrantCache{
 		Client:    client,
-		Threshold: 0.85,
+		Threshold: DefaultThreshold,
 	}
 }
 
-func (q *QdrantCache) ExistsInCache(ctx context.Context, Embedding types.Embedding, userQuery string) (types.CacheResponse, bool, error) {
+func (q *QdrantCache) ExistsInCache(ctx context.Context, key Key, Embedding types.Embedding) (types.CacheResponse, bool, error) {
 	ctx, cancel := context.WithTimeout(ctx, time.Second)
 	defer cancel()
 	ctx, span := Tracer.Start(ctx, "Qdrant.ExistsInCache")
 	span.SetAttributes(
-		attribute.String("user_query", userQuery),
+		attribute.String("user_query", key.Query),
+		attribute.String("namespace", key.Namespace),
+		attribute.Bool("has_context", key.Context != ""),
 	)
 
 	defer span.End()
+	threshold := q.threshold()
 	searchResult, err := q.Client.Query(ctx, &qdrant.QueryPoints{
 		CollectionName: "AI_Gateway_Cache_1",
 		Query:          qdrant.NewQuery(Embedding...),
+		Filter: &qdrant.Filter{
+			Must: []*qdrant.Condition{
+				qdrant.NewMatchKeyword("Namespace", key.Namespace),
+				qdrant.NewMatchKeyword("Context", contextPayload(key)),
+				//the sweep only runs every so often, expired points must not be served until then
+				qdrant.NewFilterAsCondition(&qdrant.Filter{
+					Should: []*qdrant.Condition{
+						qdrant.NewDatetimeRange("TTL", &qdrant.DatetimeRange{Gt: timestamppb.New(time.Now())}),
+						qdrant.NewMatchBool("Pinned", true),
+					},
+				}),
+			},
+			MustNot: []*qdrant.Condition{
+				qdrant.NewMatchBool("Quarantined", true),
+			},
+		},
 		WithPayload:    qdrant.NewWithPayload(true),
-		ScoreThreshold: &q.Threshold,
+		ScoreThreshold: &threshold,
 	})
 	if err != nil {
 		slog.Info("Got this error while trying to find if it ExistsInCache", "error", err)
@@ -95,12 +192,42 @@ func (q *QdrantCache) ExistsInCache(ctx context.Context, Embedding types.Embeddi
 		slog.Info("CACHE HIT! Found something in the cache!")
 		x := results.Payload
 		Res := GetCachedRes(x)
+		Res.Score = results.Score
+		Res.Id = results.Id.GetUuid()
+		if q.sliding() && !x["Pinned"].GetBoolValue() {
+			go q.extend(context.WithoutCancel(ctx), results.Id, ttlOrDefault(int(x["TTLSeconds"].GetIntegerValue())))
+		}
 		return *Res, true, nil
 	}
 	slog.Info("Cache Miss!")
 	return types.CacheResponse{}, false, nil
 }
 
+// contextPayload is the Context stored in qdrant, single questions get a placeholder as
+// a keyword match on an empty string is not something to rely on.
+func contextPayload(key Key) string {
+	if key.Context == "" {
+		return "none"
+	}
+	return key.Context
+}
+
+// extend pushes the expiry of a point that was just hit back by its ttl.
+func (q *QdrantCache) extend(ctx context.Context, id *qdrant.PointId, ttl time.Duration) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	_, err := q.Client.SetPayload(ctx, &qdrant.SetPayloadPoints{
+		CollectionName: "AI_Gateway_Cache_1",
+		Payload: qdrant.NewValueMap(map[string]any{
+			"TTL": time.Now().Add(ttl).Format(time.RFC3339),
+		}),
+		PointsSelector: qdrant.NewPointsSelector(id),
+	})
+	if err != nil {
+		slog.Error("Got this error while extending the ttl of a cache entry", "error", err)
+	}
+}
+
 func GetCachedRes(x map[string]*qdrant.Value) *types.CacheResponse {
 	Res := &types.CacheResponse{}
 	Res.CachedAnswer = string(x["CachedAnswer"].GetStringValue())
@@ -110,15 +237,27 @@ func GetCachedRes(x map[string]*qdrant.Value) *types.CacheResponse {
 	return Res
 }
 
-func (q *QdrantCache) InsertIntoCache(ctx context.Context, Embedding types.Embedding, llmResStruct types.LLMResponse, userQuery string) {
+func (q *QdrantCache) InsertIntoCache(ctx context.Context, key Key, Embedding types.Embedding, llmResStruct types.LLMResponse, ttl time.Duration) {
 	ctx, cancel := context.WithTimeout(ctx, time.Second)
 	defer cancel()
 	ctx, span := Tracer.Start(ctx, "Qdrant.InsertIntoCache")
 	span.SetAttributes(
-		attribute.String("user_query", userQuery),
+		attribute.String("user_query", key.Query),
+		attribute.String("namespace", key.Namespace),
+		attribute.Bool("has_context", key.Context != ""),
 	)
 	defer span.End()
-	id := uuid.NewSHA1(uuid.NameSpaceOID, []byte(userQuery)).String()
+	id := EntryId(key)
+	now := time.Now()
+	var prev *Entry
+	if old, err := q.GetEntry(ctx, id); err == nil {
+		prev = &old
+	}
+	e, ok := replacement(prev, key, llmResStruct, ttl, now)
+	if !ok {
+		slog.Info("The query is already cached, keeping its entry", "id", id, "quarantined", prev.Quarantined)
+		return
+	}
 	operationInfo, err := q.Client.Upsert(ctx, &qdrant.UpsertPoints{
 		CollectionName: "AI_Gateway_Cache_1",
 		Points: []*qdrant.PointStruct{
@@ -126,11 +265,19 @@ func (q *QdrantCache) InsertIntoCache(ctx context.Context, Embedding types.Embed
 				Id:      qdrant.NewIDUUID(id),
 				Vectors: qdrant.NewVectors(Embedding...),
 				Payload: qdrant.NewValueMap(map[string]any{
-					"InputTokens":  llmResStruct.InputTokens,
-					"OutputTokens": llmResStruct.OutputTokens,
-					"CachedAnswer": llmResStruct.LLMRes.String(),
-					"CachedQuery":  userQuery,
-					"TTL":          time.Now().Add(24 * time.Hour).Format(time.RFC3339), //inside cache for a day
+					"InputTokens":  e.InputTokens,
+					"OutputTokens": e.OutputTokens,
+					"CachedAnswer": e.Answer,
+					"CachedQuery":  e.Query,
+					"Namespace":    e.Namespace,
+					"Context":      contextPayload(key),
+					"CreatedAt":    e.CreatedAt.Format(time.RFC3339),
+					"TTL":          e.ExpiresAt.Format(time.RFC3339),
+					"TTLSeconds":   e.TTLSeconds,
+					"Pinned":       e.Pinned,
+					"FeedbackUp":   e.FeedbackUp,
+					"FeedbackDown": e.FeedbackDown,
+					"Quarantined":  e.Quarantined,
 				}),
 			},
 		},
@@ -142,14 +289,14 @@ func (q *QdrantCache) InsertIntoCache(ctx context.Context, Embedding types.Embed
 	slog.Info("Insertion into cache successful!", "operationInfo", operationInfo)
 }
 
-func (q *QdrantCache) ReviseCache(ctx context.Context) {
+func (q *QdrantCache) ReviseCache(ctx context.Context, interval time.Duration) {
 	//this function goes via the qdrant cache and removes those points/vectors that have exceeded their TTL.
-	ticker := time.NewTicker(24 * time.Hour)
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 	for {
 		select {
 		case <-ticker.C:
-			slog.Info("Our daily cache cleanup has begun!")
+			slog.Info("Our periodic cache cleanup has begun!")
 			res, err := q.Client.Delete(context.Background(), &qdrant.DeletePoints{
 				CollectionName: "AI_Gateway_Cache_1",
 				Points: qdrant.NewPointsSelectorFilter(
@@ -159,6 +306,10 @@ func (q *QdrantCache) ReviseCache(ctx context.Context) {
 								Lte: timestamppb.New(time.Now()),
 							}),
 						},
+						//pinned entries never expire
+						MustNot: []*qdrant.Condition{
+							qdrant.NewMatchBool("Pinned", true),
+						},
 					},
 				),
 			})
@@ -172,3 +323,229 @@ func (q *QdrantCache) ReviseCache(ctx context.Context) {
 		}
 	}
 }
+
+// entryFromPayload turns a point back into an Entry, points written before CreatedAt, Pinned
+// and the feedback existed just leave them zero.
+func entryFromPayload(id *qdrant.PointId, x map[string]*qdrant.Value) Entry {
+	e := Entry{
+		Id:           id.GetUuid(),
+		Namespace:    x["Namespace"].GetStringValue(),
+		Query:        x["CachedQuery"].GetStringValue(),
+		Answer:       x["CachedAnswer"].GetStringValue(),
+		InputTokens:  int(x["InputTokens"].GetIntegerValue()),
+		OutputTokens: int(x["OutputTokens"].GetIntegerValue()),
+		TTLSeconds:   int(ttlOrDefault(int(x["TTLSeconds"].GetIntegerValue())) / time.Second),
+		Pinned:       x["Pinned"].GetBoolValue(),
+		FeedbackUp:   int(x["FeedbackUp"].GetIntegerValue()),
+		FeedbackDown: int(x["FeedbackDown"].GetIntegerValue()),
+		Quarantined:  x["Quarantined"].GetBoolValue(),
+	}
+	if conversation := x["Context"].GetStringValue(); conversation != "none" {
+		e.Context = conversation
+	}
+	e.CreatedAt, _ = time.Parse(time.RFC3339, x["CreatedAt"].GetStringValue())
+	e.ExpiresAt, _ = time.Parse(time.RFC3339, x["TTL"].GetStringValue())
+	return e
+}
+
+func namespaceFilter(namespace string) *qdrant.Filter {
+	if namespace == "" {
+		return nil
+	}
+	return &qdrant.Filter{
+		Must: []*qdrant.Condition{qdrant.NewMatchKeyword("Namespace", namespace)},
+	}
+}
+
+func (q *QdrantCache) ListEntries(ctx context.Context, opts ListOptions) (EntryPage, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	limit := uint32(opts.Limit)
+	scroll := &qdrant.ScrollPoints{
+		CollectionName: "AI_Gateway_Cache_1",
+		Filter:         namespaceFilter(opts.Namespace),
+		Limit:          &limit,
+		WithPayload:    qdrant.NewWithPayload(true),
+	}
+	if opts.Cursor != "" {
+		scroll.Offset = qdrant.NewIDUUID(opts.Cursor)
+	}
+	points, next, err := q.Client.ScrollAndOffset(ctx, scroll)
+	if err != nil {
+		slog.Error("Got this error while trying to scroll through the cache", "error", err)
+		return EntryPage{}, err
+	}
+	page := EntryPage{Entries: make([]Entry, 0, len(points))}
+	for _, p := range points {
+		page.Entries = append(page.Entries, entryFromPayload(p.Id, p.Payload))
+	}
+	if next != nil {
+		page.NextCursor = next.GetUuid()
+	}
+	return page, nil
+}
+
+func (q *QdrantCache) GetEntry(ctx context.Context, id string) (Entry, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	points, err := q.Client.Get(ctx, &qdrant.GetPoints{
+		CollectionName: "AI_Gateway_Cache_1",
+		Ids:            []*qdrant.PointId{qdrant.NewIDUUID(id)},
+		WithPayload:    qdrant.NewWithPayload(true),
+	})
+	if err != nil {
+		return Entry{}, err
+	}
+	if len(points) == 0 {
+		return Entry{}, ErrEntryNotFound
+	}
+	return entryFromPayload(points[0].Id, points[0].Payload), nil
+}
+
+func (q *QdrantCache) SearchEntries(ctx context.Context, namespace string, Embedding types.Embedding, limit int) ([]Entry, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	ctx, span := Tracer.Start(ctx, "Qdrant.SearchEntries")
+	defer span.End()
+	n := uint64(limit)
+	results, err := q.Client.Query(ctx, &qdrant.QueryPoints{
+		CollectionName: "AI_Gateway_Cache_1",
+		Query:          qdrant.NewQuery(Embedding...),
+		Filter:         namespaceFilter(namespace),
+		Limit:          &n,
+		WithPayload:    qdrant.NewWithPayload(true),
+	})
+	if err != nil {
+		slog.Error("Got this error while searching the cache", "error", err)
+		return nil, err
+	}
+	entries := make([]Entry, 0, len(results))
+	for _, r := range results {
+		e := entryFromPayload(r.Id, r.Payload)
+		e.Score = r.Score
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+func deleteConditions(filter DeleteFilter) *qdrant.Filter {
+	f := &qdrant.Filter{}
+	if len(filter.Ids) > 0 {
+		ids := make([]*qdrant.PointId, len(filter.Ids))
+		for i, id := range filter.Ids {
+			ids[i] = qdrant.NewIDUUID(id)
+		}
+		f.Must = append(f.Must, qdrant.NewHasID(ids...))
+	}
+	if filter.Namespace != "" {
+		f.Must = append(f.Must, qdrant.NewMatchKeyword("Namespace", filter.Namespace))
+	}
+	if filter.Query != "" {
+		f.Must = append(f.Must, qdrant.NewMatchKeyword("CachedQuery", filter.Query))
+	}
+	if !filter.Before.IsZero() {
+		//points written before CreatedAt existed were all cached for a day, their TTL tells their age
+		f.Must = append(f.Must, qdrant.NewFilterAsCondition(&qdrant.Filter{
+			Should: []*qdrant.Condition{
+				qdrant.NewDatetimeRange("CreatedAt", &qdrant.DatetimeRange{Lt: timestamppb.New(filter.Before)}),
+				qdrant.NewFilterAsCondition(&qdrant.Filter{
+					Must: []*qdrant.Condition{
+						qdrant.NewIsEmpty("CreatedAt"),
+						qdrant.NewDatetimeRange("TTL", &qdrant.DatetimeRange{Lt: timestamppb.New(filter.Before.Add(24 * time.Hour))}),
+					},
+				}),
+			},
+		}))
+	}
+	return f
+}
+
+func (q *QdrantCache) DeleteEntries(ctx context.Context, filter DeleteFilter) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	conditions := deleteConditions(filter)
+	exact := true
+	//qdrant doesn't say how many points a delete removed, so they are counted first
+	count, err := q.Client.Count(ctx, &qdrant.CountPoints{
+		CollectionName: "AI_Gateway_Cache_1",
+		Filter:         conditions,
+		Exact:          &exact,
+	})
+	if err != nil {
+		return 0, err
+	}
+	if count == 0 {
+		return 0, nil
+	}
+	wait := true
+	if _, err := q.Client.Delete(ctx, &qdrant.DeletePoints{
+		CollectionName: "AI_Gateway_Cache_1",
+		Wait:           &wait,
+		Points:         qdrant.NewPointsSelectorFilter(conditions),
+	}); err != nil {
+		slog.Error("Got this error while deleting cache entries", "error", err)
+		return 0, err
+	}
+	return int(count), nil
+}
+
+func (q *QdrantCache) UpdateEntry(ctx context.Context, id string, update EntryUpdate) (Entry, error) {
+	e, err := q.GetEntry(ctx, id)
+	if err != nil {
+		return Entry{}, err
+	}
+	payload := map[string]any{}
+	if update.Answer != nil {
+		payload["CachedAnswer"] = *update.Answer
+		e.Answer = *update.Answer
+	}
+	if update.Pinned != nil {
+		payload["Pinned"] = *update.Pinned
+		e.Pinned = *update.Pinned
+	}
+	if update.Quarantined != nil {
+		payload["Quarantined"] = *update.Quarantined
+		e.Quarantined = *update.Quarantined
+	}
+	if len(payload) == 0 {
+		return e, nil
+	}
+	if err := q.setPayload(ctx, id, payload); err != nil {
+		return Entry{}, err
+	}
+	return e, nil
+}
+
+// RecordFeedback reads the counts and writes them back, qdrant can't increment a payload field.
+// Two votes on the same entry at the same moment can lose one, which the ratio shrugs off.
+func (q *QdrantCache) RecordFeedback(ctx context.Context, id string, up int, down int) (Entry, error) {
+	e, err := q.GetEntry(ctx, id)
+	if err != nil {
+		return Entry{}, err
+	}
+	e.FeedbackUp = max(e.FeedbackUp+up, 0)
+	e.FeedbackDown = max(e.FeedbackDown+down, 0)
+	if err := q.setPayload(ctx, id, map[string]any{
+		"FeedbackUp":   e.FeedbackUp,
+		"FeedbackDown": e.FeedbackDown,
+	}); err != nil {
+		return Entry{}, err
+	}
+	return e, nil
+}
+
+func (q *QdrantCache) setPayload(ctx context.Context, id string, payload map[string]any) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	wait := true
+	if _, err := q.Client.SetPayload(ctx, &qdrant.SetPayloadPoints{
+		CollectionName: "AI_Gateway_Cache_1",
+		Wait:           &wait,
+		Payload:        qdrant.NewValueMap(payload),
+		PointsSelector: qdrant.NewPointsSelector(qdrant.NewIDUUID(id)),
+	}); err != nil {
+		slog.Error("Got this error while updating a cache entry", "id", id, "error", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package cache
+
+import (
+	"fmt"
+	"math"
+)
+
+// DefaultThreshold is the similarity a cache hit needs until the config sets another one,
+// POST /admin/cache/calibrate tells how well it does on your own queries.
+const DefaultThreshold float32 = 0.85
+
+// CalibrationPair is two queries labeled with whether one's cached answer is right for the
+// other. Similarity is filled in once both have been embedded.
+type CalibrationPair struct {
+	A          string  `json:"a"`
+	B          string  `json:"b"`
+	Same       bool    `json:"same"`
+	Similarity float32 `json:"similarity"`
+}
+
+// ThresholdStats is how a threshold does on the labeled pairs, a pair at or above it would be
+// a cache hit. Precision is the share of those hits that are right, recall the share of the
+// pairs that should hit that do.
+type ThresholdStats struct {
+	Threshold      float32 `json:"threshold"`
+	TruePositives  int     `json:"true_positives"`
+	FalsePositives int     `json:"false_positives"`
+	FalseNegatives int     `json:"false_negatives"`
+	TrueNegatives  int     `json:"true_negatives"`
+	Precision      float64 `json:"precision"`
+	Recall         float64 `json:"recall"`
+	F1             float64 `json:"f1"`
+}
+
+// CalibrationThresholds are the thresholds tried when none are given, 0.70 to 0.99.
+func CalibrationThresholds() []float32 {
+	thresholds := make([]float32, 0, 30)
+	for i := 70; i < 100; i++ {
+		thresholds = append(thresholds, float32(i)/100)
+	}
+	return thresholds
+}
+
+func ValidateThresholds(thresholds []float32) error {
+	for _, t := range thresholds {
+		if t <= 0 || t > 1 {
+			return fmt.Errorf("thresholds must be in (0, 1], got %v", t)
+		}
+	}
+	return nil
+}
+
+// Calibrate scores every threshold on pairs, whose Similarity must be set.
+func Calibrate(pairs []CalibrationPair, thresholds []float32) []ThresholdStats {
+	stats := make([]ThresholdStats, 0, len(thresholds))
+	for _, t := range thresholds {
+		s := ThresholdStats{Threshold: t}
+		for _, p := range pairs {
+			hit := p.Similarity >= t
+			switch {
+			case hit && p.Same:
+				s.TruePositives++
+			case hit:
+				s.FalsePositives++
+			case p.Same:
+				s.FalseNegatives++
+			default:
+				s.TrueNegatives++
+			}
+		}
+		//no hits at all serves nothing wrong
+		s.Precision = 1
+		if hits := s.TruePositives + s.FalsePositives; hits > 0 {
+			s.Precision = float64(s.TruePositives) / float64(hits)
+		}
+		if same := s.TruePositives + s.FalseNegatives; same > 0 {
+			s.Recall = float64(s.TruePositives) / float64(same)
+		}
+		if s.Precision+s.Recall > 0 {
+			s.F1 = 2 * s.Precision * s.Recall / (s.Precision + s.Recall)
+		}
+		stats = append(stats, s)
+	}
+	return stats
+}
+
+// BestThreshold is the threshold with the highest F1, the higher one on a tie as a false hit
+// costs more than a miss.
+func BestThreshold(stats []ThresholdStats) (ThresholdStats, bool) {
+	var best ThresholdStats
+	found := false
+	for _, s := range stats {
+		if !found || s.F1 > best.F1+1e-9 || (math.Abs(s.F1-best.F1) <= 1e-9 && s.Threshold > best.Threshold) {
+			best, found = s, true
+		}
+	}
+	return best, found
+}
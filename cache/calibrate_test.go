@@ -0,0 +1,35 @@
+package cache
+
+import "testing"
+
+func TestCalibrate(t *testing.T) {
+	pairs := []CalibrationPair{
+		{Same: true, Similarity: 0.95},
+		{Same: true, Similarity: 0.88},
+		{Same: true, Similarity: 0.80},
+		{Same: false, Similarity: 0.86},
+		{Same: false, Similarity: 0.60},
+	}
+	stats := Calibrate(pairs, []float32{0.85, 0.9, 0.99})
+	s := stats[0]
+	if s.TruePositives != 2 || s.FalsePositives != 1 || s.FalseNegatives != 1 || s.TrueNegatives != 1 {
+		t.Fatalf("0.85: %+v", s)
+	}
+	if s.Precision < 0.66 || s.Precision > 0.67 || s.Recall < 0.66 || s.Recall > 0.67 {
+		t.Errorf("0.85: precision %v, recall %v", s.Precision, s.Recall)
+	}
+	if s := stats[1]; s.Precision != 1 || s.TruePositives != 1 {
+		t.Errorf("0.9: %+v", s)
+	}
+	if s := stats[2]; s.Precision != 1 || s.Recall != 0 || s.F1 != 0 {
+		t.Errorf("0.99 has no hits: %+v", s)
+	}
+	//0.8 gets every same pair for one false hit, which beats 0.85 and 0.9 on F1
+	best, _ := BestThreshold(Calibrate(pairs, []float32{0.8, 0.85, 0.9}))
+	if best.Threshold != 0.8 {
+		t.Errorf("best = %+v", best)
+	}
+	if len(CalibrationThresholds()) != 30 || ValidateThresholds([]float32{0.5, 1.2}) == nil {
+		t.Error("default thresholds or validation")
+	}
+}
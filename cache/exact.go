@@ -0,0 +1,104 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/Prateek-Gupta001/AI_Gateway/types"
+)
+
+// ExactCache is the in process tier in front of the vector search. It answers byte identical
+// repeats (after normalizing case and whitespace) without an embedding or a qdrant round trip.
+// It is an LRU bounded by size, and entries also expire after ttl, or sooner when the vector
+// cache keeps them for less.
+type ExactCache struct {
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	order    *list.List //front is the most recently used
+	entries  map[string]*list.Element
+	now      func() time.Time
+}
+
+type exactEntry struct {
+	hash    string
+	res     types.CacheResponse
+	expires time.Time
+}
+
+// NewExactCache returns an exact match tier holding up to capacity answers for ttl.
+// A capacity of 0 turns the tier off.
+func NewExactCache(capacity int, ttl time.Duration) *ExactCache {
+	return &ExactCache{
+		capacity: capacity,
+		ttl:      ttl,
+		order:    list.New(),
+		entries:  map[string]*list.Element{},
+		now:      time.Now,
+	}
+}
+
+func (c *ExactCache) Get(key Key) (types.CacheResponse, bool) {
+	if c == nil {
+		return types.CacheResponse{}, false
+	}
+	hash := key.Hash()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[hash]
+	if !ok {
+		return types.CacheResponse{}, false
+	}
+	e := el.Value.(*exactEntry)
+	if !c.now().Before(e.expires) {
+		c.order.Remove(el)
+		delete(c.entries, hash)
+		return types.CacheResponse{}, false
+	}
+	c.order.MoveToFront(el)
+	return e.res, true
+}
+
+// Put stores an answer for ttl, capped at the tier's own ttl.
+func (c *ExactCache) Put(key Key, res types.CacheResponse, ttl time.Duration) {
+	if c == nil || c.capacity <= 0 {
+		return
+	}
+	hash := key.Hash()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expires := c.now().Add(min(ttl, c.ttl))
+	if el, ok := c.entries[hash]; ok {
+		el.Value = &exactEntry{hash: hash, res: res, expires: expires}
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[hash] = c.order.PushFront(&exactEntry{hash: hash, res: res, expires: expires})
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*exactEntry).hash)
+	}
+}
+
+func (c *ExactCache) Len() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// Purge drops every answer, the cache admin api calls it after any change to the vector cache as
+// it can't tell which exact entries a change affected.
+func (c *ExactCache) Purge() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.order.Init()
+	c.entries = map[string]*list.Element{}
+}
@@ -0,0 +1,84 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Prateek-Gupta001/AI_Gateway/types"
+)
+
+func newTestExactCache(capacity int) (*ExactCache, *time.Time) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewExactCache(capacity, time.Minute)
+	c.now = func() time.Time { return now }
+	return c, &now
+}
+
+func TestExactCacheNormalizes(t *testing.T) {
+	c, _ := newTestExactCache(10)
+	c.Put(Key{Namespace: "tenant:acme", Query: "What is a  goroutine"}, types.CacheResponse{CachedAnswer: "a"}, time.Hour)
+	if res, ok := c.Get(Key{Namespace: "tenant:acme", Query: "what is a goroutine\n"}); !ok || res.CachedAnswer != "a" {
+		t.Errorf("normalized repeat missed: %+v %v", res, ok)
+	}
+	if _, ok := c.Get(Key{Namespace: "tenant:other", Query: "what is a goroutine"}); ok {
+		t.Error("hit across namespaces")
+	}
+	if _, ok := c.Get(Key{Namespace: "tenant:acme", Context: "abc", Query: "what is a goroutine"}); ok {
+		t.Error("hit across conversation contexts")
+	}
+	if _, ok := c.Get(Key{Namespace: "tenant:acme", Query: "what is a goroutine?"}); ok {
+		t.Error("exact tier matched a different query")
+	}
+}
+
+func TestExactCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c, _ := newTestExactCache(2)
+	c.Put(Key{Query: "a"}, types.CacheResponse{}, time.Hour)
+	c.Put(Key{Query: "b"}, types.CacheResponse{}, time.Hour)
+	c.Get(Key{Query: "a"})
+	c.Put(Key{Query: "c"}, types.CacheResponse{}, time.Hour)
+	if _, ok := c.Get(Key{Query: "b"}); ok {
+		t.Error("b should have been evicted")
+	}
+	if _, ok := c.Get(Key{Query: "a"}); !ok {
+		t.Error("a was used recently and should still be there")
+	}
+	if c.Len() != 2 {
+		t.Errorf("len = %d", c.Len())
+	}
+}
+
+func TestExactCacheTTL(t *testing.T) {
+	c, now := newTestExactCache(10)
+	c.Put(Key{Query: "a"}, types.CacheResponse{}, time.Hour)
+	*now = now.Add(59 * time.Second)
+	if _, ok := c.Get(Key{Query: "a"}); !ok {
+		t.Error("expired too early")
+	}
+	*now = now.Add(time.Second)
+	if _, ok := c.Get(Key{Query: "a"}); ok {
+		t.Error("entry outlived its ttl")
+	}
+	if c.Len() != 0 {
+		t.Errorf("expired entry wasn't dropped, len = %d", c.Len())
+	}
+	//the vector cache keeps this one for less than the tier's ttl
+	c.Put(Key{Query: "b"}, types.CacheResponse{}, 10*time.Second)
+	*now = now.Add(10 * time.Second)
+	if _, ok := c.Get(Key{Query: "b"}); ok {
+		t.Error("entry outlived the ttl it was put with")
+	}
+}
+
+func TestExactCacheDisabled(t *testing.T) {
+	c, _ := newTestExactCache(0)
+	c.Put(Key{Query: "a"}, types.CacheResponse{}, time.Hour)
+	if _, ok := c.Get(Key{Query: "a"}); ok {
+		t.Error("a 0 capacity cache stored something")
+	}
+	var nilCache *ExactCache
+	nilCache.Put(Key{Query: "a"}, types.CacheResponse{}, time.Hour)
+	if _, ok := nilCache.Get(Key{Query: "a"}); ok {
+		t.Error("nil cache hit")
+	}
+}
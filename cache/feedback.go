@@ -0,0 +1,44 @@
+package cache
+
+import "fmt"
+
+// QuarantinePolicy decides when the thumbs down on an entry are enough to take it out of the
+// cache. The zero value quarantines an entry once half of at least 3 votes are down.
+type QuarantinePolicy struct {
+	// Ratio is the share of down votes, in (0, 1], that triggers it. 0.5 when 0.
+	Ratio float64 `json:"ratio,omitempty"`
+	// MinVotes keeps a single early thumbs down from taking an entry out. 3 when 0.
+	MinVotes int `json:"min_votes,omitempty"`
+	// Evict deletes the entry instead of quarantining it, so its query is simply cached again
+	// on the next miss. Quarantined entries stay for an admin to look at.
+	Evict bool `json:"evict,omitempty"`
+	// Disabled only counts the votes.
+	Disabled bool `json:"disabled,omitempty"`
+}
+
+func (p QuarantinePolicy) Validate() error {
+	if p.Ratio < 0 || p.Ratio > 1 {
+		return fmt.Errorf("ratio must be in (0, 1], got %v", p.Ratio)
+	}
+	if p.MinVotes < 0 {
+		return fmt.Errorf("min_votes can't be negative")
+	}
+	return nil
+}
+
+// Triggered tells if the votes on e are bad enough to take it out. Pinned entries were vouched
+// for by an admin and are left alone.
+func (p QuarantinePolicy) Triggered(e Entry) bool {
+	if p.Disabled || e.Pinned || e.Quarantined {
+		return false
+	}
+	ratio, minVotes := p.Ratio, p.MinVotes
+	if ratio == 0 {
+		ratio = 0.5
+	}
+	if minVotes == 0 {
+		minVotes = 3
+	}
+	votes := e.FeedbackUp + e.FeedbackDown
+	return votes >= minVotes && float64(e.FeedbackDown) >= ratio*float64(votes)
+}
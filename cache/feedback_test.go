@@ -0,0 +1,27 @@
+package cache
+
+import "testing"
+
+func TestQuarantinePolicyTriggered(t *testing.T) {
+	cases := []struct {
+		policy QuarantinePolicy
+		entry  Entry
+		want   bool
+	}{
+		{QuarantinePolicy{}, Entry{FeedbackDown: 2}, false},
+		{QuarantinePolicy{}, Entry{FeedbackUp: 1, FeedbackDown: 2}, true},
+		{QuarantinePolicy{}, Entry{FeedbackUp: 3, FeedbackDown: 2}, false},
+		{QuarantinePolicy{Ratio: 0.25, MinVotes: 4}, Entry{FeedbackUp: 3, FeedbackDown: 1}, true},
+		{QuarantinePolicy{}, Entry{FeedbackDown: 5, Pinned: true}, false},
+		{QuarantinePolicy{}, Entry{FeedbackDown: 5, Quarantined: true}, false},
+		{QuarantinePolicy{Disabled: true}, Entry{FeedbackDown: 5}, false},
+	}
+	for i, c := range cases {
+		if got := c.policy.Triggered(c.entry); got != c.want {
+			t.Errorf("case %d: Triggered = %v, want %v", i, got, c.want)
+		}
+	}
+	if err := (QuarantinePolicy{Ratio: 1.5}).Validate(); err == nil {
+		t.Error("a ratio over 1 should not validate")
+	}
+}
@@ -0,0 +1,90 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"github.com/Prateek-Gupta001/AI_Gateway/types"
+	"github.com/google/uuid"
+)
+
+// Key is what a cached answer is stored under. Query is the last user message, it is the part that
+// gets embedded and matched semantically. Context is a hash of the system prompt and the turns before
+// it, which has to match exactly, so a follow up is only ever answered from the same conversation.
+type Key struct {
+	Namespace string
+	Context   string
+	Query     string
+}
+
+// Hash identifies the key up to case and whitespace in the query, it is what the exact match
+// tier stores answers under and what identical requests in flight are coalesced on.
+func (k Key) Hash() string {
+	sum := sha256.Sum256([]byte(k.Namespace + "\x00" + k.Context + "\x00" + normalizeQuery(k.Query)))
+	return hex.EncodeToString(sum[:])
+}
+
+// EntryId is the id an answer is stored under, the same query asked in two namespaces or
+// conversations has to be two entries.
+func EntryId(key Key) string {
+	return uuid.NewSHA1(uuid.NameSpaceOID, []byte(key.Namespace+"\x00"+key.Context+"\x00"+key.Query)).String()
+}
+
+// contextMessageChars is how much of every earlier message goes into the context, templated flows
+// differ in the first few sentences and a long answer shouldn't make the key expensive to build.
+const contextMessageChars = 500
+
+// ConversationKey builds the key of a conversation. depth is how many of the messages before the last
+// one (system prompts aside, they are always part of it) count towards the context, only the most
+// recent ones are kept. With depth 0 only conversations without earlier turns are cacheable, like
+// before. It reports false when the conversation can't be cached.
+func ConversationKey(namespace string, messages []types.Messages, depth int) (Key, bool) {
+	if len(messages) == 0 || messages[len(messages)-1].Role != types.RoleUser {
+		return Key{}, false
+	}
+	var system []string
+	var turns []types.Messages
+	for _, m := range messages[:len(messages)-1] {
+		if m.Role == types.RoleSystem {
+			system = append(system, normalizeMessage(m.Content))
+			continue
+		}
+		turns = append(turns, m)
+	}
+	if len(turns) > 0 && depth == 0 {
+		return Key{}, false
+	}
+	if len(turns) > depth {
+		turns = turns[len(turns)-depth:]
+	}
+	key := Key{Namespace: namespace, Query: messages[len(messages)-1].Content}
+	if len(system) == 0 && len(turns) == 0 {
+		//a plain single question has no context at all
+		return key, true
+	}
+	var b strings.Builder
+	for _, s := range system {
+		b.WriteString("system: " + s + "\n")
+	}
+	for _, m := range turns {
+		b.WriteString(string(m.Role) + ": " + normalizeMessage(m.Content) + "\n")
+	}
+	sum := sha256.Sum256([]byte(b.String()))
+	key.Context = hex.EncodeToString(sum[:])
+	return key, true
+}
+
+// normalizeQuery lowercases and collapses the whitespace of a message.
+func normalizeQuery(content string) string {
+	return strings.Join(strings.Fields(strings.ToLower(content)), " ")
+}
+
+// normalizeMessage normalizes a message and truncates it.
+func normalizeMessage(content string) string {
+	normalized := normalizeQuery(content)
+	if runes := []rune(normalized); len(runes) > contextMessageChars {
+		normalized = string(runes[:contextMessageChars])
+	}
+	return normalized
+}
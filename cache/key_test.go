@@ -0,0 +1,80 @@
+package cache
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Prateek-Gupta001/AI_Gateway/types"
+)
+
+func conversation(contents ...string) []types.Messages {
+	var messages []types.Messages
+	for i, c := range contents {
+		role := types.RoleUser
+		if i%2 == 1 {
+			role = types.RoleAssistant
+		}
+		messages = append(messages, types.Messages{Role: role, Content: c})
+	}
+	return messages
+}
+
+func TestConversationKeySingleQuestion(t *testing.T) {
+	key, ok := ConversationKey("tenant:acme", conversation("what is a goroutine"), 0)
+	if !ok || key.Context != "" || key.Query != "what is a goroutine" || key.Namespace != "tenant:acme" {
+		t.Errorf("key = %+v, ok = %v", key, ok)
+	}
+}
+
+func TestConversationKeyDepth(t *testing.T) {
+	messages := conversation("how do I create a project", "Click New Project", "and then?")
+	if _, ok := ConversationKey("ns", messages, 0); ok {
+		t.Error("follow ups can't be cached with depth 0")
+	}
+	a, ok := ConversationKey("ns", messages, 2)
+	if !ok || a.Context == "" || a.Query != "and then?" {
+		t.Fatalf("key = %+v, ok = %v", a, ok)
+	}
+	other := conversation("how do I delete a project", "Click Delete", "and then?")
+	if b, _ := ConversationKey("ns", other, 2); b.Context == a.Context {
+		t.Error("different conversations got the same context")
+	}
+
+	//only the last depth turns count
+	longer := append(conversation("hello", "hi!"), messages...)
+	if b, _ := ConversationKey("ns", longer, 2); b.Context != a.Context {
+		t.Error("turns older than the depth changed the context")
+	}
+	if b, _ := ConversationKey("ns", longer, 4); b.Context == a.Context {
+		t.Error("turns within the depth didn't change the context")
+	}
+}
+
+func TestConversationKeyNormalizes(t *testing.T) {
+	a, _ := ConversationKey("ns", conversation("How do I create   a project", "Click New Project", "and then?"), 2)
+	b, _ := ConversationKey("ns", conversation("how do i create a project\n", "click new  project", "and then?"), 2)
+	if a.Context != b.Context {
+		t.Error("case and whitespace changed the context")
+	}
+	long := strings.Repeat("a", contextMessageChars)
+	c, _ := ConversationKey("ns", conversation(long+" first tail", "ok", "next"), 2)
+	d, _ := ConversationKey("ns", conversation(long+" second tail", "ok", "next"), 2)
+	if c.Context != d.Context {
+		t.Error("messages aren't truncated")
+	}
+}
+
+func TestConversationKeySystemPrompt(t *testing.T) {
+	support := append([]types.Messages{{Role: types.RoleSystem, Content: "You are the support bot"}}, conversation("reset my password")...)
+	sales := append([]types.Messages{{Role: types.RoleSystem, Content: "You are the sales bot"}}, conversation("reset my password")...)
+	a, ok := ConversationKey("ns", support, 0)
+	if !ok || a.Context == "" {
+		t.Fatalf("key = %+v, ok = %v", a, ok)
+	}
+	if b, _ := ConversationKey("ns", sales, 0); b.Context == a.Context {
+		t.Error("the system prompt isn't part of the context")
+	}
+	if _, ok := ConversationKey("ns", conversation("hi", "hello"), 2); ok {
+		t.Error("a conversation ending on the assistant can't be cached")
+	}
+}
@@ -0,0 +1,441 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Prateek-Gupta001/AI_Gateway/types"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// MemoryCache is an in process Cache for local development, tests and small deployments that
+// don't want to run Qdrant. Search is a brute force cosine scan, which is fine up to a few tens
+// of thousands of entries. Entries expire after their ttl and the soonest to expire are dropped once
+// it is over capacity. It can be snapshotted to disk so a restart doesn't start cold.
+type MemoryCache struct {
+	mu        sync.RWMutex
+	Threshold float32
+	Sliding   bool
+	capacity  int
+	entries   map[string]*memoryEntry
+	now       func() time.Time
+}
+
+// memoryEntry has its fields exported so snapshots are plain json.
+type memoryEntry struct {
+	Id           string    `json:"id"`
+	Namespace    string    `json:"namespace"`
+	Context      string    `json:"context,omitempty"`
+	Query        string    `json:"query"`
+	Answer       string    `json:"answer"`
+	InputTokens  int       `json:"input_tokens"`
+	OutputTokens int       `json:"output_tokens"`
+	Vector       []float32 `json:"vector"` //normalized, so the cosine similarity is a dot product
+	Created      time.Time `json:"created"`
+	Expires      time.Time `json:"expires"`
+	TTLSeconds   int       `json:"ttl_seconds,omitempty"`
+	Pinned       bool      `json:"pinned,omitempty"`
+	FeedbackUp   int       `json:"feedback_up,omitempty"`
+	FeedbackDown int       `json:"feedback_down,omitempty"`
+	Quarantined  bool      `json:"quarantined,omitempty"`
+}
+
+// live tells if the entry can still be served, pinned ones never expire.
+func (e *memoryEntry) live(now time.Time) bool {
+	return e.Pinned || now.Before(e.Expires)
+}
+
+func NewMemoryCache(capacity int) *MemoryCache {
+	return &MemoryCache{
+		Threshold: DefaultThreshold,
+		capacity:  capacity,
+		entries:   map[string]*memoryEntry{},
+		now:       time.Now,
+	}
+}
+
+func (m *MemoryCache) SetThreshold(threshold float32) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Threshold = threshold
+}
+
+func (m *MemoryCache) SetSliding(sliding bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Sliding = sliding
+}
+
+func normalize(v types.Embedding) []float32 {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	out := make([]float32, len(v))
+	if sum == 0 {
+		return out
+	}
+	norm := math.Sqrt(sum)
+	for i, x := range v {
+		out[i] = float32(float64(x) / norm)
+	}
+	return out
+}
+
+func dot(a []float32, b []float32) float32 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var sum float32
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
+
+func (m *MemoryCache) ExistsInCache(ctx context.Context, key Key, Embedding types.Embedding) (types.CacheResponse, bool, error) {
+	_, span := Tracer.Start(ctx, "Memory.ExistsInCache")
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("user_query", key.Query),
+		attribute.String("namespace", key.Namespace),
+		attribute.Bool("has_context", key.Context != ""),
+	)
+	query := normalize(Embedding)
+	now := m.now()
+	m.mu.RLock()
+	var best *memoryEntry
+	bestScore := m.Threshold
+	for _, e := range m.entries {
+		if e.Namespace != key.Namespace || e.Context != key.Context || e.Quarantined || !e.live(now) {
+			continue
+		}
+		if score := dot(query, e.Vector); score >= bestScore {
+			best, bestScore = e, score
+		}
+	}
+	if best == nil {
+		m.mu.RUnlock()
+		slog.Info("Cache Miss!")
+		return types.CacheResponse{}, false, nil
+	}
+	res := types.CacheResponse{
+		Id:           best.Id,
+		CachedAnswer: best.Answer,
+		CachedQuery:  best.Query,
+		InputTokens:  best.InputTokens,
+		OutputTokens: best.OutputTokens,
+		Score:        bestScore,
+	}
+	sliding := m.Sliding && !best.Pinned
+	m.mu.RUnlock()
+	if sliding {
+		m.mu.Lock()
+		best.Expires = now.Add(ttlOrDefault(best.TTLSeconds))
+		m.mu.Unlock()
+	}
+	slog.Info("CACHE HIT! Found something in the memory cache!", "score", bestScore)
+	return res, true, nil
+}
+
+func (m *MemoryCache) InsertIntoCache(ctx context.Context, key Key, Embedding types.Embedding, llmResStruct types.LLMResponse, ttl time.Duration) {
+	_, span := Tracer.Start(ctx, "Memory.InsertIntoCache")
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("user_query", key.Query),
+		attribute.String("namespace", key.Namespace),
+	)
+	if m.capacity <= 0 {
+		return
+	}
+	now := m.now()
+	id := EntryId(key)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var prev *Entry
+	if old, ok := m.entries[id]; ok {
+		entry := old.entry()
+		prev = &entry
+	}
+	r, ok := replacement(prev, key, llmResStruct, ttl, now)
+	if !ok {
+		slog.Info("The query is already cached, keeping its entry", "id", id, "quarantined", prev.Quarantined)
+		return
+	}
+	e := &memoryEntry{
+		Id:           r.Id,
+		Namespace:    r.Namespace,
+		Context:      r.Context,
+		Query:        r.Query,
+		Answer:       r.Answer,
+		InputTokens:  r.InputTokens,
+		OutputTokens: r.OutputTokens,
+		Vector:       normalize(Embedding),
+		Created:      r.CreatedAt,
+		Expires:      r.ExpiresAt,
+		TTLSeconds:   r.TTLSeconds,
+		FeedbackUp:   r.FeedbackUp,
+		FeedbackDown: r.FeedbackDown,
+	}
+	m.entries[e.Id] = e
+	m.enforceCapacity()
+}
+
+// enforceCapacity drops expired entries and then the ones closest to expiring until it fits,
+// pinned ones only go when nothing else is left. Must be called with mu held.
+func (m *MemoryCache) enforceCapacity() {
+	if len(m.entries) <= m.capacity {
+		return
+	}
+	m.removeExpired()
+	for len(m.entries) > m.capacity {
+		var oldest *memoryEntry
+		for _, e := range m.entries {
+			if oldest == nil || (oldest.Pinned && !e.Pinned) || (oldest.Pinned == e.Pinned && e.Expires.Before(oldest.Expires)) {
+				oldest = e
+			}
+		}
+		delete(m.entries, oldest.Id)
+	}
+}
+
+// removeExpired must be called with mu held.
+func (m *MemoryCache) removeExpired() int {
+	now := m.now()
+	removed := 0
+	for id, e := range m.entries {
+		if !e.live(now) {
+			delete(m.entries, id)
+			removed++
+		}
+	}
+	return removed
+}
+
+func (m *MemoryCache) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.entries)
+}
+
+// Snapshot writes every live entry to path. It writes a temp file and renames it, so a crash
+// in the middle never leaves a half written snapshot behind.
+func (m *MemoryCache) Snapshot(path string) error {
+	m.mu.RLock()
+	now := m.now()
+	entries := make([]*memoryEntry, 0, len(m.entries))
+	for _, e := range m.entries {
+		if e.live(now) {
+			entries = append(entries, e)
+		}
+	}
+	data, err := json.Marshal(entries)
+	m.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadSnapshot adds the entries of a snapshot that haven't expired yet. A missing file is not an error,
+// that is just the first start.
+func (m *MemoryCache) LoadSnapshot(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var entries []*memoryEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	for _, e := range entries {
+		if e.live(now) {
+			m.entries[e.Id] = e
+		}
+	}
+	m.enforceCapacity()
+	return nil
+}
+
+// ReviseCache drops the expired entries every interval and, when snapshotPath is set, snapshots
+// the cache then and one last time on shutdown.
+func (m *MemoryCache) ReviseCache(ctx context.Context, interval time.Duration, snapshotPath string) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.mu.Lock()
+			removed := m.removeExpired()
+			m.mu.Unlock()
+			slog.Info("Memory cache cleanup done", "removed", removed)
+			if snapshotPath != "" {
+				if err := m.Snapshot(snapshotPath); err != nil {
+					slog.Error("Got this error while trying to snapshot the memory cache", "path", snapshotPath, "error", err)
+				}
+			}
+		case <-ctx.Done():
+			if snapshotPath != "" {
+				if err := m.Snapshot(snapshotPath); err != nil {
+					slog.Error("Got this error while trying to snapshot the memory cache", "path", snapshotPath, "error", err)
+				}
+			}
+			slog.Info("Stopping memory cache cleanup job...")
+			return
+		}
+	}
+}
+
+func (e *memoryEntry) entry() Entry {
+	return Entry{
+		Id:           e.Id,
+		Namespace:    e.Namespace,
+		Context:      e.Context,
+		Query:        e.Query,
+		Answer:       e.Answer,
+		InputTokens:  e.InputTokens,
+		OutputTokens: e.OutputTokens,
+		CreatedAt:    e.Created,
+		ExpiresAt:    e.Expires,
+		TTLSeconds:   int(ttlOrDefault(e.TTLSeconds) / time.Second),
+		Pinned:       e.Pinned,
+		FeedbackUp:   e.FeedbackUp,
+		FeedbackDown: e.FeedbackDown,
+		Quarantined:  e.Quarantined,
+	}
+}
+
+func (m *MemoryCache) ListEntries(ctx context.Context, opts ListOptions) (EntryPage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	ids := make([]string, 0, len(m.entries))
+	for id, e := range m.entries {
+		if id >= opts.Cursor && (opts.Namespace == "" || e.Namespace == opts.Namespace) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	page := EntryPage{Entries: []Entry{}}
+	for i, id := range ids {
+		if i == opts.Limit {
+			page.NextCursor = id
+			break
+		}
+		page.Entries = append(page.Entries, m.entries[id].entry())
+	}
+	return page, nil
+}
+
+func (m *MemoryCache) GetEntry(ctx context.Context, id string) (Entry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	e, ok := m.entries[id]
+	if !ok {
+		return Entry{}, ErrEntryNotFound
+	}
+	return e.entry(), nil
+}
+
+func (m *MemoryCache) SearchEntries(ctx context.Context, namespace string, Embedding types.Embedding, limit int) ([]Entry, error) {
+	query := normalize(Embedding)
+	m.mu.RLock()
+	entries := make([]Entry, 0, len(m.entries))
+	for _, e := range m.entries {
+		if namespace != "" && e.Namespace != namespace {
+			continue
+		}
+		found := e.entry()
+		found.Score = dot(query, e.Vector)
+		entries = append(entries, found)
+	}
+	m.mu.RUnlock()
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Score > entries[j].Score })
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries, nil
+}
+
+func (f DeleteFilter) matches(e *memoryEntry) bool {
+	if len(f.Ids) > 0 && !slices.Contains(f.Ids, e.Id) {
+		return false
+	}
+	if f.Namespace != "" && e.Namespace != f.Namespace {
+		return false
+	}
+	if f.Query != "" && e.Query != f.Query {
+		return false
+	}
+	return f.Before.IsZero() || e.Created.Before(f.Before)
+}
+
+func (m *MemoryCache) DeleteEntries(ctx context.Context, filter DeleteFilter) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	deleted := 0
+	for id, e := range m.entries {
+		if filter.matches(e) {
+			delete(m.entries, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (m *MemoryCache) UpdateEntry(ctx context.Context, id string, update EntryUpdate) (Entry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[id]
+	if !ok {
+		return Entry{}, ErrEntryNotFound
+	}
+	if update.Answer != nil {
+		e.Answer = *update.Answer
+	}
+	if update.Pinned != nil {
+		e.Pinned = *update.Pinned
+	}
+	if update.Quarantined != nil {
+		e.Quarantined = *update.Quarantined
+	}
+	return e.entry(), nil
+}
+
+func (m *MemoryCache) RecordFeedback(ctx context.Context, id string, up int, down int) (Entry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[id]
+	if !ok {
+		return Entry{}, ErrEntryNotFound
+	}
+	e.FeedbackUp = max(e.FeedbackUp+up, 0)
+	e.FeedbackDown = max(e.FeedbackDown+down, 0)
+	return e.entry(), nil
+}
@@ -0,0 +1,260 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Prateek-Gupta001/AI_Gateway/types"
+)
+
+func newTestMemoryCache(capacity int) (*MemoryCache, *time.Time) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	m := NewMemoryCache(capacity)
+	m.now = func() time.Time { return now }
+	return m, &now
+}
+
+func answer(text string) types.LLMResponse {
+	return types.LLMResponse{LLMRes: bytes.NewBufferString(text), InputTokens: 1, OutputTokens: 2}
+}
+
+func TestMemoryCacheSearch(t *testing.T) {
+	ctx := context.Background()
+	m, _ := newTestMemoryCache(10)
+	key := Key{Namespace: "tenant:acme", Query: "what is a goroutine"}
+	m.InsertIntoCache(ctx, key, types.Embedding{1, 0, 0}, answer("a lightweight thread"), time.Hour)
+	m.InsertIntoCache(ctx, Key{Namespace: "tenant:acme", Query: "what is a channel"}, types.Embedding{0, 1, 0}, answer("a pipe"), time.Hour)
+
+	res, ok, err := m.ExistsInCache(ctx, Key{Namespace: "tenant:acme", Query: "whats a goroutine"}, types.Embedding{2, 0.2, 0})
+	if err != nil || !ok || res.CachedAnswer != "a lightweight thread" || res.CachedQuery != "what is a goroutine" || res.Score < 0.99 {
+		t.Errorf("res = %+v, ok = %v, err = %v", res, ok, err)
+	}
+	if _, ok, _ := m.ExistsInCache(ctx, Key{Namespace: "tenant:acme"}, types.Embedding{1, 1, 0}); ok {
+		t.Error("a 0.7 similarity is below the threshold")
+	}
+	if _, ok, _ := m.ExistsInCache(ctx, Key{Namespace: "tenant:other"}, types.Embedding{1, 0, 0}); ok {
+		t.Error("hit across namespaces")
+	}
+	if _, ok, _ := m.ExistsInCache(ctx, Key{Namespace: "tenant:acme", Context: "abc"}, types.Embedding{1, 0, 0}); ok {
+		t.Error("hit across conversation contexts")
+	}
+	m.SetThreshold(0.7)
+	if _, ok, _ := m.ExistsInCache(ctx, Key{Namespace: "tenant:acme"}, types.Embedding{1, 1, 0}); !ok {
+		t.Error("the new threshold wasn't used")
+	}
+}
+
+func TestMemoryCacheExpiryAndCapacity(t *testing.T) {
+	ctx := context.Background()
+	m, now := newTestMemoryCache(2)
+	m.InsertIntoCache(ctx, Key{Query: "a"}, types.Embedding{1, 0}, answer("a"), time.Hour)
+	*now = now.Add(time.Minute)
+	m.InsertIntoCache(ctx, Key{Query: "b"}, types.Embedding{0, 1}, answer("b"), time.Hour)
+	*now = now.Add(time.Minute)
+	m.InsertIntoCache(ctx, Key{Query: "c"}, types.Embedding{1, 1}, answer("c"), time.Hour)
+	if m.Len() != 2 {
+		t.Fatalf("len = %d", m.Len())
+	}
+	if _, ok, _ := m.ExistsInCache(ctx, Key{}, types.Embedding{1, 0}); ok {
+		t.Error("the oldest entry should have been dropped")
+	}
+	*now = now.Add(59 * time.Minute)
+	if _, ok, _ := m.ExistsInCache(ctx, Key{}, types.Embedding{0, 1}); ok {
+		t.Error("b outlived its ttl")
+	}
+	if _, ok, _ := m.ExistsInCache(ctx, Key{}, types.Embedding{1, 1}); !ok {
+		t.Error("c expired too early")
+	}
+}
+
+func TestMemoryCacheSnapshot(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "cache.json")
+	m, now := newTestMemoryCache(10)
+	m.InsertIntoCache(ctx, Key{Namespace: "global", Query: "a"}, types.Embedding{1, 0}, answer("answer a"), time.Hour)
+	*now = now.Add(30 * time.Minute)
+	m.InsertIntoCache(ctx, Key{Namespace: "global", Query: "b"}, types.Embedding{0, 1}, answer("answer b"), time.Hour)
+	if err := m.Snapshot(path); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, later := newTestMemoryCache(10)
+	*later = now.Add(45 * time.Minute) //a has expired by then, b hasn't
+	if err := restored.LoadSnapshot(path); err != nil {
+		t.Fatal(err)
+	}
+	if restored.Len() != 1 {
+		t.Errorf("len = %d", restored.Len())
+	}
+	res, ok, _ := restored.ExistsInCache(ctx, Key{Namespace: "global"}, types.Embedding{0, 1})
+	if !ok || res.CachedAnswer != "answer b" || res.InputTokens != 1 {
+		t.Errorf("res = %+v, ok = %v", res, ok)
+	}
+
+	if err := restored.LoadSnapshot(filepath.Join(t.TempDir(), "missing.json")); err != nil {
+		t.Errorf("a missing snapshot should be fine, got %v", err)
+	}
+}
+
+func TestMemoryCacheAdmin(t *testing.T) {
+	ctx := context.Background()
+	m, now := newTestMemoryCache(10)
+	for _, q := range []string{"a", "b", "c"} {
+		m.InsertIntoCache(ctx, Key{Namespace: "tenant:acme", Query: q}, types.Embedding{1, 0}, answer("answer "+q), time.Hour)
+		*now = now.Add(time.Minute)
+	}
+	m.InsertIntoCache(ctx, Key{Namespace: "global", Query: "d"}, types.Embedding{0, 1}, answer("answer d"), time.Hour)
+
+	page, _ := m.ListEntries(ctx, ListOptions{Namespace: "tenant:acme", Limit: 2})
+	if len(page.Entries) != 2 || page.NextCursor == "" {
+		t.Fatalf("first page = %+v", page)
+	}
+	rest, _ := m.ListEntries(ctx, ListOptions{Namespace: "tenant:acme", Limit: 2, Cursor: page.NextCursor})
+	if len(rest.Entries) != 1 || rest.NextCursor != "" || rest.Entries[0].Id == page.Entries[1].Id {
+		t.Errorf("second page = %+v", rest)
+	}
+
+	found, _ := m.SearchEntries(ctx, "", types.Embedding{0, 1}, 2)
+	if len(found) != 2 || found[0].Query != "d" || found[0].Score < 0.99 {
+		t.Errorf("search = %+v", found)
+	}
+
+	id := EntryId(Key{Namespace: "tenant:acme", Query: "a"})
+	fixed, pinned := "fixed", true
+	if e, err := m.UpdateEntry(ctx, id, EntryUpdate{Answer: &fixed, Pinned: &pinned}); err != nil || e.Answer != "fixed" || !e.Pinned {
+		t.Errorf("update = %+v, %v", e, err)
+	}
+	if _, err := m.UpdateEntry(ctx, "missing", EntryUpdate{Pinned: &pinned}); err != ErrEntryNotFound {
+		t.Errorf("updating a missing entry got %v", err)
+	}
+	*now = now.Add(2 * time.Hour)
+	m.mu.Lock()
+	m.removeExpired()
+	m.mu.Unlock()
+	if m.Len() != 1 {
+		t.Errorf("only the pinned entry should be left, len = %d", m.Len())
+	}
+	res, ok, _ := m.ExistsInCache(ctx, Key{Namespace: "tenant:acme"}, types.Embedding{1, 0})
+	if !ok || res.CachedAnswer != "fixed" {
+		t.Errorf("pinned entry res = %+v, ok = %v", res, ok)
+	}
+}
+
+func TestMemoryCacheDeleteEntries(t *testing.T) {
+	ctx := context.Background()
+	m, now := newTestMemoryCache(10)
+	m.InsertIntoCache(ctx, Key{Namespace: "tenant:acme", Query: "old"}, types.Embedding{1, 0}, answer("x"), time.Hour)
+	*now = now.Add(30 * time.Minute)
+	m.InsertIntoCache(ctx, Key{Namespace: "tenant:acme", Query: "new"}, types.Embedding{1, 0}, answer("x"), time.Hour)
+	m.InsertIntoCache(ctx, Key{Namespace: "global", Query: "old"}, types.Embedding{1, 0}, answer("x"), time.Hour)
+
+	if n, _ := m.DeleteEntries(ctx, DeleteFilter{Namespace: "tenant:acme", Query: "old"}); n != 1 {
+		t.Errorf("deleted %d by namespace and query", n)
+	}
+	if n, _ := m.DeleteEntries(ctx, DeleteFilter{Before: now.Add(-time.Minute)}); n != 0 {
+		t.Errorf("deleted %d entries that aren't old enough", n)
+	}
+	if n, _ := m.DeleteEntries(ctx, DeleteFilter{Ids: []string{EntryId(Key{Namespace: "global", Query: "old"})}}); n != 1 {
+		t.Errorf("deleted %d by id", n)
+	}
+	if m.Len() != 1 {
+		t.Errorf("len = %d", m.Len())
+	}
+}
+
+func TestMemoryCacheSlidingTTL(t *testing.T) {
+	ctx := context.Background()
+	m, now := newTestMemoryCache(10)
+	m.InsertIntoCache(ctx, Key{Query: "short"}, types.Embedding{1, 0}, answer("a"), 10*time.Minute)
+	m.InsertIntoCache(ctx, Key{Query: "long"}, types.Embedding{0, 1}, answer("b"), time.Hour)
+
+	*now = now.Add(9 * time.Minute)
+	if _, ok, _ := m.ExistsInCache(ctx, Key{}, types.Embedding{1, 0}); !ok {
+		t.Fatal("short expired too early")
+	}
+	*now = now.Add(2 * time.Minute)
+	if _, ok, _ := m.ExistsInCache(ctx, Key{}, types.Embedding{1, 0}); ok {
+		t.Error("short outlived its ttl without sliding")
+	}
+
+	m.SetSliding(true)
+	for i := 0; i < 3; i++ {
+		*now = now.Add(40 * time.Minute)
+		if _, ok, _ := m.ExistsInCache(ctx, Key{}, types.Embedding{0, 1}); !ok {
+			t.Fatalf("long expired although it was hit every 40 minutes (hit %d)", i)
+		}
+	}
+	e, _ := m.GetEntry(ctx, EntryId(Key{Query: "long"}))
+	if !e.ExpiresAt.Equal(now.Add(time.Hour)) || e.TTLSeconds != 3600 {
+		t.Errorf("entry = %+v", e)
+	}
+}
+
+func TestMemoryCacheQuarantine(t *testing.T) {
+	ctx := context.Background()
+	m, now := newTestMemoryCache(10)
+	key := Key{Namespace: "global", Query: "what is a goroutine"}
+	m.InsertIntoCache(ctx, key, types.Embedding{1, 0, 0}, answer("a process"), time.Hour)
+	res, ok, _ := m.ExistsInCache(ctx, key, types.Embedding{1, 0, 0})
+	if !ok || res.Id != EntryId(key) {
+		t.Fatalf("hit %v, id %q", ok, res.Id)
+	}
+
+	m.RecordFeedback(ctx, res.Id, 1, 2)
+	e, err := m.RecordFeedback(ctx, res.Id, -1, 0)
+	if err != nil || e.FeedbackUp != 0 || e.FeedbackDown != 2 {
+		t.Errorf("entry %+v, err %v", e, err)
+	}
+	if _, err := m.RecordFeedback(ctx, "missing", 1, 0); err != ErrEntryNotFound {
+		t.Errorf("err = %v", err)
+	}
+
+	quarantined := true
+	m.UpdateEntry(ctx, res.Id, EntryUpdate{Quarantined: &quarantined})
+	if _, ok, _ := m.ExistsInCache(ctx, key, types.Embedding{1, 0, 0}); ok {
+		t.Error("a quarantined entry was served")
+	}
+	//a new answer to the same query doesn't replace it until it is released
+	m.InsertIntoCache(ctx, key, types.Embedding{1, 0, 0}, answer("a lightweight thread"), time.Hour)
+	if e, _ := m.GetEntry(ctx, res.Id); e.Answer != "a process" || !e.Quarantined {
+		t.Errorf("entry after a new insert %+v", e)
+	}
+	quarantined = false
+	m.UpdateEntry(ctx, res.Id, EntryUpdate{Quarantined: &quarantined})
+	*now = now.Add(2 * time.Hour)
+	m.InsertIntoCache(ctx, key, types.Embedding{1, 0, 0}, answer("a lightweight thread"), time.Hour)
+	if e, _ := m.GetEntry(ctx, res.Id); e.Answer != "a lightweight thread" || e.FeedbackDown != 2 {
+		t.Errorf("entry after it was released %+v", e)
+	}
+}
+
+func TestMemoryCacheReinsertKeepsEntry(t *testing.T) {
+	ctx := context.Background()
+	m, now := newTestMemoryCache(10)
+	key := Key{Namespace: "global", Query: "what is a goroutine"}
+	m.InsertIntoCache(ctx, key, types.Embedding{1, 0, 0}, answer("a process"), time.Hour)
+	edited, pinned := "a lightweight thread", true
+	m.UpdateEntry(ctx, EntryId(key), EntryUpdate{Answer: &edited, Pinned: &pinned})
+
+	//a late insert of the same query, as the lazy caching path does
+	m.InsertIntoCache(ctx, key, types.Embedding{1, 0, 0}, answer("a process"), time.Hour)
+	if e, _ := m.GetEntry(ctx, EntryId(key)); !e.Pinned || e.Answer != edited {
+		t.Errorf("live entry after a re-insert %+v", e)
+	}
+	*now = now.Add(2 * time.Hour)
+	m.InsertIntoCache(ctx, key, types.Embedding{1, 0, 0}, answer("a process"), time.Hour)
+	if e, _ := m.GetEntry(ctx, EntryId(key)); !e.Pinned || e.Answer != edited {
+		t.Errorf("pinned entry after a re-insert past its ttl %+v", e)
+	}
+
+	other := Key{Namespace: "global", Query: "what is a channel"}
+	m.InsertIntoCache(ctx, other, types.Embedding{0, 1, 0}, answer("a pipe"), time.Hour)
+	*now = now.Add(2 * time.Hour)
+	m.InsertIntoCache(ctx, other, types.Embedding{0, 1, 0}, answer("a typed pipe"), time.Hour)
+	if e, _ := m.GetEntry(ctx, EntryId(other)); e.Answer != "a typed pipe" {
+		t.Errorf("an expired entry should be replaced, got %+v", e)
+	}
+}
@@ -0,0 +1,435 @@
+package cache
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Prateek-Gupta001/AI_Gateway/types"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// PgVectorCache keeps the semantic cache in Postgres with the pgvector extension, on the same
+// connection as the store, so smaller deployments don't have to run Qdrant as well.
+type PgVectorCache struct {
+	db        *sql.DB
+	Threshold float32
+	Sliding   bool
+	mu        sync.RWMutex
+	// iterativeScan is set when pgvector is 0.8 or newer, see searchSettings.
+	iterativeScan bool
+}
+
+// NewPgVectorCache creates the extension, the table and its indexes if they don't exist yet.
+// dims has to match the embedding service (384 for the current model).
+func NewPgVectorCache(db *sql.DB, dims int) (*PgVectorCache, error) {
+	query1 := `CREATE EXTENSION IF NOT EXISTS vector`
+	if _, err1 := db.Exec(query1); err1 != nil {
+		slog.Error("Got this error while trying to create the pgvector extension", "error", err1)
+		return nil, err1
+	}
+	query2 := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS Semantic_Cache(
+	id UUID primary key,
+	namespace TEXT NOT NULL,
+	context TEXT NOT NULL default '',
+	query TEXT NOT NULL,
+	answer TEXT NOT NULL,
+	input_tokens INT NOT NULL default 0,
+	output_tokens INT NOT NULL default 0,
+	embedding vector(%d) NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL
+	)`, dims)
+	if _, err2 := db.Exec(query2); err2 != nil {
+		slog.Error("Got this error while trying to create table semantic cache", "error", err2)
+		return nil, err2
+	}
+	//hnsw keeps the nearest neighbour search from scanning the whole table
+	query3 := `CREATE INDEX IF NOT EXISTS semantic_cache_embedding_idx ON Semantic_Cache USING hnsw (embedding vector_cosine_ops)`
+	if _, err3 := db.Exec(query3); err3 != nil {
+		slog.Error("Got this error while trying to create the embedding index", "error", err3)
+		return nil, err3
+	}
+	query4 := `CREATE INDEX IF NOT EXISTS semantic_cache_namespace_idx ON Semantic_Cache (namespace, context)`
+	if _, err4 := db.Exec(query4); err4 != nil {
+		slog.Error("Got this error while trying to create the namespace index", "error", err4)
+		return nil, err4
+	}
+	query5 := `CREATE INDEX IF NOT EXISTS semantic_cache_expires_idx ON Semantic_Cache (expires_at)`
+	if _, err5 := db.Exec(query5); err5 != nil {
+		slog.Error("Got this error while trying to create the expiry index", "error", err5)
+		return nil, err5
+	}
+	query6 := `ALTER TABLE Semantic_Cache ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL default now(),
+	ADD COLUMN IF NOT EXISTS pinned BOOLEAN NOT NULL default false`
+	if _, err6 := db.Exec(query6); err6 != nil {
+		slog.Error("Got this error while trying to add the created_at and pinned columns", "error", err6)
+		return nil, err6
+	}
+	query7 := `ALTER TABLE Semantic_Cache ADD COLUMN IF NOT EXISTS ttl_seconds INT NOT NULL default 86400`
+	if _, err7 := db.Exec(query7); err7 != nil {
+		slog.Error("Got this error while trying to add the ttl_seconds column", "error", err7)
+		return nil, err7
+	}
+	query8 := `ALTER TABLE Semantic_Cache ADD COLUMN IF NOT EXISTS feedback_up INT NOT NULL default 0,
+	ADD COLUMN IF NOT EXISTS feedback_down INT NOT NULL default 0,
+	ADD COLUMN IF NOT EXISTS quarantined BOOLEAN NOT NULL default false`
+	if _, err8 := db.Exec(query8); err8 != nil {
+		slog.Error("Got this error while trying to add the feedback columns", "error", err8)
+		return nil, err8
+	}
+	var version string
+	query9 := `SELECT extversion FROM pg_extension WHERE extname = 'vector'`
+	if err9 := db.QueryRow(query9).Scan(&version); err9 != nil {
+		slog.Error("Got this error while trying to read the pgvector version", "error", err9)
+		return nil, err9
+	}
+	iterativeScan := supportsIterativeScan(version)
+	if !iterativeScan {
+		slog.Warn("pgvector is older than 0.8, searches fall back to a wide ef_search and can still miss in a big shared table", "version", version)
+	}
+	return &PgVectorCache{db: db, Threshold: DefaultThreshold, iterativeScan: iterativeScan}, nil
+}
+
+// supportsIterativeScan reports if a pgvector version has hnsw.iterative_scan, which came in 0.8.0.
+func supportsIterativeScan(version string) bool {
+	parts := strings.SplitN(version, ".", 3)
+	if len(parts) < 2 {
+		return false
+	}
+	major, err1 := strconv.Atoi(parts[0])
+	minor, err2 := strconv.Atoi(parts[1])
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	return major > 0 || minor >= 8
+}
+
+// searchSettings is what a search sets for its own transaction. The hnsw index hands back its
+// ef_search nearest rows and the namespace and context filter only runs after that, so in a
+// table shared by many tenants the nearest row of this one is often not among them and the
+// search comes back empty. With iterative_scan the index keeps going until a row passes the
+// filter, older versions only get a wider candidate list (1000 is its maximum).
+func (p *PgVectorCache) searchSettings() string {
+	if p.iterativeScan {
+		return `SET LOCAL hnsw.iterative_scan = strict_order`
+	}
+	return `SET LOCAL hnsw.ef_search = 1000`
+}
+
+func (p *PgVectorCache) SetThreshold(threshold float32) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Threshold = threshold
+}
+
+func (p *PgVectorCache) threshold() float32 {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.Threshold
+}
+
+func (p *PgVectorCache) SetSliding(sliding bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Sliding = sliding
+}
+
+func (p *PgVectorCache) sliding() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.Sliding
+}
+
+// vectorLiteral is the text form pgvector parses, '[0.1,0.2,...]'.
+func vectorLiteral(v types.Embedding) string {
+	var b strings.Builder
+	b.WriteByte('[')
+	for i, x := range v {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(strconv.FormatFloat(float64(x), 'g', -1, 32))
+	}
+	b.WriteByte(']')
+	return b.String()
+}
+
+func (p *PgVectorCache) ExistsInCache(ctx context.Context, key Key, Embedding types.Embedding) (types.CacheResponse, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	ctx, span := Tracer.Start(ctx, "PgVector.ExistsInCache")
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("user_query", key.Query),
+		attribute.String("namespace", key.Namespace),
+		attribute.Bool("has_context", key.Context != ""),
+	)
+	//<=> is the cosine distance, ordering on it is what lets the hnsw index be used
+	query := `SELECT id, pinned, query, answer, input_tokens, output_tokens, 1 - (embedding <=> $1::vector)
+	FROM Semantic_Cache
+	WHERE namespace = $2 AND context = $3 AND (pinned OR expires_at > now()) AND NOT quarantined
+	ORDER BY embedding <=> $1::vector
+	LIMIT 1`
+	tx, err := p.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		slog.Info("Got this error while trying to start the cache search", "error", err)
+		return types.CacheResponse{}, false, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, p.searchSettings()); err != nil {
+		slog.Info("Got this error while trying to set up the cache search", "error", err)
+		return types.CacheResponse{}, false, err
+	}
+	var res types.CacheResponse
+	var pinned bool
+	var score float64
+	err = tx.QueryRowContext(ctx, query, vectorLiteral(Embedding), key.Namespace, key.Context).Scan(
+		&res.Id,
+		&pinned,
+		&res.CachedQuery,
+		&res.CachedAnswer,
+		&res.InputTokens,
+		&res.OutputTokens,
+		&score,
+	)
+	if err == sql.ErrNoRows {
+		slog.Info("Cache Miss!")
+		return types.CacheResponse{}, false, nil
+	}
+	if err != nil {
+		slog.Info("Got this error while trying to find if it ExistsInCache", "error", err)
+		return types.CacheResponse{}, false, err
+	}
+	res.Score = float32(score)
+	if res.Score < p.threshold() {
+		slog.Info("Cache Miss!", "closest", res.Score)
+		return types.CacheResponse{}, false, nil
+	}
+	if p.sliding() && !pinned {
+		go p.extend(context.WithoutCancel(ctx), res.Id)
+	}
+	slog.Info("CACHE HIT! Found something in the cache!", "score", res.Score)
+	return res, true, nil
+}
+
+// extend pushes the expiry of a row that was just hit back by its ttl.
+func (p *PgVectorCache) extend(ctx context.Context, id string) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	query := `UPDATE Semantic_Cache SET expires_at = now() + ttl_seconds * interval '1 second' WHERE id = $1`
+	if _, err := p.db.ExecContext(ctx, query, id); err != nil {
+		slog.Error("Got this error while extending the ttl of a cache entry", "error", err)
+	}
+}
+
+func (p *PgVectorCache) InsertIntoCache(ctx context.Context, key Key, Embedding types.Embedding, llmResStruct types.LLMResponse, ttl time.Duration) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	ctx, span := Tracer.Start(ctx, "PgVector.InsertIntoCache")
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("user_query", key.Query),
+		attribute.String("namespace", key.Namespace),
+	)
+	//the rules of replacement, in the upsert so they hold against concurrent inserts
+	query := `INSERT INTO Semantic_Cache(id, namespace, context, query, answer, input_tokens, output_tokens, embedding, expires_at, ttl_seconds)
+	VALUES($1, $2, $3, $4, $5, $6, $7, $8::vector, $9, $10)
+	ON CONFLICT (id) DO UPDATE SET answer = EXCLUDED.answer,
+	created_at = now(),
+	input_tokens = EXCLUDED.input_tokens,
+	output_tokens = EXCLUDED.output_tokens,
+	embedding = EXCLUDED.embedding,
+	expires_at = EXCLUDED.expires_at,
+	ttl_seconds = EXCLUDED.ttl_seconds
+	WHERE NOT Semantic_Cache.quarantined AND NOT Semantic_Cache.pinned AND Semantic_Cache.expires_at <= now()`
+	if _, err := p.db.ExecContext(ctx, query,
+		EntryId(key),
+		key.Namespace,
+		key.Context,
+		key.Query,
+		llmResStruct.LLMRes.String(),
+		llmResStruct.InputTokens,
+		llmResStruct.OutputTokens,
+		vectorLiteral(Embedding),
+		time.Now().Add(ttl),
+		int(ttl/time.Second),
+	); err != nil {
+		slog.Error("Got this error while trying to insert the query into the cache!", "error", err)
+		return
+	}
+	slog.Info("Insertion into cache successful!")
+}
+
+func (p *PgVectorCache) ReviseCache(ctx context.Context, interval time.Duration) {
+	//this function goes via the cache table and removes the rows that have exceeded their TTL.
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			slog.Info("Our periodic cache cleanup has begun!")
+			res, err := p.db.ExecContext(ctx, `DELETE FROM Semantic_Cache WHERE expires_at <= now() AND NOT pinned`)
+			if err != nil {
+				slog.Error("Got this error while revising/clearing the cache ", "error", err)
+				continue
+			}
+			deleted, _ := res.RowsAffected()
+			slog.Info("Periodic Cache cleanup was succesful", "deleted", deleted)
+		case <-ctx.Done():
+			slog.Info("Stopping cache cleanup job...")
+			return
+		}
+	}
+}
+
+const entryColumns = `id, namespace, context, query, answer, input_tokens, output_tokens, created_at, expires_at, ttl_seconds, pinned, feedback_up, feedback_down, quarantined`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanEntry(row rowScanner, extra ...any) (Entry, error) {
+	var e Entry
+	err := row.Scan(append([]any{
+		&e.Id,
+		&e.Namespace,
+		&e.Context,
+		&e.Query,
+		&e.Answer,
+		&e.InputTokens,
+		&e.OutputTokens,
+		&e.CreatedAt,
+		&e.ExpiresAt,
+		&e.TTLSeconds,
+		&e.Pinned,
+		&e.FeedbackUp,
+		&e.FeedbackDown,
+		&e.Quarantined,
+	}, extra...)...)
+	return e, err
+}
+
+func (p *PgVectorCache) ListEntries(ctx context.Context, opts ListOptions) (EntryPage, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	//one row more than asked for tells where the next page starts
+	query := `SELECT ` + entryColumns + ` FROM Semantic_Cache
+	WHERE ($1 = '' OR namespace = $1) AND ($2 = '' OR id >= NULLIF($2, '')::uuid)
+	ORDER BY id
+	LIMIT $3`
+	rows, err := p.db.QueryContext(ctx, query, opts.Namespace, opts.Cursor, opts.Limit+1)
+	if err != nil {
+		slog.Error("Got this error while trying to list the cache entries", "error", err)
+		return EntryPage{}, err
+	}
+	defer rows.Close()
+	page := EntryPage{Entries: []Entry{}}
+	for rows.Next() {
+		e, err := scanEntry(rows)
+		if err != nil {
+			return EntryPage{}, err
+		}
+		if len(page.Entries) == opts.Limit {
+			page.NextCursor = e.Id
+			break
+		}
+		page.Entries = append(page.Entries, e)
+	}
+	return page, rows.Err()
+}
+
+func (p *PgVectorCache) GetEntry(ctx context.Context, id string) (Entry, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	e, err := scanEntry(p.db.QueryRowContext(ctx, `SELECT `+entryColumns+` FROM Semantic_Cache WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		return Entry{}, ErrEntryNotFound
+	}
+	return e, err
+}
+
+func (p *PgVectorCache) SearchEntries(ctx context.Context, namespace string, Embedding types.Embedding, limit int) ([]Entry, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	ctx, span := Tracer.Start(ctx, "PgVector.SearchEntries")
+	defer span.End()
+	query := `SELECT ` + entryColumns + `, 1 - (embedding <=> $1::vector) FROM Semantic_Cache
+	WHERE ($2 = '' OR namespace = $2)
+	ORDER BY embedding <=> $1::vector
+	LIMIT $3`
+	rows, err := p.db.QueryContext(ctx, query, vectorLiteral(Embedding), namespace, limit)
+	if err != nil {
+		slog.Error("Got this error while searching the cache", "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+	entries := []Entry{}
+	for rows.Next() {
+		var score float64
+		e, err := scanEntry(rows, &score)
+		if err != nil {
+			return nil, err
+		}
+		e.Score = float32(score)
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+func (p *PgVectorCache) DeleteEntries(ctx context.Context, filter DeleteFilter) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	var before any
+	if !filter.Before.IsZero() {
+		before = filter.Before
+	}
+	query := `DELETE FROM Semantic_Cache
+	WHERE (cardinality($1::uuid[]) = 0 OR id = ANY($1::uuid[]))
+	AND ($2 = '' OR namespace = $2)
+	AND ($3 = '' OR query = $3)
+	AND ($4::timestamptz IS NULL OR created_at < $4)`
+	res, err := p.db.ExecContext(ctx, query, "{"+strings.Join(filter.Ids, ",")+"}", filter.Namespace, filter.Query, before)
+	if err != nil {
+		slog.Error("Got this error while deleting cache entries", "error", err)
+		return 0, err
+	}
+	deleted, err := res.RowsAffected()
+	return int(deleted), err
+}
+
+func (p *PgVectorCache) UpdateEntry(ctx context.Context, id string, update EntryUpdate) (Entry, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	query := `UPDATE Semantic_Cache SET answer = COALESCE($2, answer), pinned = COALESCE($3, pinned), quarantined = COALESCE($4, quarantined)
+	WHERE id = $1
+	RETURNING ` + entryColumns
+	e, err := scanEntry(p.db.QueryRowContext(ctx, query, id, update.Answer, update.Pinned, update.Quarantined))
+	if err == sql.ErrNoRows {
+		return Entry{}, ErrEntryNotFound
+	}
+	if err != nil {
+		slog.Error("Got this error while updating a cache entry", "id", id, "error", err)
+	}
+	return e, err
+}
+
+func (p *PgVectorCache) RecordFeedback(ctx context.Context, id string, up int, down int) (Entry, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	query := `UPDATE Semantic_Cache SET feedback_up = GREATEST(feedback_up + $2, 0), feedback_down = GREATEST(feedback_down + $3, 0)
+	WHERE id = $1
+	RETURNING ` + entryColumns
+	e, err := scanEntry(p.db.QueryRowContext(ctx, query, id, up, down))
+	if err == sql.ErrNoRows {
+		return Entry{}, ErrEntryNotFound
+	}
+	if err != nil {
+		slog.Error("Got this error while recording feedback on a cache entry", "id", id, "error", err)
+	}
+	return e, err
+}
@@ -0,0 +1,24 @@
+package cache
+
+import (
+	"testing"
+
+	"github.com/Prateek-Gupta001/AI_Gateway/types"
+)
+
+func TestVectorLiteral(t *testing.T) {
+	if got := vectorLiteral(types.Embedding{0.5, -1, 0.125}); got != "[0.5,-1,0.125]" {
+		t.Errorf("vectorLiteral = %s", got)
+	}
+	if got := vectorLiteral(nil); got != "[]" {
+		t.Errorf("vectorLiteral(nil) = %s", got)
+	}
+}
+
+func TestSupportsIterativeScan(t *testing.T) {
+	for version, want := range map[string]bool{"0.7.4": false, "0.8.0": true, "0.10.1": true, "1.0": true, "": false} {
+		if got := supportsIterativeScan(version); got != want {
+			t.Errorf("supportsIterativeScan(%q) = %v", version, got)
+		}
+	}
+}
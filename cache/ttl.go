@@ -0,0 +1,72 @@
+package cache
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/Prateek-Gupta001/AI_Gateway/types"
+)
+
+// DefaultTTL is how long an answer stays cached when no policy says otherwise.
+const DefaultTTL = 24 * time.Hour
+
+// TTLRule matches when every condition that is set matches. Model is the name of the model
+// that answered, Level what the classifier made of the query.
+type TTLRule struct {
+	Tenant     string      `json:"tenant,omitempty"`
+	Model      string      `json:"model,omitempty"`
+	Level      types.Level `json:"level,omitempty"`
+	TTLSeconds int         `json:"ttl_seconds"`
+}
+
+// TTLPolicy decides how long every answer stays cached. The rules are tried in order and the
+// first one that matches wins, DefaultSeconds (a day when 0) is used when none does.
+type TTLPolicy struct {
+	DefaultSeconds int       `json:"default_ttl_seconds,omitempty"`
+	Rules          []TTLRule `json:"rules,omitempty"`
+	// Sliding pushes an entry's expiry back by its ttl every time it is hit, so answers that
+	// keep being asked for stay and the rest expire.
+	Sliding bool `json:"sliding,omitempty"`
+}
+
+func (p TTLPolicy) Validate() error {
+	if p.DefaultSeconds < 0 {
+		return fmt.Errorf("default_ttl_seconds can't be negative")
+	}
+	for i, rule := range p.Rules {
+		if rule.TTLSeconds <= 0 {
+			return fmt.Errorf("rule %d: ttl_seconds must be positive", i)
+		}
+		if rule.Level != "" && !slices.Contains(types.AllLevels, rule.Level) {
+			return fmt.Errorf("rule %d: invalid level %q", i, rule.Level)
+		}
+		if rule.Tenant == "" && rule.Model == "" && rule.Level == "" {
+			return fmt.Errorf("rule %d: needs a tenant, model or level, use default_ttl_seconds otherwise", i)
+		}
+	}
+	return nil
+}
+
+// TTL is how long the answer of model, for a query of the given level asked by tenant, is kept.
+func (p TTLPolicy) TTL(tenant string, model string, level types.Level) time.Duration {
+	for _, rule := range p.Rules {
+		if (rule.Tenant == "" || rule.Tenant == tenant) &&
+			(rule.Model == "" || rule.Model == model) &&
+			(rule.Level == "" || rule.Level == level) {
+			return time.Duration(rule.TTLSeconds) * time.Second
+		}
+	}
+	if p.DefaultSeconds > 0 {
+		return time.Duration(p.DefaultSeconds) * time.Second
+	}
+	return DefaultTTL
+}
+
+// ttlOrDefault is the ttl of an entry that was stored before entries had their own.
+func ttlOrDefault(seconds int) time.Duration {
+	if seconds <= 0 {
+		return DefaultTTL
+	}
+	return time.Duration(seconds) * time.Second
+}
@@ -0,0 +1,55 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Prateek-Gupta001/AI_Gateway/types"
+)
+
+func TestTTLPolicy(t *testing.T) {
+	p := TTLPolicy{
+		DefaultSeconds: 3600,
+		Rules: []TTLRule{
+			{Tenant: "acme", Level: types.High, TTLSeconds: 60},
+			{Tenant: "acme", TTLSeconds: 600},
+			{Model: "Gemini 2.5 flash", TTLSeconds: 7200},
+		},
+	}
+	cases := []struct {
+		tenant string
+		model  string
+		level  types.Level
+		want   time.Duration
+	}{
+		{"acme", "Gemini 2.5 flash", types.High, time.Minute},
+		{"acme", "Gemini 2.5 flash", types.Easy, 10 * time.Minute},
+		{"other", "Gemini 2.5 flash", types.Easy, 2 * time.Hour},
+		{"other", "Gpt 4o mini", types.Easy, time.Hour},
+	}
+	for _, c := range cases {
+		if got := p.TTL(c.tenant, c.model, c.level); got != c.want {
+			t.Errorf("TTL(%q, %q, %q) = %v, want %v", c.tenant, c.model, c.level, got, c.want)
+		}
+	}
+	if got := (TTLPolicy{}).TTL("acme", "", types.Easy); got != DefaultTTL {
+		t.Errorf("empty policy ttl = %v", got)
+	}
+}
+
+func TestTTLPolicyValidate(t *testing.T) {
+	bad := []TTLPolicy{
+		{DefaultSeconds: -1},
+		{Rules: []TTLRule{{Tenant: "acme"}}},
+		{Rules: []TTLRule{{Level: "extreme", TTLSeconds: 60}}},
+		{Rules: []TTLRule{{TTLSeconds: 60}}},
+	}
+	for _, p := range bad {
+		if p.Validate() == nil {
+			t.Errorf("%+v should not validate", p)
+		}
+	}
+	if err := (TTLPolicy{Rules: []TTLRule{{Level: types.Easy, TTLSeconds: 60}}}).Validate(); err != nil {
+		t.Error(err)
+	}
+}
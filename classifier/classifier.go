@@ -0,0 +1,112 @@
+package classifier
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Prateek-Gupta001/AI_Gateway/embed"
+	"github.com/Prateek-Gupta001/AI_Gateway/types"
+)
+
+// Classifier decides which level (and hence which model) a request gets routed to.
+type Classifier interface {
+	Classify(ctx context.Context, in Input) Result
+}
+
+// Input is everything a classifier gets to look at. Embedding is only there when
+// Chat managed to compute it for the cache lookup, classifiers must cope with it being nil.
+type Input struct {
+	Query     string
+	Messages  []types.Messages
+	Embedding types.Embedding
+}
+
+type Result struct {
+	Level      types.Level
+	Reason     string
+	Classifier string
+}
+
+// Heuristic is the original word counting classifier. MediumWords is off when it is 0.
+type Heuristic struct {
+	MediumWords int `json:"medium_words"`
+	HighWords   int `json:"high_words"`
+}
+
+func NewHeuristic() *Heuristic {
+	return &Heuristic{HighWords: 10}
+}
+
+func (h *Heuristic) Classify(ctx context.Context, in Input) Result {
+	numWords := len(strings.Fields(in.Query))
+	if h.HighWords > 0 && numWords >= h.HighWords {
+		return Result{Level: types.High, Reason: fmt.Sprintf("%d words >= %d", numWords, h.HighWords), Classifier: "heuristic"}
+	}
+	if h.MediumWords > 0 && numWords >= h.MediumWords {
+		return Result{Level: types.Medium, Reason: fmt.Sprintf("%d words >= %d", numWords, h.MediumWords), Classifier: "heuristic"}
+	}
+	return Result{Level: types.Easy, Reason: fmt.Sprintf("%d words", numWords), Classifier: "heuristic"}
+}
+
+// Config is the classifier section of the config file. Type picks which classifier gets built,
+// the embedding classifier falls back onto the heuristic one when it can't decide.
+type Config struct {
+	Type      string           `json:"type"` // heuristic | rules | embedding
+	Heuristic *Heuristic       `json:"heuristic,omitempty"`
+	Rules     *RulesConfig     `json:"rules,omitempty"`
+	Embedding *EmbeddingConfig `json:"embedding,omitempty"`
+}
+
+// LoadConfig reads a classifier config from a json file.
+func LoadConfig(path string) (Config, error) {
+	var cfg Config
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("could not parse classifier config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// New builds the classifier described by the config. The embedder is only needed for
+// the embedding classifier, which embeds its examples in the background.
+func New(ctx context.Context, cfg Config, e embed.Embed) (Classifier, error) {
+	heuristic := NewHeuristic()
+	if cfg.Heuristic != nil {
+		heuristic = cfg.Heuristic
+	}
+	switch cfg.Type {
+	case "", "heuristic":
+		return heuristic, nil
+	case "rules":
+		if cfg.Rules == nil {
+			return nil, fmt.Errorf("classifier type is rules but there is no rules section")
+		}
+		return NewRules(*cfg.Rules)
+	case "embedding":
+		if cfg.Embedding == nil {
+			return nil, fmt.Errorf("classifier type is embedding but there is no embedding section")
+		}
+		c, err := NewEmbeddingClassifier(*cfg.Embedding, heuristic)
+		if err != nil {
+			return nil, err
+		}
+		go c.Prepare(ctx, e)
+		return c, nil
+	}
+	return nil, fmt.Errorf("unknown classifier type %q", cfg.Type)
+}
+
+func validLevel(level types.Level) bool {
+	for _, l := range types.AllLevels {
+		if l == level {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+package classifier
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Prateek-Gupta001/AI_Gateway/types"
+)
+
+func TestHeuristic(t *testing.T) {
+	h := NewHeuristic()
+	if got := h.Classify(context.Background(), Input{Query: "hi there"}).Level; got != types.Easy {
+		t.Errorf("short query got %q", got)
+	}
+	if got := h.Classify(context.Background(), Input{Query: "one two three four five six seven eight nine ten"}).Level; got != types.High {
+		t.Errorf("10 word query got %q", got)
+	}
+	h.MediumWords = 3
+	if got := h.Classify(context.Background(), Input{Query: "one two three"}).Level; got != types.Medium {
+		t.Errorf("3 word query with medium on got %q", got)
+	}
+}
+
+func TestRules(t *testing.T) {
+	r, err := NewRules(RulesConfig{
+		DefaultLevel: types.Easy,
+		Rules: []Rule{
+			{Name: "code", Level: types.High, Keywords: []string{"debug", "stack trace"}},
+			{Name: "conversation", Level: types.Medium, MinMessages: 3},
+			{Name: "explain", Level: types.Medium, Regex: `(?i)^explain`, MaxWords: 5},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		query    string
+		messages int
+		want     types.Level
+	}{
+		{"help me DEBUG this", 1, types.High},
+		{"here is the stack trace", 1, types.High},
+		{"debugger recommendations", 1, types.Easy}, //keywords only match whole words
+		{"hello", 3, types.Medium},
+		{"Explain monads", 1, types.Medium},
+		{"explain monads to me like I am five", 1, types.Easy}, //too many words for the explain rule
+	}
+	for _, c := range cases {
+		in := Input{Query: c.query, Messages: make([]types.Messages, c.messages)}
+		if got := r.Classify(context.Background(), in); got.Level != c.want {
+			t.Errorf("%q: got %q (%s), want %q", c.query, got.Level, got.Reason, c.want)
+		}
+	}
+}
+
+func TestRulesValidation(t *testing.T) {
+	if _, err := NewRules(RulesConfig{Rules: []Rule{{Name: "bad", Level: "huge"}}}); err == nil {
+		t.Error("expected an error for an unknown level")
+	}
+	if _, err := NewRules(RulesConfig{Rules: []Rule{{Name: "bad", Level: types.High, Regex: "("}}}); err == nil {
+		t.Error("expected an error for an invalid regex")
+	}
+}
+
+func TestEmbeddingClassifier(t *testing.T) {
+	c, err := NewEmbeddingClassifier(EmbeddingConfig{
+		K:        1,
+		MinScore: 0.5,
+		Examples: []Example{
+			{Text: "easy one", Level: types.Easy, Embedding: types.Embedding{1, 0}},
+			{Text: "hard one", Level: types.High, Embedding: types.Embedding{0, 1}},
+		},
+	}, NewHeuristic())
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := c.Classify(context.Background(), Input{Query: "x", Embedding: types.Embedding{0.1, 0.9}})
+	if res.Level != types.High || res.Classifier != "embedding" {
+		t.Errorf("got %+v", res)
+	}
+	//no embedding, or nothing similar enough, goes to the fallback
+	if res := c.Classify(context.Background(), Input{Query: "x"}); res.Classifier != "heuristic" {
+		t.Errorf("without embedding got %+v", res)
+	}
+	if res := c.Classify(context.Background(), Input{Query: "x", Embedding: types.Embedding{-1, -1}}); res.Classifier != "heuristic" {
+		t.Errorf("with a far away embedding got %+v", res)
+	}
+}
@@ -0,0 +1,188 @@
+package classifier
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// DynamicConfig configures the time sensitivity detector. Queries it flags as dynamic
+// skip the cache, since yesterday's answer to them is probably wrong today.
+type DynamicConfig struct {
+	Keywords      []string `json:"keywords"`
+	Regexes       []string `json:"regexes"`
+	DetectDates   bool     `json:"detect_dates"`
+	DetectNumbers bool     `json:"detect_numbers"`
+	//per tenant tweaks on top of the config above, keyed by the tenant id of the request's API key.
+	//Requests without a key have no tenant and only get the config above.
+	Tenants map[string]DynamicOverride `json:"tenants,omitempty"`
+}
+
+type DynamicOverride struct {
+	AddKeywords    []string `json:"add_keywords,omitempty"`
+	RemoveKeywords []string `json:"remove_keywords,omitempty"`
+	AddRegexes     []string `json:"add_regexes,omitempty"`
+	DetectDates    *bool    `json:"detect_dates,omitempty"`
+	DetectNumbers  *bool    `json:"detect_numbers,omitempty"`
+}
+
+func DefaultDynamicConfig() DynamicConfig {
+	return DynamicConfig{
+		Keywords: []string{
+			"now", "today", "today's", "tonight", "tomorrow", "yesterday", "weather",
+			"latest", "what time", "current", "currently", "this week", "this month", "this year",
+		},
+		DetectDates: true,
+	}
+}
+
+func LoadDynamicConfig(path string) (DynamicConfig, error) {
+	var cfg DynamicConfig
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("could not parse time sensitivity config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// months are the full names and their abbreviations only, a bare prefix would take "decimal 10" for a date.
+const months = `(?:jan(?:uary)?|feb(?:ruary)?|mar(?:ch)?|apr(?:il)?|may|june?|july?|aug(?:ust)?|sep(?:t(?:ember)?)?|oct(?:ober)?|nov(?:ember)?|dec(?:ember)?)`
+
+var (
+	datePatterns = []*regexp.Regexp{
+		regexp.MustCompile(`\b\d{4}-\d{1,2}-\d{1,2}\b`),
+		regexp.MustCompile(`\b\d{1,2}[/.]\d{1,2}[/.]\d{2,4}\b`),
+		regexp.MustCompile(`(?i)\b` + months + `\b\.? \d{1,2}(?:st|nd|rd|th)?\b`),
+		regexp.MustCompile(`(?i)\b\d{1,2}(?:st|nd|rd|th)? (?:of )?` + months + `\b`),
+	}
+	numberPattern = regexp.MustCompile(`\b\d+(?:[.,]\d+)?\b`)
+)
+
+// DynamicMatch is one reason a query was judged dynamic.
+type DynamicMatch struct {
+	Kind    string `json:"kind"` // keyword | regex | date | number
+	Pattern string `json:"pattern"`
+	Match   string `json:"match"`
+}
+
+type DynamicVerdict struct {
+	Dynamic bool           `json:"dynamic"`
+	Tenant  string         `json:"tenant,omitempty"`
+	Matches []DynamicMatch `json:"matches"`
+}
+
+type namedRegex struct {
+	pattern string
+	re      *regexp.Regexp
+}
+
+type dynamicRules struct {
+	keywords      []namedRegex
+	regexes       []namedRegex
+	detectDates   bool
+	detectNumbers bool
+}
+
+type DynamicDetector struct {
+	base    dynamicRules
+	tenants map[string]dynamicRules
+}
+
+func NewDynamicDetector(cfg DynamicConfig) (*DynamicDetector, error) {
+	base, err := buildDynamicRules(cfg.Keywords, cfg.Regexes, cfg.DetectDates, cfg.DetectNumbers)
+	if err != nil {
+		return nil, err
+	}
+	d := &DynamicDetector{base: base, tenants: map[string]dynamicRules{}}
+	for tenant, o := range cfg.Tenants {
+		removed := map[string]bool{}
+		for _, k := range o.RemoveKeywords {
+			removed[strings.ToLower(k)] = true
+		}
+		var keywords []string
+		for _, k := range append(append([]string{}, cfg.Keywords...), o.AddKeywords...) {
+			if !removed[strings.ToLower(k)] {
+				keywords = append(keywords, k)
+			}
+		}
+		detectDates, detectNumbers := cfg.DetectDates, cfg.DetectNumbers
+		if o.DetectDates != nil {
+			detectDates = *o.DetectDates
+		}
+		if o.DetectNumbers != nil {
+			detectNumbers = *o.DetectNumbers
+		}
+		rules, err := buildDynamicRules(keywords, append(append([]string{}, cfg.Regexes...), o.AddRegexes...), detectDates, detectNumbers)
+		if err != nil {
+			return nil, fmt.Errorf("tenant %s: %w", tenant, err)
+		}
+		d.tenants[tenant] = rules
+	}
+	return d, nil
+}
+
+func buildDynamicRules(keywords []string, regexes []string, detectDates bool, detectNumbers bool) (dynamicRules, error) {
+	rules := dynamicRules{
+		detectDates:   detectDates,
+		detectNumbers: detectNumbers,
+	}
+	for _, k := range keywords {
+		k = strings.TrimSpace(k)
+		if k == "" {
+			continue
+		}
+		rules.keywords = append(rules.keywords, namedRegex{pattern: k, re: keywordRegex([]string{k})})
+	}
+	for _, r := range regexes {
+		re, err := regexp.Compile(r)
+		if err != nil {
+			return rules, fmt.Errorf("invalid regex %q: %w", r, err)
+		}
+		rules.regexes = append(rules.regexes, namedRegex{pattern: r, re: re})
+	}
+	return rules, nil
+}
+
+// IsDynamic is the quick yes/no version of Explain.
+func (d *DynamicDetector) IsDynamic(tenant string, query string) bool {
+	return d.Explain(tenant, query).Dynamic
+}
+
+// Explain returns every keyword, regex and pattern that matched, so the lists can be tuned.
+func (d *DynamicDetector) Explain(tenant string, query string) DynamicVerdict {
+	rules, ok := d.tenants[tenant]
+	if !ok {
+		rules = d.base
+		tenant = ""
+	}
+	verdict := DynamicVerdict{Tenant: tenant, Matches: []DynamicMatch{}}
+	for _, k := range rules.keywords {
+		if m := k.re.FindString(query); m != "" {
+			verdict.Matches = append(verdict.Matches, DynamicMatch{Kind: "keyword", Pattern: k.pattern, Match: m})
+		}
+	}
+	for _, r := range rules.regexes {
+		if m := r.re.FindString(query); m != "" {
+			verdict.Matches = append(verdict.Matches, DynamicMatch{Kind: "regex", Pattern: r.pattern, Match: m})
+		}
+	}
+	if rules.detectDates {
+		for _, re := range datePatterns {
+			if m := re.FindString(query); m != "" {
+				verdict.Matches = append(verdict.Matches, DynamicMatch{Kind: "date", Pattern: re.String(), Match: m})
+			}
+		}
+	}
+	if rules.detectNumbers {
+		if m := numberPattern.FindString(query); m != "" {
+			verdict.Matches = append(verdict.Matches, DynamicMatch{Kind: "number", Pattern: numberPattern.String(), Match: m})
+		}
+	}
+	verdict.Dynamic = len(verdict.Matches) > 0
+	return verdict
+}
@@ -0,0 +1,62 @@
+package classifier
+
+import "testing"
+
+func TestDynamicDetectorWordBoundaries(t *testing.T) {
+	d, err := NewDynamicDetector(DefaultDynamicConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]bool{
+		"What is the weather like?":                true,
+		"Sometimes I wonder about recursion":       false, //"time" inside "sometimes"
+		"Do you know what a monad is?":             false, //"now" inside "know"
+		"What's TODAY's top story":                 true,
+		"What happened on 2024-03-01?":             true,
+		"Remind me what is on March 3rd":           true,
+		"Explain the history of the Roman army":    false,
+		"Remind me what is on 3 Sept":              true,
+		"What time is it in Tokyo":                 true,
+		"convert decimal 10 to binary":             false, //"dec" prefix, not a month
+		"why do 3 marbles roll":                    false,
+		"hire 4 junior devs":                       false,
+		"a novel 2 page summary":                   false,
+		"what is the time complexity of quicksort": false,
+	}
+	for query, want := range cases {
+		v := d.Explain("", query)
+		if v.Dynamic != want {
+			t.Errorf("%q: dynamic = %v, want %v (matches %+v)", query, v.Dynamic, want, v.Matches)
+		}
+	}
+}
+
+func TestDynamicDetectorTenants(t *testing.T) {
+	yes := true
+	cfg := DefaultDynamicConfig()
+	cfg.Regexes = []string{`(?i)\bstock price\b`}
+	cfg.Tenants = map[string]DynamicOverride{
+		"finance": {AddKeywords: []string{"exchange rate"}, DetectNumbers: &yes},
+		"docs":    {RemoveKeywords: []string{"LATEST"}},
+	}
+	d, err := NewDynamicDetector(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.IsDynamic("", "convert 20 dollars with the exchange rate") {
+		t.Error("the base config should not know about exchange rates")
+	}
+	v := d.Explain("finance", "convert 20 dollars with the exchange rate")
+	if !v.Dynamic || v.Tenant != "finance" || len(v.Matches) != 2 {
+		t.Errorf("finance verdict = %+v", v)
+	}
+	if d.IsDynamic("docs", "show me the latest docs") {
+		t.Error("docs removed the latest keyword")
+	}
+	if !d.IsDynamic("docs", "what is the stock price of ACME") {
+		t.Error("tenants keep the base regexes")
+	}
+	if _, err := NewDynamicDetector(DynamicConfig{Regexes: []string{"("}}); err == nil {
+		t.Error("expected an invalid regex to fail")
+	}
+}
@@ -0,0 +1,135 @@
+package classifier
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Prateek-Gupta001/AI_Gateway/embed"
+	"github.com/Prateek-Gupta001/AI_Gateway/types"
+)
+
+type Example struct {
+	Text      string          `json:"text"`
+	Level     types.Level     `json:"level"`
+	Embedding types.Embedding `json:"-"`
+}
+
+type EmbeddingConfig struct {
+	K        int       `json:"k"`
+	MinScore float32   `json:"min_score"`
+	Examples []Example `json:"examples"`
+}
+
+// EmbeddingClassifier compares the query embedding against labeled example prompts and
+// lets the k nearest ones vote (weighted by similarity). Without a query embedding, or before
+// the examples have been embedded, it hands over to the fallback.
+type EmbeddingClassifier struct {
+	k        int
+	minScore float32
+	fallback Classifier
+
+	mu       sync.RWMutex
+	examples []Example
+}
+
+func NewEmbeddingClassifier(cfg EmbeddingConfig, fallback Classifier) (*EmbeddingClassifier, error) {
+	if len(cfg.Examples) == 0 {
+		return nil, fmt.Errorf("the embedding classifier needs at least one example")
+	}
+	for _, ex := range cfg.Examples {
+		if !validLevel(ex.Level) {
+			return nil, fmt.Errorf("example %q has invalid level %q", ex.Text, ex.Level)
+		}
+	}
+	if cfg.K <= 0 {
+		cfg.K = 3
+	}
+	return &EmbeddingClassifier{
+		k:        cfg.K,
+		minScore: cfg.MinScore,
+		fallback: fallback,
+		examples: cfg.Examples,
+	}, nil
+}
+
+// Prepare embeds every example that doesn't have an embedding yet.
+func (c *EmbeddingClassifier) Prepare(ctx context.Context, e embed.Embed) {
+	c.mu.RLock()
+	examples := make([]Example, len(c.examples))
+	copy(examples, c.examples)
+	c.mu.RUnlock()
+
+	for i := range examples {
+		if examples[i].Embedding != nil {
+			continue
+		}
+		//the embedding workers might still be loading the model, so be generous here
+		jobCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		resultChan := make(chan types.EmbeddingResult, 1)
+		go e.SubmitJob(jobCtx, examples[i].Text, resultChan)
+		select {
+		case result := <-resultChan:
+			if result.Err != nil {
+				slog.Error("Got this error while trying to embed a classifier example", "example", examples[i].Text, "error", result.Err)
+			} else {
+				examples[i].Embedding = result.Embedding_Result
+			}
+		case <-jobCtx.Done():
+			slog.Error("Embedding a classifier example timed out", "example", examples[i].Text)
+		}
+		cancel()
+	}
+	c.mu.Lock()
+	c.examples = examples
+	c.mu.Unlock()
+	slog.Info("Embedding classifier examples are ready!", "num", len(examples))
+}
+
+func (c *EmbeddingClassifier) Classify(ctx context.Context, in Input) Result {
+	if in.Embedding == nil {
+		return c.fallback.Classify(ctx, in)
+	}
+	type neighbour struct {
+		level types.Level
+		score float32
+		text  string
+	}
+	c.mu.RLock()
+	neighbours := make([]neighbour, 0, len(c.examples))
+	for _, ex := range c.examples {
+		if ex.Embedding == nil {
+			continue
+		}
+		score := in.Embedding.CosineSimilarity(ex.Embedding)
+		if score >= c.minScore {
+			neighbours = append(neighbours, neighbour{level: ex.Level, score: score, text: ex.Text})
+		}
+	}
+	c.mu.RUnlock()
+	if len(neighbours) == 0 {
+		return c.fallback.Classify(ctx, in)
+	}
+	sort.Slice(neighbours, func(i, j int) bool { return neighbours[i].score > neighbours[j].score })
+	if len(neighbours) > c.k {
+		neighbours = neighbours[:c.k]
+	}
+	votes := map[types.Level]float32{}
+	for _, n := range neighbours {
+		votes[n.level] += n.score
+	}
+	best := neighbours[0].level
+	for _, level := range types.AllLevels {
+		if votes[level] > votes[best] {
+			best = level
+		}
+	}
+	return Result{
+		Level:      best,
+		Reason:     fmt.Sprintf("nearest example %q (score %.3f), %d of %d neighbours voted", neighbours[0].text, neighbours[0].score, len(neighbours), c.k),
+		Classifier: "embedding",
+	}
+}
@@ -0,0 +1,108 @@
+package classifier
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/Prateek-Gupta001/AI_Gateway/types"
+)
+
+// Rule matches when every condition that is set matches. Keywords are matched case
+// insensitively on word boundaries, any one of them is enough.
+type Rule struct {
+	Name        string      `json:"name"`
+	Level       types.Level `json:"level"`
+	Keywords    []string    `json:"keywords,omitempty"`
+	Regex       string      `json:"regex,omitempty"`
+	MinWords    int         `json:"min_words,omitempty"`
+	MaxWords    int         `json:"max_words,omitempty"`
+	MinMessages int         `json:"min_messages,omitempty"`
+	MaxMessages int         `json:"max_messages,omitempty"`
+
+	keywords *regexp.Regexp
+	regex    *regexp.Regexp
+}
+
+type RulesConfig struct {
+	DefaultLevel types.Level `json:"default_level"`
+	Rules        []Rule      `json:"rules"`
+}
+
+// Rules goes through the rules in order, the first one that matches decides the level.
+type Rules struct {
+	defaultLevel types.Level
+	rules        []Rule
+}
+
+func NewRules(cfg RulesConfig) (*Rules, error) {
+	if cfg.DefaultLevel == "" {
+		cfg.DefaultLevel = types.Easy
+	}
+	if !validLevel(cfg.DefaultLevel) {
+		return nil, fmt.Errorf("invalid default level %q", cfg.DefaultLevel)
+	}
+	rules := make([]Rule, 0, len(cfg.Rules))
+	for i, rule := range cfg.Rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule %d", i)
+		}
+		if !validLevel(rule.Level) {
+			return nil, fmt.Errorf("%s: invalid level %q", rule.Name, rule.Level)
+		}
+		if len(rule.Keywords) > 0 {
+			rule.keywords = keywordRegex(rule.Keywords)
+		}
+		if rule.Regex != "" {
+			re, err := regexp.Compile(rule.Regex)
+			if err != nil {
+				return nil, fmt.Errorf("%s: invalid regex: %w", rule.Name, err)
+			}
+			rule.regex = re
+		}
+		rules = append(rules, rule)
+	}
+	return &Rules{defaultLevel: cfg.DefaultLevel, rules: rules}, nil
+}
+
+// keywordRegex builds one case insensitive, word boundary regex out of a keyword list.
+func keywordRegex(keywords []string) *regexp.Regexp {
+	quoted := make([]string, 0, len(keywords))
+	for _, k := range keywords {
+		quoted = append(quoted, regexp.QuoteMeta(strings.TrimSpace(k)))
+	}
+	return regexp.MustCompile(`(?i)\b(?:` + strings.Join(quoted, "|") + `)\b`)
+}
+
+func (r *Rule) matches(in Input, numWords int) bool {
+	if r.keywords != nil && !r.keywords.MatchString(in.Query) {
+		return false
+	}
+	if r.regex != nil && !r.regex.MatchString(in.Query) {
+		return false
+	}
+	if r.MinWords > 0 && numWords < r.MinWords {
+		return false
+	}
+	if r.MaxWords > 0 && numWords > r.MaxWords {
+		return false
+	}
+	if r.MinMessages > 0 && len(in.Messages) < r.MinMessages {
+		return false
+	}
+	if r.MaxMessages > 0 && len(in.Messages) > r.MaxMessages {
+		return false
+	}
+	return true
+}
+
+func (r *Rules) Classify(ctx context.Context, in Input) Result {
+	numWords := len(strings.Fields(in.Query))
+	for _, rule := range r.rules {
+		if rule.matches(in, numWords) {
+			return Result{Level: rule.Level, Reason: "matched " + rule.Name, Classifier: "rules"}
+		}
+	}
+	return Result{Level: r.defaultLevel, Reason: "no rule matched", Classifier: "rules"}
+}
@@ -0,0 +1,210 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/Prateek-Gupta001/AI_Gateway/types"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	AnthropicBaseURL = "https://api.anthropic.com"
+	anthropicVersion = "2023-06-01"
+)
+
+// NewAnthropicProvider returns an LLMProvider for the Anthropic Messages API. baseURL is only
+// there so tests can point it at a local server. Anthropic requires max_tokens, so maxTokens is
+// what gets sent when the request doesn't set one.
+func NewAnthropicProvider(baseURL string, model string, maxTokens int) LLMProvider {
+	return func(ctx context.Context, sw StreamWriter, messages []types.Messages, opts types.GenerationOptions, apikey string, llmResStruct *types.LLMResponse) error {
+		return callAnthropicAPI(ctx, baseURL, model, maxTokens, sw, messages, opts, apikey, llmResStruct)
+	}
+}
+
+// CreateAnthropicMessages hoists the system messages out into the top level system prompt
+// (that is where Anthropic wants them) and merges consecutive messages of the same role,
+// since the Messages API wants the roles to alternate.
+func CreateAnthropicMessages(messages []types.Messages) (string, []map[string]string) {
+	var system []string
+	msg := make([]map[string]string, 0, len(messages))
+	for _, m := range messages {
+		if m.Role == types.RoleSystem {
+			system = append(system, m.Content)
+			continue
+		}
+		if n := len(msg); n > 0 && msg[n-1]["role"] == string(m.Role) {
+			msg[n-1]["content"] += "\n\n" + m.Content
+			continue
+		}
+		msg = append(msg, map[string]string{"role": string(m.Role), "content": m.Content})
+	}
+	return strings.Join(system, "\n\n"), msg
+}
+
+func callAnthropicAPI(ctx context.Context, baseURL string, model string, maxTokens int, sw StreamWriter, messages []types.Messages, opts types.GenerationOptions, apikey string, llmResStruct *types.LLMResponse) error {
+	ctx, span := Tracer.Start(ctx, "CallAnthropicAPI")
+	defer span.End()
+	system, msgs := CreateAnthropicMessages(messages)
+	if opts.MaxTokens > 0 {
+		maxTokens = opts.MaxTokens
+	}
+	requestBody := map[string]interface{}{
+		"model":      model,
+		"max_tokens": maxTokens,
+		"messages":   msgs,
+		"stream":     true,
+	}
+	if system != "" {
+		requestBody["system"] = system
+	}
+	if opts.Temperature != nil {
+		requestBody["temperature"] = *opts.Temperature
+	}
+	jsonData, err := json.Marshal(requestBody)
+	if err != nil {
+		slog.Error("Failed to marshal request", "error", err)
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", strings.TrimRight(baseURL, "/")+"/v1/messages", bytes.NewReader(jsonData))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-api-key", apikey)
+	req.Header.Set("anthropic-version", anthropicVersion)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		slog.Error("Got this error while trying to call Anthropic", "error", err)
+		return &ProviderError{Err: err}
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		slog.Error("Anthropic returned an error", "error", err)
+		return err
+	}
+	if llmResStruct.LLMRes == nil {
+		llmResStruct.LLMRes = new(bytes.Buffer)
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			slog.Error("Got this unexpected error while reading the Anthropic stream", "error", err)
+			return err
+		}
+		//the "event:" lines just repeat the type that is also inside the data
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		var event AnthropicStreamEvent
+		if err := json.Unmarshal([]byte(strings.TrimSpace(strings.TrimPrefix(line, "data:"))), &event); err != nil {
+			slog.Error("Got this error while trying to unmarshal the json in Anthropic", "error", err)
+			continue
+		}
+		switch event.Type {
+		case "message_start":
+			if event.Message != nil {
+				llmResStruct.InputTokens = event.Message.Usage.InputTokens
+				llmResStruct.OutputTokens = event.Message.Usage.OutputTokens
+			}
+		case "content_block_delta":
+			if event.Delta != nil && event.Delta.Type == "text_delta" {
+				if err := sw.WriteDelta(event.Delta.Text); err != nil {
+					return err
+				}
+				llmResStruct.LLMRes.WriteString(event.Delta.Text)
+			}
+		case "message_delta":
+			if event.Delta != nil && event.Delta.StopReason != "" {
+				sw.WriteFinish(normalizeFinishReason(event.Delta.StopReason))
+			}
+			if event.Usage != nil {
+				//output_tokens here is the running total, not an increment
+				llmResStruct.OutputTokens = event.Usage.OutputTokens
+			}
+		case "error":
+			if event.Error == nil {
+				return fmt.Errorf("anthropic stream error")
+			}
+			slog.Error("Anthropic sent an error event in the stream", "type", event.Error.Type, "message", event.Error.Message)
+			return &ProviderError{
+				StatusCode: anthropicErrorStatus(event.Error.Type),
+				Err:        fmt.Errorf("%s: %s", event.Error.Type, event.Error.Message),
+			}
+		}
+	}
+	llmResStruct.TotalTokens = llmResStruct.InputTokens + llmResStruct.OutputTokens
+	sw.WriteUsage(llmResStruct.InputTokens, llmResStruct.OutputTokens, llmResStruct.TotalTokens)
+	span.SetAttributes(
+		attribute.Int("total_tokens", llmResStruct.TotalTokens),
+		attribute.Int("input_tokens", llmResStruct.InputTokens),
+		attribute.Int("output_tokens", llmResStruct.OutputTokens),
+	)
+	return nil
+}
+
+// anthropicErrorStatus maps the error types Anthropic sends in the stream to the http status
+// they come with otherwise, so the fallback chain can tell which ones are worth a retry.
+func anthropicErrorStatus(errType string) int {
+	switch errType {
+	case "overloaded_error":
+		return 529
+	case "api_error":
+		return http.StatusInternalServerError
+	case "rate_limit_error":
+		return http.StatusTooManyRequests
+	case "authentication_error":
+		return http.StatusUnauthorized
+	case "permission_error":
+		return http.StatusForbidden
+	case "not_found_error":
+		return http.StatusNotFound
+	case "request_too_large":
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+type AnthropicStreamEvent struct {
+	Type    string            `json:"type"`
+	Message *AnthropicMessage `json:"message,omitempty"` // message_start
+	Index   int               `json:"index,omitempty"`
+	Delta   *AnthropicDelta   `json:"delta,omitempty"` // content_block_delta and message_delta
+	Usage   *AnthropicUsage   `json:"usage,omitempty"` // message_delta
+	Error   *AnthropicError   `json:"error,omitempty"` // error
+}
+
+type AnthropicMessage struct {
+	ID    string         `json:"id"`
+	Model string         `json:"model"`
+	Usage AnthropicUsage `json:"usage"`
+}
+
+type AnthropicDelta struct {
+	Type       string `json:"type"`
+	Text       string `json:"text,omitempty"`
+	StopReason string `json:"stop_reason,omitempty"`
+}
+
+type AnthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+type AnthropicError struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
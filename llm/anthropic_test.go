@@ -0,0 +1,132 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/Prateek-Gupta001/AI_Gateway/types"
+)
+
+// replayServer serves a recorded Anthropic SSE fixture and hands back the request body it got.
+func replayServer(t *testing.T, fixture string, gotBody *map[string]any) *httptest.Server {
+	t.Helper()
+	data, err := os.ReadFile("testdata/" + fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/messages" {
+			t.Errorf("path = %s", r.URL.Path)
+		}
+		if r.Header.Get("x-api-key") != "test-key" || r.Header.Get("anthropic-version") != anthropicVersion {
+			t.Errorf("headers = %v", r.Header)
+		}
+		body, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(body, gotBody); err != nil {
+			t.Errorf("request body is not json: %v", err)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write(data)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestAnthropicStream(t *testing.T) {
+	var body map[string]any
+	srv := replayServer(t, "anthropic_stream.txt", &body)
+	call := NewAnthropicProvider(srv.URL, "claude-sonnet-4-5", 1024)
+
+	messages := []types.Messages{
+		{Role: types.RoleSystem, Content: "You are terse."},
+		{Role: types.RoleUser, Content: "Hi"},
+		{Role: types.RoleSystem, Content: "Answer in English."},
+		{Role: types.RoleUser, Content: "Hello?"},
+	}
+	recorder := httptest.NewRecorder()
+	sw := NewSSEWriter(recorder, "req")
+	res := &types.LLMResponse{}
+	if err := call(context.Background(), sw, messages, types.GenerationOptions{}, "test-key", res); err != nil {
+		t.Fatal(err)
+	}
+	if err := sw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if body["system"] != "You are terse.\n\nAnswer in English." {
+		t.Errorf("system = %q", body["system"])
+	}
+	msgs, _ := body["messages"].([]any)
+	if len(msgs) != 1 {
+		t.Fatalf("the two user messages should have been merged, got %v", body["messages"])
+	}
+	if body["max_tokens"] != float64(1024) || body["stream"] != true || body["model"] != "claude-sonnet-4-5" {
+		t.Errorf("body = %v", body)
+	}
+
+	if res.LLMRes.String() != "Hello! How can I help you today?" {
+		t.Errorf("text = %q", res.LLMRes.String())
+	}
+	if res.InputTokens != 25 || res.OutputTokens != 12 || res.TotalTokens != 37 {
+		t.Errorf("usage = %d/%d/%d", res.InputTokens, res.OutputTokens, res.TotalTokens)
+	}
+	events := readEvents(t, recorder.Body.String())
+	var streamed string
+	for _, e := range events {
+		streamed += e.Text
+		if e.Type == types.EventFinish && e.FinishReason != FinishStop {
+			t.Errorf("finish reason = %q", e.FinishReason)
+		}
+	}
+	if streamed != res.LLMRes.String() {
+		t.Errorf("streamed %q", streamed)
+	}
+}
+
+func TestAnthropicMaxTokens(t *testing.T) {
+	var body map[string]any
+	srv := replayServer(t, "anthropic_max_tokens.txt", &body)
+	call := NewAnthropicProvider(srv.URL, "claude-sonnet-4-5", 1024)
+	collector := &CollectWriter{}
+	res := &types.LLMResponse{}
+	err := call(context.Background(), collector, []types.Messages{{Role: types.RoleUser, Content: "Tell me a story"}}, types.GenerationOptions{MaxTokens: 4}, "test-key", res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body["max_tokens"] != float64(4) {
+		t.Errorf("max_tokens = %v", body["max_tokens"])
+	}
+	if collector.FinishReason != FinishLength || collector.TotalTokens != 18 {
+		t.Errorf("collector = %+v", collector)
+	}
+}
+
+func TestAnthropicErrorEvent(t *testing.T) {
+	var body map[string]any
+	srv := replayServer(t, "anthropic_overloaded.txt", &body)
+	call := NewAnthropicProvider(srv.URL, "claude-sonnet-4-5", 1024)
+	err := call(context.Background(), &CollectWriter{}, []types.Messages{{Role: types.RoleUser, Content: "Hi"}}, types.GenerationOptions{}, "test-key", &types.LLMResponse{})
+	var perr *ProviderError
+	if !errors.As(err, &perr) || perr.StatusCode != 529 || !perr.Retryable() {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestAnthropicHTTPError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"type":"error","error":{"type":"rate_limit_error","message":"Number of request tokens has exceeded your per-minute rate limit"}}`))
+	}))
+	defer srv.Close()
+	call := NewAnthropicProvider(srv.URL, "claude-sonnet-4-5", 1024)
+	err := call(context.Background(), &CollectWriter{}, []types.Messages{{Role: types.RoleUser, Content: "Hi"}}, types.GenerationOptions{}, "test-key", &types.LLMResponse{})
+	if !isRetryable(err) {
+		t.Fatalf("err = %v", err)
+	}
+}
@@ -1,10 +1,13 @@
 package llm
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/Prateek-Gupta001/AI_Gateway/types"
@@ -31,7 +34,11 @@ func TestCallGptAPI_Integration(t *testing.T) {
 	// 4. Call the function
 	// Note: Your current implementation hardcodes the input prompt inside callGptAPI,
 	// so the 'messages' argument here is ignored, but we pass nil for now.
-	err := CallGptAPI(context.Background(), recorder, nil, apiKey, llmResStruct)
+	sw := NewOpenAIStreamWriter(recorder, "chatcmpl-test", "gpt-4o", true)
+	err := CallGptAPI(context.Background(), sw, nil, types.GenerationOptions{}, apiKey, llmResStruct)
+	if err == nil {
+		err = sw.Close()
+	}
 
 	// 5. Assertions
 	if err != nil {
@@ -54,10 +61,31 @@ func TestCallGptAPI_Integration(t *testing.T) {
 		llmResStruct.InputTokens, llmResStruct.OutputTokens, llmResStruct.TotalTokens)
 
 	// CHECK 3: Did it stream to the HTTP writer?
-	// The recorder.Body will contain the raw data written to w (e.g. "Aye, matey!")
-	// Note: Since your code writes just the delta (text) to 'w', this should match LLMRes.
-	streamedOutput := recorder.Body.String()
+	// The recorder.Body will contain chat.completion.chunk events, the deltas in them
+	// put back together should match LLMRes.
+	streamedOutput := collectChunkText(t, recorder.Body.String())
 	if streamedOutput != gotText {
 		t.Errorf("Mismatch between Struct storage and HTTP stream.\nStruct: %s\nStream: %s", gotText, streamedOutput)
 	}
 }
+
+// collectChunkText puts the content deltas of a chat.completion.chunk stream back together.
+func collectChunkText(t *testing.T, stream string) string {
+	t.Helper()
+	var text strings.Builder
+	scanner := bufio.NewScanner(strings.NewReader(stream))
+	for scanner.Scan() {
+		line := strings.TrimPrefix(scanner.Text(), "data: ")
+		if line == "" || line == "[DONE]" {
+			continue
+		}
+		var chunk OpenAIChunk
+		if err := json.Unmarshal([]byte(line), &chunk); err != nil {
+			t.Fatalf("could not unmarshal chunk %q: %v", line, err)
+		}
+		for _, choice := range chunk.Choices {
+			text.WriteString(choice.Delta.Content)
+		}
+	}
+	return text.String()
+}
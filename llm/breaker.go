@@ -0,0 +1,277 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Prateek-Gupta001/AI_Gateway/types"
+)
+
+type BreakerState string
+
+const (
+	BreakerClosed   BreakerState = "closed"
+	BreakerOpen     BreakerState = "open"
+	BreakerHalfOpen BreakerState = "half_open"
+)
+
+// BreakerConfig decides when a model's circuit opens. The circuit looks at the calls made within
+// Window: once there are at least MinRequests of them it opens if the error rate reaches
+// ErrorRate, or the p95 latency (time to first token) reaches P95Latency. After OpenFor it lets
+// HalfOpenProbes calls through, and closes again if they succeed.
+type BreakerConfig struct {
+	Window         time.Duration
+	MinRequests    int
+	ErrorRate      float64
+	P95Latency     time.Duration //0 turns the latency check off
+	OpenFor        time.Duration
+	HalfOpenProbes int
+}
+
+func DefaultBreakerConfig() BreakerConfig {
+	return BreakerConfig{
+		Window:         time.Minute,
+		MinRequests:    5,
+		ErrorRate:      0.5,
+		P95Latency:     15 * time.Second,
+		OpenFor:        30 * time.Second,
+		HalfOpenProbes: 1,
+	}
+}
+
+// maxSamples caps how many calls a breaker remembers, however busy the window is.
+const maxSamples = 1000
+
+type sample struct {
+	at      time.Time
+	latency time.Duration
+	failed  bool
+}
+
+type CircuitBreaker struct {
+	mu       sync.Mutex
+	cfg      BreakerConfig
+	state    BreakerState
+	openedAt time.Time
+	probes   int
+	samples  []sample
+	now      func() time.Time
+	// generation goes up on every change of state, so a call that was let through in an
+	// earlier one can't decide what happens in this one.
+	generation uint64
+}
+
+// BreakerTicket is handed out by Allow for every call it lets through, the call's result
+// goes back with it.
+type BreakerTicket struct {
+	generation uint64
+	probe      bool
+}
+
+func NewCircuitBreaker(cfg BreakerConfig) *CircuitBreaker {
+	return &CircuitBreaker{cfg: cfg, state: BreakerClosed, now: time.Now}
+}
+
+// Allow reports whether a call may go to this model right now. Every allowed call has to be
+// followed by a Record, or a Release when it ended without telling anything about the model.
+func (b *CircuitBreaker) Allow() (BreakerTicket, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		if b.now().Sub(b.openedAt) < b.cfg.OpenFor {
+			return BreakerTicket{}, false
+		}
+		b.setState(BreakerHalfOpen)
+		b.probes = 0
+		fallthrough
+	case BreakerHalfOpen:
+		if b.probes >= b.cfg.HalfOpenProbes {
+			return BreakerTicket{}, false
+		}
+		b.probes++
+		return BreakerTicket{generation: b.generation, probe: true}, true
+	}
+	return BreakerTicket{generation: b.generation}, true
+}
+
+// Record takes the result of the call t was handed out for. Calls let through before the
+// circuit last changed state are ignored, a slow success from before it opened mustn't close it.
+func (b *CircuitBreaker) Record(t BreakerTicket, latency time.Duration, failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if t.generation != b.generation {
+		return
+	}
+	now := b.now()
+	if t.probe {
+		b.probes--
+		if failed {
+			b.open(now)
+			return
+		}
+		//the probe went through, start over with a clean window
+		b.setState(BreakerClosed)
+		b.samples = nil
+	}
+	b.samples = append(b.samples, sample{at: now, latency: latency, failed: failed})
+	if len(b.samples) > maxSamples {
+		b.samples = b.samples[len(b.samples)-maxSamples:]
+	}
+	b.prune(now)
+	if b.state != BreakerClosed || len(b.samples) < b.cfg.MinRequests {
+		return
+	}
+	errorRate, _, p95 := b.stats()
+	if errorRate >= b.cfg.ErrorRate || (b.cfg.P95Latency > 0 && p95 >= b.cfg.P95Latency) {
+		b.open(now)
+	}
+}
+
+// Release hands back a call that ended without a result worth counting, like the client
+// hanging up. A probe's slot is freed for the next call.
+func (b *CircuitBreaker) Release(t BreakerTicket) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if t.probe && t.generation == b.generation {
+		b.probes--
+	}
+}
+
+// setState has to be called with the lock held.
+func (b *CircuitBreaker) setState(state BreakerState) {
+	b.state = state
+	b.generation++
+}
+
+func (b *CircuitBreaker) open(now time.Time) {
+	b.setState(BreakerOpen)
+	b.openedAt = now
+	b.probes = 0
+}
+
+func (b *CircuitBreaker) prune(now time.Time) {
+	cutoff := now.Add(-b.cfg.Window)
+	i := 0
+	for i < len(b.samples) && b.samples[i].at.Before(cutoff) {
+		i++
+	}
+	b.samples = b.samples[i:]
+}
+
+// stats has to be called with the lock held.
+func (b *CircuitBreaker) stats() (errorRate float64, p50 time.Duration, p95 time.Duration) {
+	if len(b.samples) == 0 {
+		return 0, 0, 0
+	}
+	failures := 0
+	latencies := make([]time.Duration, 0, len(b.samples))
+	for _, s := range b.samples {
+		if s.failed {
+			failures++
+		}
+		latencies = append(latencies, s.latency)
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	return float64(failures) / float64(len(b.samples)), percentile(latencies, 0.50), percentile(latencies, 0.95)
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	idx := int(float64(len(sorted)-1) * p)
+	return sorted[idx]
+}
+
+type ProviderStats struct {
+	Model     string        `json:"model"`
+	Levels    []types.Level `json:"levels"`
+	State     BreakerState  `json:"state"`
+	Requests  int           `json:"requests"`
+	ErrorRate float64       `json:"error_rate"`
+	P50Ms     int64         `json:"p50_ms"`
+	P95Ms     int64         `json:"p95_ms"`
+	OpenedAt  *time.Time    `json:"opened_at,omitempty"`
+	Pricing   Pricing       `json:"pricing"`
+}
+
+func (b *CircuitBreaker) Stats() ProviderStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.prune(b.now())
+	errorRate, p50, p95 := b.stats()
+	stats := ProviderStats{
+		State:     b.state,
+		Requests:  len(b.samples),
+		ErrorRate: errorRate,
+		P50Ms:     p50.Milliseconds(),
+		P95Ms:     p95.Milliseconds(),
+	}
+	if b.state != BreakerClosed {
+		openedAt := b.openedAt
+		stats.OpenedAt = &openedAt
+	}
+	return stats
+}
+
+// countsAsFailure decides if an error says something about the provider's health. The client
+// hanging up, or the provider rejecting a bad request, doesn't.
+func countsAsFailure(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+	var perr *ProviderError
+	if errors.As(err, &perr) && perr.StatusCode >= 400 && perr.StatusCode < 500 && perr.StatusCode != http.StatusTooManyRequests {
+		return false
+	}
+	return true
+}
+
+// timingWriter notes when the first delta went out, which is the latency the breakers look at.
+type timingWriter struct {
+	StreamWriter
+	firstDelta time.Time
+}
+
+func (t *timingWriter) WriteDelta(text string) error {
+	if t.firstDelta.IsZero() {
+		t.firstDelta = time.Now()
+	}
+	return t.StreamWriter.WriteDelta(text)
+}
+
+func (s *LLMStruct) breaker(model string) *CircuitBreaker {
+	s.breakerMu.Lock()
+	defer s.breakerMu.Unlock()
+	if s.breakers == nil {
+		s.breakers = map[string]*CircuitBreaker{}
+	}
+	b, ok := s.breakers[model]
+	if !ok {
+		cfg := s.BreakerConfig
+		if cfg == (BreakerConfig{}) {
+			cfg = DefaultBreakerConfig()
+		}
+		b = NewCircuitBreaker(cfg)
+		s.breakers[model] = b
+	}
+	return b
+}
+
+// ProviderStats returns the circuit state, error rate and latency of every model.
+func (s *LLMStruct) ProviderStats() []ProviderStats {
+	s.mu.RLock()
+	models := s.Models
+	s.mu.RUnlock()
+	stats := make([]ProviderStats, 0, len(models))
+	for _, m := range models {
+		st := s.breaker(m.ModelName).Stats()
+		st.Model = m.ModelName
+		st.Levels = m.Levels
+		st.Pricing = m.Pricing
+		stats = append(stats, st)
+	}
+	return stats
+}
@@ -0,0 +1,207 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Prateek-Gupta001/AI_Gateway/types"
+)
+
+func newTestBreaker(cfg BreakerConfig) (*CircuitBreaker, *time.Time) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := NewCircuitBreaker(cfg)
+	b.now = func() time.Time { return now }
+	return b, &now
+}
+
+func TestCircuitBreakerOpensAndRecovers(t *testing.T) {
+	cfg := DefaultBreakerConfig()
+	b, now := newTestBreaker(cfg)
+	for i := 0; i < 4; i++ {
+		ticket, _ := b.Allow()
+		b.Record(ticket, 100*time.Millisecond, i%2 == 0)
+	}
+	if b.Stats().State != BreakerClosed {
+		t.Fatal("should stay closed below MinRequests")
+	}
+	ticket, _ := b.Allow()
+	b.Record(ticket, 100*time.Millisecond, true) //3 out of 5 failed
+	st := b.Stats()
+	if st.State != BreakerOpen || st.ErrorRate != 0.6 {
+		t.Fatalf("stats = %+v", st)
+	}
+	if _, ok := b.Allow(); ok {
+		t.Fatal("open circuit let a call through")
+	}
+
+	*now = now.Add(cfg.OpenFor)
+	probe, ok := b.Allow()
+	if !ok {
+		t.Fatal("half open circuit should let a probe through")
+	}
+	if _, ok := b.Allow(); ok {
+		t.Fatal("only one probe at a time")
+	}
+	b.Record(probe, 50*time.Millisecond, true)
+	if b.Stats().State != BreakerOpen {
+		t.Fatal("failed probe should open the circuit again")
+	}
+
+	*now = now.Add(cfg.OpenFor)
+	probe, _ = b.Allow()
+	b.Record(probe, 50*time.Millisecond, false)
+	if st := b.Stats(); st.State != BreakerClosed || st.Requests != 1 {
+		t.Fatalf("after a good probe stats = %+v", st)
+	}
+}
+
+func TestCircuitBreakerLatencyAndWindow(t *testing.T) {
+	cfg := DefaultBreakerConfig()
+	cfg.P95Latency = time.Second
+	b, now := newTestBreaker(cfg)
+	for i := 0; i < 5; i++ {
+		ticket, _ := b.Allow()
+		b.Record(ticket, 2*time.Second, false)
+		*now = now.Add(time.Second)
+	}
+	if st := b.Stats(); st.State != BreakerOpen || st.P50Ms != 2000 || st.P95Ms != 2000 {
+		t.Fatalf("slow model stats = %+v", st)
+	}
+
+	fresh, now := newTestBreaker(DefaultBreakerConfig())
+	ticket, _ := fresh.Allow()
+	fresh.Record(ticket, time.Second, true)
+	*now = now.Add(2 * time.Minute)
+	if st := fresh.Stats(); st.Requests != 0 || st.ErrorRate != 0 {
+		t.Fatalf("old samples should fall out of the window, stats = %+v", st)
+	}
+}
+
+func TestCircuitBreakerIgnoresOtherCalls(t *testing.T) {
+	cfg := DefaultBreakerConfig()
+	b, now := newTestBreaker(cfg)
+	early, _ := b.Allow()
+	b.open(*now)
+	*now = now.Add(cfg.OpenFor)
+	probe, ok := b.Allow()
+	if !ok {
+		t.Fatal("half open circuit should let a probe through")
+	}
+	//a call from before the circuit opened finally comes back fine, the probe decides
+	b.Record(early, time.Second, false)
+	if st := b.Stats(); st.State != BreakerHalfOpen {
+		t.Fatalf("a stale success closed the circuit, stats = %+v", st)
+	}
+	//the probe's client hung up, the next call gets to probe instead
+	b.Release(probe)
+	if st := b.Stats(); st.State != BreakerHalfOpen {
+		t.Fatalf("a released probe changed the state, stats = %+v", st)
+	}
+	probe, ok = b.Allow()
+	if !ok {
+		t.Fatal("the released probe's slot should be free again")
+	}
+	b.Record(probe, time.Second, false)
+	if st := b.Stats(); st.State != BreakerClosed {
+		t.Fatalf("stats = %+v", st)
+	}
+}
+
+func TestCountsAsFailure(t *testing.T) {
+	ctx := context.Background()
+	if countsAsFailure(ctx, &ProviderError{StatusCode: http.StatusBadRequest, Err: errors.New("bad")}) {
+		t.Error("a 400 is the client's fault")
+	}
+	if !countsAsFailure(ctx, &ProviderError{StatusCode: http.StatusTooManyRequests, Err: errors.New("slow down")}) {
+		t.Error("a 429 should count")
+	}
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if countsAsFailure(cancelled, errors.New("context canceled")) {
+		t.Error("the client hanging up is not the provider's fault")
+	}
+}
+
+func TestGenerateResponseSkipsOpenCircuits(t *testing.T) {
+	var calls []string
+	s := &LLMStruct{
+		Models: []llmModel{
+			{ModelName: "flaky", Levels: []types.Level{types.Easy}, Call: fakeProvider("flaky", &ProviderError{StatusCode: http.StatusBadGateway, Err: errors.New("down")}, &calls)},
+			{ModelName: "steady", Levels: []types.Level{types.Easy}, Call: fakeProvider("steady", nil, &calls)},
+		},
+	}
+	for i := 0; i < 10; i++ {
+		if err := s.GenerateResponse(context.Background(), &CollectWriter{}, nil, types.GenerationOptions{}, types.Easy, &types.LLMResponse{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	flaky := 0
+	for _, c := range calls {
+		if c == "flaky" {
+			flaky++
+		}
+	}
+	if flaky != DefaultBreakerConfig().MinRequests {
+		t.Errorf("flaky got called %d times", flaky)
+	}
+	for _, st := range s.ProviderStats() {
+		if st.Model == "flaky" && st.State != BreakerOpen {
+			t.Errorf("flaky stats = %+v", st)
+		}
+	}
+}
+
+func TestGenerateResponseOversizedPromptKeepsProbe(t *testing.T) {
+	var calls []string
+	s := &LLMStruct{
+		Models: []llmModel{
+			{ModelName: "small", Levels: []types.Level{types.Easy}, Call: fakeProvider("small", nil, &calls), ContextWindow: 5},
+			{ModelName: "big", Levels: []types.Level{types.Easy}, Call: fakeProvider("big", nil, &calls)},
+		},
+		Chains: map[types.Level][]string{types.Easy: {"small", "big"}},
+	}
+	//small's circuit is half open, waiting for its one probe
+	cfg := DefaultBreakerConfig()
+	b, now := newTestBreaker(cfg)
+	b.open(*now)
+	*now = now.Add(cfg.OpenFor)
+	s.breakers = map[string]*CircuitBreaker{"small": b}
+
+	long := []types.Messages{{Role: types.RoleUser, Content: strings.Repeat("word ", 20)}}
+	if err := s.GenerateResponse(context.Background(), &CollectWriter{}, long, types.GenerationOptions{}, types.Easy, &types.LLMResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	short := []types.Messages{{Role: types.RoleUser, Content: "hi"}}
+	res := &types.LLMResponse{}
+	if err := s.GenerateResponse(context.Background(), &CollectWriter{}, short, types.GenerationOptions{}, types.Easy, res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Model != "small" || b.Stats().State != BreakerClosed {
+		t.Errorf("calls %v, breaker %+v", calls, b.Stats())
+	}
+}
+
+func TestGenerateResponseCancelledProbe(t *testing.T) {
+	var calls []string
+	s := &LLMStruct{
+		Models: []llmModel{{ModelName: "flaky", Levels: []types.Level{types.Easy}, Call: fakeProvider("flaky", context.Canceled, &calls)}},
+	}
+	cfg := DefaultBreakerConfig()
+	b, now := newTestBreaker(cfg)
+	b.open(*now)
+	*now = now.Add(cfg.OpenFor)
+	s.breakers = map[string]*CircuitBreaker{"flaky": b}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := s.GenerateResponse(ctx, &CollectWriter{}, nil, types.GenerationOptions{}, types.Easy, &types.LLMResponse{}); err == nil {
+		t.Fatal("expected the cancellation to come back")
+	}
+	if st := b.Stats(); st.State != BreakerHalfOpen {
+		t.Errorf("a cancelled probe decided the circuit, stats = %+v", st)
+	}
+}
@@ -0,0 +1,94 @@
+package llm
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+
+	"github.com/Prateek-Gupta001/AI_Gateway/types"
+)
+
+// ProviderError is what the providers return when the call itself failed (as opposed to
+// the stream breaking halfway). StatusCode is 0 when the request never got a response.
+type ProviderError struct {
+	StatusCode int
+	Err        error
+}
+
+func (e *ProviderError) Error() string {
+	if e.StatusCode == 0 {
+		return fmt.Sprintf("provider unreachable: %v", e.Err)
+	}
+	return fmt.Sprintf("provider returned %d: %v", e.StatusCode, e.Err)
+}
+
+func (e *ProviderError) Unwrap() error { return e.Err }
+
+// Retryable is true for connection errors, 5xx and 429, i.e. when another model might do better.
+func (e *ProviderError) Retryable() bool {
+	return e.StatusCode == 0 || e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// isRetryable reports whether the next model in the chain should be tried after this error.
+func isRetryable(err error) bool {
+	var perr *ProviderError
+	return errors.As(err, &perr) && perr.Retryable()
+}
+
+// checkResponse turns a non 2xx response into a ProviderError carrying the body the provider sent.
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return &ProviderError{StatusCode: resp.StatusCode, Err: fmt.Errorf("%s", body)}
+}
+
+// chain returns the models to try, in order, for a level. Without a configured chain it is every
+// model at that level, then the ones above it, then the ones below it.
+func (s *LLMStruct) chain(level types.Level) []llmModel {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	byName := make(map[string]llmModel, len(s.Models))
+	for _, m := range s.Models {
+		byName[m.ModelName] = m
+	}
+	var models []llmModel
+	if names, ok := s.Chains[level]; ok {
+		for _, name := range names {
+			if m, ok := byName[name]; ok {
+				models = append(models, m)
+			}
+		}
+		return models
+	}
+	for _, l := range levelPreference(level) {
+		for _, m := range s.Models {
+			if slices.Contains(m.Levels, l) {
+				models = append(models, m)
+			}
+		}
+	}
+	return models
+}
+
+// levelPreference is the order in which levels get tried when there is no model for the
+// requested one: the level itself, then the ones above it, then the ones below it.
+func levelPreference(level types.Level) []types.Level {
+	idx := -1
+	for i, l := range types.AllLevels {
+		if l == level {
+			idx = i
+		}
+	}
+	if idx == -1 {
+		return []types.Level{level}
+	}
+	order := append([]types.Level{}, types.AllLevels[idx:]...)
+	for i := idx - 1; i >= 0; i-- {
+		order = append(order, types.AllLevels[i])
+	}
+	return order
+}
@@ -0,0 +1,124 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Prateek-Gupta001/AI_Gateway/types"
+)
+
+// fakeProvider returns err, or writes "ok from <name>" when err is nil.
+func fakeProvider(name string, err error, calls *[]string) LLMProvider {
+	return func(ctx context.Context, sw StreamWriter, messages []types.Messages, opts types.GenerationOptions, apikey string, res *types.LLMResponse) error {
+		*calls = append(*calls, name)
+		if err != nil {
+			return err
+		}
+		res.LLMRes = new(bytes.Buffer)
+		res.LLMRes.WriteString("ok from " + name)
+		return sw.WriteDelta("ok from " + name)
+	}
+}
+
+func TestGenerateResponseFallsBack(t *testing.T) {
+	cases := []struct {
+		name      string
+		firstErr  error
+		wantCalls int
+		wantModel string
+	}{
+		{"connection error", &ProviderError{Err: errors.New("connection refused")}, 2, "second"},
+		{"503", &ProviderError{StatusCode: http.StatusServiceUnavailable, Err: errors.New("overloaded")}, 2, "second"},
+		{"429", &ProviderError{StatusCode: http.StatusTooManyRequests, Err: errors.New("slow down")}, 2, "second"},
+		{"400 is not retried", &ProviderError{StatusCode: http.StatusBadRequest, Err: errors.New("bad request")}, 1, ""},
+		{"stream errors are not retried", errors.New("stream broke"), 1, ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var calls []string
+			s := &LLMStruct{
+				Models: []llmModel{
+					{ModelName: "first", Levels: []types.Level{types.Easy}, Call: fakeProvider("first", c.firstErr, &calls)},
+					{ModelName: "second", Levels: []types.Level{types.High}, Call: fakeProvider("second", nil, &calls)},
+				},
+			}
+			res := &types.LLMResponse{}
+			err := s.GenerateResponse(context.Background(), &CollectWriter{}, nil, types.GenerationOptions{}, types.Easy, res)
+			if len(calls) != c.wantCalls {
+				t.Fatalf("calls = %v", calls)
+			}
+			if c.wantModel == "" {
+				if err == nil {
+					t.Fatal("expected the error to be returned")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Model != c.wantModel || res.Level != types.Easy || res.LLMRes.String() != "ok from second" {
+				t.Errorf("res = %+v", res)
+			}
+		})
+	}
+}
+
+func TestGenerateResponseNoRetryAfterStreaming(t *testing.T) {
+	var calls []string
+	partial := func(ctx context.Context, sw StreamWriter, messages []types.Messages, opts types.GenerationOptions, apikey string, res *types.LLMResponse) error {
+		calls = append(calls, "partial")
+		sw.WriteDelta("half an answ")
+		return &ProviderError{StatusCode: http.StatusBadGateway, Err: errors.New("gone")}
+	}
+	s := &LLMStruct{
+		Models: []llmModel{
+			{ModelName: "partial", Levels: []types.Level{types.Easy}, Call: partial},
+			{ModelName: "second", Levels: []types.Level{types.Easy}, Call: fakeProvider("second", nil, &calls)},
+		},
+	}
+	sw := NewSSEWriter(httptest.NewRecorder(), "req")
+	if err := s.GenerateResponse(context.Background(), sw, nil, types.GenerationOptions{}, types.Easy, &types.LLMResponse{}); err == nil {
+		t.Fatal("expected an error")
+	}
+	if len(calls) != 1 {
+		t.Errorf("calls = %v", calls)
+	}
+}
+
+func TestChainOrder(t *testing.T) {
+	s := &LLMStruct{
+		Models: []llmModel{
+			{ModelName: "a", Levels: []types.Level{types.Easy}},
+			{ModelName: "b", Levels: []types.Level{types.High}},
+			{ModelName: "c", Levels: []types.Level{types.Medium}},
+		},
+		Chains: map[types.Level][]string{types.High: {"b", "missing", "a"}},
+	}
+	names := func(models []llmModel) (out []string) {
+		for _, m := range models {
+			out = append(out, m.ModelName)
+		}
+		return out
+	}
+	if got := names(s.chain(types.High)); len(got) != 2 || got[0] != "b" || got[1] != "a" {
+		t.Errorf("configured chain = %v", got)
+	}
+	if got := names(s.chain(types.Medium)); len(got) != 3 || got[0] != "c" || got[1] != "b" || got[2] != "a" {
+		t.Errorf("derived chain = %v", got)
+	}
+}
+
+func TestCheckResponse(t *testing.T) {
+	rec := httptest.NewRecorder()
+	rec.WriteHeader(http.StatusTooManyRequests)
+	rec.WriteString(`{"error":"rate limited"}`)
+	err := checkResponse(rec.Result())
+	var perr *ProviderError
+	if !errors.As(err, &perr) || perr.StatusCode != http.StatusTooManyRequests || !perr.Retryable() {
+		t.Errorf("err = %v", err)
+	}
+}
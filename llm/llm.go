@@ -5,6 +5,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
@@ -12,73 +13,222 @@ import (
 	"os"
 
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/Prateek-Gupta001/AI_Gateway/types"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type LLMs interface {
-	GenerateResponse(context.Context, http.ResponseWriter, []types.Messages, types.Level, *types.LLMResponse) error
+	GenerateResponse(context.Context, StreamWriter, []types.Messages, types.GenerationOptions, types.Level, *types.LLMResponse) error
+	ProviderStats() []ProviderStats
+	Reload(Registry) error
 }
 
 var Tracer = otel.Tracer("ai-gateway-service")
 
 type llmModel struct {
-	Level     types.Level
+	// Levels are the levels the model serves when there is no chain for one, a model can serve several.
+	Levels    []types.Level
 	ModelName string
 	ApiKey    string
 	Call      LLMProvider
+	Pricing   Pricing
+	// ContextWindow, MaxOutputTokens and Timeout are only enforced when set.
+	ContextWindow   int
+	MaxOutputTokens int
+	Timeout         time.Duration
 }
 
-type LLMProvider func(ctx context.Context, w http.ResponseWriter, messages []types.Messages, apikey string, llmResStruct *types.LLMResponse) error
+type LLMProvider func(ctx context.Context, sw StreamWriter, messages []types.Messages, opts types.GenerationOptions, apikey string, llmResStruct *types.LLMResponse) error
 
 type LLMStruct struct {
+	// mu guards Models and Chains, so they can be swapped by Reload while requests are running.
+	mu     sync.RWMutex
 	Models []llmModel
+	// Chains are the ordered model names to try per level. A level without a chain
+	// falls back to every model, closest level first (see chain).
+	Chains map[types.Level][]string
+	// BreakerConfig is used for every model's circuit breaker, DefaultBreakerConfig when left empty.
+	BreakerConfig BreakerConfig
+
+	breakerMu sync.Mutex
+	breakers  map[string]*CircuitBreaker
 }
 
-func (s *LLMStruct) ChangeModel(modelname string) llmModel {
-	//In future for making sure that model level changes if at that level the model failed!
-	for _, model := range s.Models {
-		if modelname != model.ModelName {
-			slog.Info("Returning a changed model", "earlier level", modelname, "new level", model.ModelName)
-			return model
+// ErrContextWindow is returned when the prompt is too long for every model that could have answered it.
+var ErrContextWindow = errors.New("the prompt doesn't fit in the context window of any model")
+
+func (s *LLMStruct) GenerateResponse(ctx context.Context, sw StreamWriter, messages []types.Messages, opts types.GenerationOptions, Level types.Level, llmResStruct *types.LLMResponse) error {
+	fmt.Println("got a request in generate response", messages, Level)
+	ctx, span := Tracer.Start(ctx, "GenerateResponse")
+	defer span.End()
+	models := s.chain(Level)
+	if len(models) == 0 {
+		return fmt.Errorf("Invalid Level type/ Not present in LLMStruct")
+	}
+	var err error
+	attempt, tooLong := 0, 0
+	for _, llm := range models {
+		//checked before the breaker, a half open circuit would otherwise hand out a probe that is never recorded
+		if llm.ContextWindow > 0 && estimateTokens(messages) > llm.ContextWindow {
+			slog.Info("Prompt doesn't fit in the context window, skipping model", "model", llm.ModelName)
+			tooLong++
+			span.AddEvent("skipped", trace.WithAttributes(
+				attribute.String("model", llm.ModelName),
+				attribute.String("reason", "context window"),
+			))
+			continue
+		}
+		//models giving off errors a lot of the time have their circuit open and get skipped
+		breaker := s.breaker(llm.ModelName)
+		ticket, ok := breaker.Allow()
+		if !ok {
+			slog.Info("Circuit is open, skipping model", "model", llm.ModelName)
+			span.AddEvent("skipped", trace.WithAttributes(
+				attribute.String("model", llm.ModelName),
+				attribute.String("reason", "circuit open"),
+			))
+			continue
+		}
+		attempt++
+		*llmResStruct = types.LLMResponse{}
+		callOpts := opts
+		if llm.MaxOutputTokens > 0 && (callOpts.MaxTokens == 0 || callOpts.MaxTokens > llm.MaxOutputTokens) {
+			callOpts.MaxTokens = llm.MaxOutputTokens
+		}
+		callCtx, cancel := ctx, context.CancelFunc(func() {})
+		if llm.Timeout > 0 {
+			callCtx, cancel = context.WithTimeout(ctx, llm.Timeout)
+		}
+		if ms, ok := sw.(ModelSetter); ok {
+			ms.SetModel(llm.ModelName)
+		}
+		start := time.Now()
+		tw := &timingWriter{StreamWriter: sw}
+		err = llm.Call(callCtx, tw, messages, callOpts, llm.ApiKey, llmResStruct)
+		cancel()
+		latency := time.Since(start)
+		if !tw.firstDelta.IsZero() {
+			latency = tw.firstDelta.Sub(start)
+		}
+		if err != nil && ctx.Err() != nil {
+			//the client is gone, that says nothing about the model either way
+			breaker.Release(ticket)
+		} else {
+			breaker.Record(ticket, latency, countsAsFailure(ctx, err))
+		}
+		span.AddEvent("attempt", trace.WithAttributes(
+			attribute.Int("attempt", attempt),
+			attribute.Int64("latency_ms", latency.Milliseconds()),
+			attribute.String("model", llm.ModelName),
+			attribute.String("level", string(Level)),
+			attribute.Bool("success", err == nil),
+			attribute.String("error", errString(err)),
+		))
+		if err == nil {
+			llmResStruct.Model = llm.ModelName
+			//the level the request was routed at, a model can serve several
+			llmResStruct.Level = Level
+			llmResStruct.Cost = llm.Pricing.Cost(llmResStruct.InputTokens, llmResStruct.OutputTokens)
+			span.SetAttributes(
+				attribute.Int("attempts", attempt),
+				attribute.String("model", llm.ModelName),
+			)
+			return nil
 		}
+		//once the client has seen some of the answer there is no going back to another model
+		if sw.Started() || !isRetryable(err) || ctx.Err() != nil {
+			break
+		}
+		slog.Info("Model failed before streaming anything, trying the next one in the chain", "model", llm.ModelName, "error", err)
+	}
+	if tooLong == len(models) {
+		err = fmt.Errorf("%w, level %s", ErrContextWindow, Level)
+	} else if attempt == 0 {
+		err = &ProviderError{StatusCode: http.StatusServiceUnavailable, Err: fmt.Errorf("every model for level %s has its circuit open", Level)}
+	}
+	span.SetAttributes(attribute.String("error", err.Error()))
+	return err
+}
+
+// estimateTokens is a rough count of the prompt tokens (about 4 characters a token), good
+// enough to tell if a prompt is anywhere near a model's context window.
+func estimateTokens(messages []types.Messages) int {
+	chars := 0
+	for _, m := range messages {
+		chars += len(m.Content)
+	}
+	return chars / 4
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
 	}
-	slog.Error("Critical Error! Model change couldn't take place ... models weren't intialised properly")
-	return s.Models[0]
+	return err.Error()
 }
 
-func (s *LLMStruct) GenerateResponse(ctx context.Context, w http.ResponseWriter, messages []types.Messages, Level types.Level, llmResStruct *types.LLMResponse) error {
-	fmt.Println("got a request in generate response", w, messages, Level)
-	//could employ a strategy here to ensure that the ones giving off the error a lot of the time is not selected!
-	//also .. make a fake .. http buffer/stream .. that I could then use .. to test things .. and actually show this running!
-	for _, llm := range s.Models {
-		if llm.Level == Level {
-			return llm.Call(ctx, w, messages, llm.ApiKey, llmResStruct)
+func NewLLMStruct() (*LLMStruct, error) {
+	s := &LLMStruct{
+		Models: []llmModel{{ModelName: "Gpt 4o", ApiKey: os.Getenv("OPENAI_API_KEY"), Levels: []types.Level{types.Easy}, Call: MockCallGptAPI, Pricing: Pricing{InputPerMillion: 2.5, OutputPerMillion: 10}},
+			{ModelName: "Gemini 2.5 flash", ApiKey: os.Getenv("GEMINI_API_KEY"), Levels: []types.Level{types.High}, Call: CallGeminiAPI, Pricing: Pricing{InputPerMillion: 0.3, OutputPerMillion: 2.5}}},
+		Chains: map[types.Level][]string{
+			types.Easy:   {"Gpt 4o", "Gemini 2.5 flash"},
+			types.Medium: {"Gemini 2.5 flash", "Gpt 4o"},
+			types.High:   {"Gemini 2.5 flash", "Gpt 4o"},
+		},
+	}
+	if baseURL := os.Getenv("LOCAL_LLM_BASE_URL"); baseURL != "" {
+		if err := addLocalModel(s, baseURL); err != nil {
+			return nil, err
 		}
 	}
-	return fmt.Errorf("Invalid Level type/ Not present in LLMStruct")
+	if key := os.Getenv("ANTHROPIC_API_KEY"); key != "" {
+		//claude is the last resort for the harder levels when it is set up
+		s.Models = append(s.Models, llmModel{ModelName: "Claude Sonnet 4.5", ApiKey: key, Levels: []types.Level{types.Medium, types.High}, Call: NewAnthropicProvider(AnthropicBaseURL, "claude-sonnet-4-5", 4096), Pricing: Pricing{InputPerMillion: 3, OutputPerMillion: 15}})
+		s.Chains[types.Medium] = append(s.Chains[types.Medium], "Claude Sonnet 4.5")
+		s.Chains[types.High] = append(s.Chains[types.High], "Claude Sonnet 4.5")
+	}
+	return s, nil
 }
 
-func NewLLMStruct() *LLMStruct {
-	return &LLMStruct{
-		Models: []llmModel{{ModelName: "Gpt 4o", ApiKey: os.Getenv("OPENAI_API_KEY"), Level: types.Easy, Call: MockCallGptAPI},
-			{ModelName: "Gemini 2.5 flash", ApiKey: os.Getenv("GEMINI_API_KEY"), Level: types.High, Call: CallGeminiAPI}},
+const (
+	OpenAIBaseURL = "https://api.openai.com/v1"
+	GeminiBaseURL = "https://generativelanguage.googleapis.com/v1beta"
+)
+
+// NewOpenAIProvider returns an LLMProvider for the OpenAI Responses API.
+func NewOpenAIProvider(baseURL string, model string) LLMProvider {
+	return func(ctx context.Context, sw StreamWriter, messages []types.Messages, opts types.GenerationOptions, apikey string, llmResStruct *types.LLMResponse) error {
+		return callGptAPI(ctx, baseURL, model, sw, messages, opts, apikey, llmResStruct)
 	}
 }
 
-func CallGptAPI(ctx context.Context, w http.ResponseWriter, messages []types.Messages, apikey string, llmResStruct *types.LLMResponse) error {
+func CallGptAPI(ctx context.Context, sw StreamWriter, messages []types.Messages, opts types.GenerationOptions, apikey string, llmResStruct *types.LLMResponse) error {
+	return callGptAPI(ctx, OpenAIBaseURL, "gpt-4o", sw, messages, opts, apikey, llmResStruct)
+}
+
+func callGptAPI(ctx context.Context, baseURL string, model string, sw StreamWriter, messages []types.Messages, opts types.GenerationOptions, apikey string, llmResStruct *types.LLMResponse) error {
 	fmt.Println("got a request in generate response", messages)
 	ctx, span := Tracer.Start(ctx, "CallGptAPI")
 	defer span.End()
 	client := &http.Client{}
 
 	requestBody := map[string]interface{}{
-		"model":  "gpt-4o", // Note: "gpt-5" does not exist yet; use "gpt-4o" or "o1-preview"
+		"model":  model,
 		"input":  CreateOpenAIMessages(messages),
 		"stream": true,
 	}
+	if opts.Temperature != nil {
+		requestBody["temperature"] = *opts.Temperature
+	}
+	if opts.MaxTokens > 0 {
+		requestBody["max_output_tokens"] = opts.MaxTokens
+	}
 
 	// Marshaling handles all formatting, escaping, and whitespace correctly
 	jsonData, err := json.Marshal(requestBody)
@@ -90,28 +240,25 @@ func CallGptAPI(ctx context.Context, w http.ResponseWriter, messages []types.Mes
 	// Create the reader from the bytes
 	var data = bytes.NewReader(jsonData)
 
-	req, err := http.NewRequest("POST", "https://api.openai.com/v1/responses", data)
+	req, err := http.NewRequestWithContext(ctx, "POST", strings.TrimRight(baseURL, "/")+"/responses", data)
 	slog.Info("request made!", "req", req)
 	if err != nil {
 		slog.Error("error happened!", "error", err)
+		return err
 	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "Bearer "+apikey)
 	resp, err := client.Do(req)
 	if err != nil {
 		slog.Error("error happened!", "error", err)
+		return &ProviderError{Err: err}
 	}
 	defer resp.Body.Close()
-	flusher, ok := w.(http.Flusher)
-	if !ok {
-		slog.Info("streaming unsupported!")
-		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
-		return nil
+	if err := checkResponse(resp); err != nil {
+		slog.Error("OpenAI returned an error", "error", err)
+		return err
 	}
 	reader := bufio.NewReader(resp.Body)
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
 	if llmResStruct.LLMRes == nil {
 		slog.Info("LLMResStruct.LLMRes was nil")
 		llmResStruct.LLMRes = new(bytes.Buffer)
@@ -145,8 +292,9 @@ func CallGptAPI(ctx context.Context, w http.ResponseWriter, messages []types.Mes
 
 			switch event.Type {
 			case "response.output_text.delta":
-				fmt.Fprintf(w, "data: %s\n\n", line)
-				flusher.Flush()
+				if err := sw.WriteDelta(event.Delta); err != nil {
+					return err
+				}
 
 				if llmResStruct.LLMRes != nil {
 					llmResStruct.LLMRes.WriteString(event.Delta)
@@ -159,89 +307,58 @@ func CallGptAPI(ctx context.Context, w http.ResponseWriter, messages []types.Mes
 					llmResStruct.InputTokens = event.Response.Usage.InputTokens
 					llmResStruct.OutputTokens = event.Response.Usage.OutputTokens
 					llmResStruct.TotalTokens = event.Response.Usage.TotalTokens
+					sw.WriteUsage(llmResStruct.InputTokens, llmResStruct.OutputTokens, llmResStruct.TotalTokens)
+				}
+				sw.WriteFinish(FinishStop)
+			case "response.incomplete":
+				sw.WriteFinish(FinishLength)
+			case "error":
+				slog.Error("OpenAI sent an error event in the stream", "message", event.Message)
+				return fmt.Errorf("openai stream error: %s", event.Message)
+			case "response.failed":
+				if event.Response != nil && event.Response.Error != nil {
+					return fmt.Errorf("openai response failed: %s", event.Response.Error.Message)
 				}
+				return fmt.Errorf("openai response failed")
 				// Break or continue as needed; the stream usually closes shortly after
 			}
 		}
 	}
-	llmResStruct.Level = types.Easy
-	llmResStruct.Model = "GPT"
 	fmt.Println("Returning from callGptAPI", llmResStruct)
 	return nil
 }
 
-func MockCallGptAPI(ctx context.Context, w http.ResponseWriter, messages []types.Messages, apikey string, llmResStruct *types.LLMResponse) error {
+func MockCallGptAPI(ctx context.Context, sw StreamWriter, messages []types.Messages, opts types.GenerationOptions, apikey string, llmResStruct *types.LLMResponse) error {
 	ctx, span := Tracer.Start(ctx, "MockCallGptAPI")
 	defer span.End()
 
-	resp, err := http.Get("http://localhost:8082/test-stream")
-	flusher, ok := w.(http.Flusher)
-	if !ok {
-		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
-		return nil
+	req, err := http.NewRequestWithContext(ctx, "GET", "http://localhost:8082/test-stream", nil)
+	if err != nil {
+		return err
 	}
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		fmt.Println("Got this err ", err)
+		return &ProviderError{Err: err}
 	}
 	defer resp.Body.Close()
-	reader := bufio.NewReader(resp.Body)
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
-	if llmResStruct.LLMRes == nil {
-		llmResStruct.LLMRes = new(bytes.Buffer)
+	if err := checkResponse(resp); err != nil {
+		return err
 	}
-	for {
-		data, err := reader.ReadString('\n')
-		if err != nil {
-			if err == io.EOF {
-				break // stream ended
-			}
-			// real error
-			fmt.Println("err ", err)
-		}
-		//use io.multiwriter here .. and write to the both the things ... the llmResStruct and to the http.responsewriter!
-		fmt.Fprint(w, data)
-		flusher.Flush()
-		var chunk = &OpenAIChunk{}
-		if strings.HasPrefix(data, "data:") {
-			dataContent := strings.TrimPrefix(data, "data:")
-			dataContent = strings.TrimSpace(dataContent)
-			if dataContent == "[DONE]" {
-				continue
-			}
-			if err := json.Unmarshal([]byte(dataContent), chunk); err != nil {
-				slog.Info("Got this error while trying to unmarshal the given chunk to json!", "error", err.Error(), "chunk", dataContent)
-				continue
-			}
-			if chunk.Usage != nil {
-				llmResStruct.InputTokens = chunk.Usage.PromptTokens
-				llmResStruct.OutputTokens = chunk.Usage.CompletionTokens
-				llmResStruct.TotalTokens = chunk.Usage.TotalTokens
-			}
-
-			if len(chunk.Choices) != 0 {
-				content := chunk.Choices[0].Delta.Content
-				if content != "" {
-					llmResStruct.LLMRes.WriteString(chunk.Choices[0].Delta.Content)
-				}
-			}
-		}
+	if err := readChatCompletionStream(resp.Body, sw, llmResStruct); err != nil {
+		return err
 	}
-	llmResStruct.Level = types.Easy
-	llmResStruct.Model = "GPT"
 	span.SetAttributes(
 		attribute.Int("total_tokens", llmResStruct.TotalTokens),
 		attribute.Int("input_tokens", llmResStruct.InputTokens),
 		attribute.Int("output_tokens", llmResStruct.OutputTokens),
-		attribute.String("model", llmResStruct.Model),
 		attribute.String("llmResponse", llmResStruct.LLMRes.String()),
-		attribute.String("level", string(llmResStruct.Level)),
 	)
 	return nil
 }
 
 type OpenAIDelta struct {
+	Role    string `json:"role,omitempty"`
 	Content string `json:"content,omitempty"`
 }
 
@@ -258,12 +375,19 @@ type OpenAIUsage struct {
 }
 
 type OpenAIChunk struct {
-	ID      string         `json:"id"`
-	Object  string         `json:"object"`
-	Created int64          `json:"created"`
-	Model   string         `json:"model"`
-	Choices []OpenAIChoice `json:"choices"`
-	Usage   *OpenAIUsage   `json:"usage,omitempty"` // Only present in the final chunk
+	ID      string             `json:"id"`
+	Object  string             `json:"object"`
+	Created int64              `json:"created"`
+	Model   string             `json:"model"`
+	Choices []OpenAIChoice     `json:"choices"`
+	Usage   *OpenAIUsage       `json:"usage,omitempty"` // Only present in the final chunk
+	Error   *OpenAIStreamError `json:"error,omitempty"` // Some servers (vLLM) send errors in the stream
+}
+
+// OpenAIStreamError leaves out the code, servers don't agree on if it is a string or a number.
+type OpenAIStreamError struct {
+	Message string `json:"message"`
+	Type    string `json:"type,omitempty"`
 }
 
 func CreateGeminiMessages(messages []types.Messages) []map[string]interface{} {
@@ -283,20 +407,42 @@ func CreateGeminiMessages(messages []types.Messages) []map[string]interface{} {
 	return msg
 }
 
-func CallGeminiAPI(ctx context.Context, w http.ResponseWriter, messages []types.Messages, apikey string, llmResStruct *types.LLMResponse) error {
+// NewGeminiProvider returns an LLMProvider for Gemini's streamGenerateContent.
+func NewGeminiProvider(baseURL string, model string) LLMProvider {
+	return func(ctx context.Context, sw StreamWriter, messages []types.Messages, opts types.GenerationOptions, apikey string, llmResStruct *types.LLMResponse) error {
+		return callGeminiAPI(ctx, baseURL, model, sw, messages, opts, apikey, llmResStruct)
+	}
+}
+
+func CallGeminiAPI(ctx context.Context, sw StreamWriter, messages []types.Messages, opts types.GenerationOptions, apikey string, llmResStruct *types.LLMResponse) error {
+	//use a non thinking model only!
+	return callGeminiAPI(ctx, GeminiBaseURL, "gemini-2.5-flash", sw, messages, opts, apikey, llmResStruct)
+}
+
+func callGeminiAPI(ctx context.Context, baseURL string, model string, sw StreamWriter, messages []types.Messages, opts types.GenerationOptions, apikey string, llmResStruct *types.LLMResponse) error {
 	ctx, span := Tracer.Start(ctx, "CallGeminiAPI")
 	defer span.End()
 	client := &http.Client{}
 	jsonRequest := map[string]interface{}{
 		"contents": CreateGeminiMessages(messages),
 	}
+	generationConfig := map[string]interface{}{}
+	if opts.Temperature != nil {
+		generationConfig["temperature"] = *opts.Temperature
+	}
+	if opts.MaxTokens > 0 {
+		generationConfig["maxOutputTokens"] = opts.MaxTokens
+	}
+	if len(generationConfig) > 0 {
+		jsonRequest["generationConfig"] = generationConfig
+	}
 	jsonData, err := json.Marshal(jsonRequest)
 	if err != nil {
 		slog.Error("Got this error while trying to marshal the llm request into json", "error", err)
 	}
 	finalReq := bytes.NewReader(jsonData)
-	req, err := http.NewRequest("POST", "https://generativelanguage.googleapis.com/v1beta/models/gemini-2.5-flash:streamGenerateContent?alt=sse", finalReq)
-	//use a non thinking model only!
+	url := fmt.Sprintf("%s/models/%s:streamGenerateContent?alt=sse", strings.TrimRight(baseURL, "/"), model)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, finalReq)
 	if err != nil {
 		slog.Error("Got this error right here", "error", err)
 		return err
@@ -306,13 +452,14 @@ func CallGeminiAPI(ctx context.Context, w http.ResponseWriter, messages []types.
 	resp, err := client.Do(req)
 	if err != nil {
 		slog.Error("Got this error right here", "error", err)
+		return &ProviderError{Err: err}
 	}
 	defer resp.Body.Close()
-	flusher := w.(http.Flusher)
+	if err := checkResponse(resp); err != nil {
+		slog.Error("Gemini returned an error", "error", err)
+		return err
+	}
 	reader := bufio.NewReader(resp.Body)
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
 	if llmResStruct.LLMRes == nil {
 		slog.Info("LLMResStruct.LLMRes was nil")
 		llmResStruct.LLMRes = new(bytes.Buffer)
@@ -326,8 +473,6 @@ func CallGeminiAPI(ctx context.Context, w http.ResponseWriter, messages []types.
 			slog.Error("Got this unexpected error inside the string", "error", err)
 			return err
 		}
-		fmt.Fprintf(w, line)
-		flusher.Flush()
 		line = strings.TrimSpace(line)
 		if strings.HasPrefix(line, "data:") {
 			jsonContent := strings.TrimPrefix(line, "data:")
@@ -338,23 +483,31 @@ func CallGeminiAPI(ctx context.Context, w http.ResponseWriter, messages []types.
 			if err != nil {
 				slog.Error("Got this error while trying to unmarshal the json in Gemini", "error", err)
 			}
+			if chunk.Error != nil {
+				slog.Error("Gemini sent an error in the stream", "status", chunk.Error.Status, "message", chunk.Error.Message)
+				return fmt.Errorf("gemini stream error (%s): %s", chunk.Error.Status, chunk.Error.Message)
+			}
 			if len(chunk.Candidates) > 0 && len(chunk.Candidates[0].Content.Parts) > 0 {
 				textChunk := chunk.Candidates[0].Content.Parts[0].Text
+				if err := sw.WriteDelta(textChunk); err != nil {
+					return err
+				}
 				llmResStruct.LLMRes.WriteString(textChunk)
 			}
+			if len(chunk.Candidates) > 0 && chunk.Candidates[0].FinishReason != "" {
+				sw.WriteFinish(normalizeFinishReason(chunk.Candidates[0].FinishReason))
+			}
 
 			if chunk.UsageMetadata != nil {
 				llmResStruct.InputTokens = chunk.UsageMetadata.PromptTokenCount
 				llmResStruct.OutputTokens = chunk.UsageMetadata.CandidatesTokenCount
 				llmResStruct.TotalTokens = chunk.UsageMetadata.TotalTokenCount
+				sw.WriteUsage(llmResStruct.InputTokens, llmResStruct.OutputTokens, llmResStruct.TotalTokens)
 			}
 
 		}
 
 	}
-	llmResStruct.Model = "Gemini"
-	llmResStruct.Level = types.High
-
 	return nil
 }
 
@@ -362,6 +515,13 @@ func CallGeminiAPI(ctx context.Context, w http.ResponseWriter, messages []types.
 type GeminiStreamResponse struct {
 	Candidates    []Candidate    `json:"candidates"`
 	UsageMetadata *UsageMetadata `json:"usageMetadata,omitempty"` // Pointer as it's not always present
+	Error         *GeminiError   `json:"error,omitempty"`
+}
+
+type GeminiError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Status  string `json:"status"`
 }
 
 type Candidate struct {
@@ -410,12 +570,19 @@ type ResponsesStreamEvent struct {
 	OutputIndex  int       `json:"output_index,omitempty"`
 	ContentIndex int       `json:"content_index,omitempty"`
 	ItemId       string    `json:"item_id,omitempty"`
+	Message      string    `json:"message,omitempty"` //only on "error" events
 }
 
 type Response struct {
-	ID     string `json:"id"`
-	Status string `json:"status"`
-	Usage  *Usage `json:"usage,omitempty"`
+	ID     string         `json:"id"`
+	Status string         `json:"status"`
+	Usage  *Usage         `json:"usage,omitempty"`
+	Error  *ResponseError `json:"error,omitempty"`
+}
+
+type ResponseError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
 }
 
 type Item struct {
@@ -0,0 +1,167 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/Prateek-Gupta001/AI_Gateway/types"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// NewOpenAICompatibleProvider returns an LLMProvider for any server speaking the OpenAI Chat
+// Completions API, like Ollama (http://localhost:11434/v1) or vLLM (http://host:8000/v1).
+// baseURL is what you would give an OpenAI SDK as its base url. The api key is optional, the
+// Authorization header is only sent when there is one.
+func NewOpenAICompatibleProvider(baseURL string, model string) LLMProvider {
+	endpoint := strings.TrimRight(baseURL, "/") + "/chat/completions"
+	return func(ctx context.Context, sw StreamWriter, messages []types.Messages, opts types.GenerationOptions, apikey string, llmResStruct *types.LLMResponse) error {
+		ctx, span := Tracer.Start(ctx, "CallOpenAICompatibleAPI")
+		defer span.End()
+		span.SetAttributes(
+			attribute.String("endpoint", endpoint),
+			attribute.String("model", model),
+		)
+		requestBody := map[string]interface{}{
+			"model":          model,
+			"messages":       CreateOpenAIMessages(messages),
+			"stream":         true,
+			"stream_options": map[string]bool{"include_usage": true},
+		}
+		if opts.Temperature != nil {
+			requestBody["temperature"] = *opts.Temperature
+		}
+		if opts.MaxTokens > 0 {
+			requestBody["max_tokens"] = opts.MaxTokens
+		}
+		jsonData, err := json.Marshal(requestBody)
+		if err != nil {
+			slog.Error("Failed to marshal request", "error", err)
+			return err
+		}
+		req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(jsonData))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if apikey != "" {
+			req.Header.Set("Authorization", "Bearer "+apikey)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			slog.Error("Got this error while trying to call the OpenAI compatible server", "endpoint", endpoint, "error", err)
+			return &ProviderError{Err: err}
+		}
+		defer resp.Body.Close()
+		if err := checkResponse(resp); err != nil {
+			slog.Error("OpenAI compatible server returned an error", "endpoint", endpoint, "error", err)
+			return err
+		}
+		if err := readChatCompletionStream(resp.Body, sw, llmResStruct); err != nil {
+			return err
+		}
+		span.SetAttributes(
+			attribute.Int("total_tokens", llmResStruct.TotalTokens),
+			attribute.Int("input_tokens", llmResStruct.InputTokens),
+			attribute.Int("output_tokens", llmResStruct.OutputTokens),
+		)
+		return nil
+	}
+}
+
+// readChatCompletionStream reads chat.completion.chunk SSE events and writes them through sw.
+func readChatCompletionStream(body io.Reader, sw StreamWriter, llmResStruct *types.LLMResponse) error {
+	reader := bufio.NewReader(body)
+	if llmResStruct.LLMRes == nil {
+		llmResStruct.LLMRes = new(bytes.Buffer)
+	}
+	for {
+		data, err := reader.ReadString('\n')
+		if err != nil {
+			if err == io.EOF {
+				break // stream ended
+			}
+			// real error
+			fmt.Println("err ", err)
+			return err
+		}
+		var chunk = &OpenAIChunk{}
+		if strings.HasPrefix(data, "data:") {
+			dataContent := strings.TrimPrefix(data, "data:")
+			dataContent = strings.TrimSpace(dataContent)
+			if dataContent == "[DONE]" {
+				continue
+			}
+			if err := json.Unmarshal([]byte(dataContent), chunk); err != nil {
+				slog.Info("Got this error while trying to unmarshal the given chunk to json!", "error", err.Error(), "chunk", dataContent)
+				continue
+			}
+			if chunk.Error != nil {
+				slog.Error("Got an error in the chat completion stream", "message", chunk.Error.Message)
+				return fmt.Errorf("stream error: %s", chunk.Error.Message)
+			}
+			if chunk.Usage != nil {
+				llmResStruct.InputTokens = chunk.Usage.PromptTokens
+				llmResStruct.OutputTokens = chunk.Usage.CompletionTokens
+				llmResStruct.TotalTokens = chunk.Usage.TotalTokens
+				sw.WriteUsage(llmResStruct.InputTokens, llmResStruct.OutputTokens, llmResStruct.TotalTokens)
+			}
+
+			if len(chunk.Choices) != 0 {
+				content := chunk.Choices[0].Delta.Content
+				if content != "" {
+					if err := sw.WriteDelta(content); err != nil {
+						return err
+					}
+					llmResStruct.LLMRes.WriteString(chunk.Choices[0].Delta.Content)
+				}
+				if chunk.Choices[0].FinishReason != nil {
+					sw.WriteFinish(normalizeFinishReason(*chunk.Choices[0].FinishReason))
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// addLocalModel puts a self hosted OpenAI compatible model at the front of the chains for the
+// levels in LOCAL_LLM_LEVELS (comma separated, easy by default). The other models stay in the
+// chain behind it as the fallback. An unknown level is an error so a typo doesn't quietly leave
+// the local model out of routing.
+func addLocalModel(s *LLMStruct, baseURL string) error {
+	model := os.Getenv("LOCAL_LLM_MODEL")
+	name := os.Getenv("LOCAL_LLM_NAME")
+	if name == "" {
+		name = "Local " + model
+	}
+	levels := []types.Level{types.Easy}
+	if env := os.Getenv("LOCAL_LLM_LEVELS"); env != "" {
+		levels = nil
+		for _, l := range strings.Split(env, ",") {
+			level := types.Level(strings.TrimSpace(l))
+			if !slices.Contains(types.AllLevels, level) {
+				return fmt.Errorf("LOCAL_LLM_LEVELS: unknown level %q", level)
+			}
+			levels = append(levels, level)
+		}
+	}
+	s.Models = append(s.Models, llmModel{
+		ModelName: name,
+		ApiKey:    os.Getenv("LOCAL_LLM_API_KEY"),
+		Levels:    levels,
+		Call:      NewOpenAICompatibleProvider(baseURL, model),
+	})
+	for _, level := range levels {
+		s.Chains[level] = append([]string{name}, s.Chains[level]...)
+	}
+	slog.Info("Routing to a local model", "name", name, "baseURL", baseURL, "levels", levels)
+	return nil
+}
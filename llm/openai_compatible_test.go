@@ -0,0 +1,104 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/Prateek-Gupta001/AI_Gateway/types"
+)
+
+func TestOpenAICompatibleStream(t *testing.T) {
+	data, err := os.ReadFile("testdata/ollama_stream.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body map[string]any
+	var auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			t.Errorf("path = %s", r.URL.Path)
+		}
+		auth = r.Header.Get("Authorization")
+		raw, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(raw, &body); err != nil {
+			t.Errorf("request body is not json: %v", err)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write(data)
+	}))
+	defer srv.Close()
+
+	call := NewOpenAICompatibleProvider(srv.URL+"/v1/", "llama3.2")
+	temperature := 0.2
+	collector := &CollectWriter{}
+	res := &types.LLMResponse{}
+	err = call(context.Background(), collector, []types.Messages{{Role: types.RoleUser, Content: "Capital of France?"}}, types.GenerationOptions{Temperature: &temperature}, "", res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth != "" {
+		t.Errorf("no api key was set, but got Authorization %q", auth)
+	}
+	if body["model"] != "llama3.2" || body["stream"] != true || body["temperature"] != 0.2 {
+		t.Errorf("body = %v", body)
+	}
+	if _, ok := body["max_tokens"]; ok {
+		t.Errorf("max_tokens should be left out when unset")
+	}
+	if res.LLMRes.String() != "Paris is the capital of France." {
+		t.Errorf("text = %q", res.LLMRes.String())
+	}
+	if res.TotalTokens != 22 || collector.FinishReason != FinishStop {
+		t.Errorf("res = %+v, collector = %+v", res, collector)
+	}
+
+	_ = call(context.Background(), &CollectWriter{}, []types.Messages{{Role: types.RoleUser, Content: "Hi"}}, types.GenerationOptions{}, "secret", &types.LLMResponse{})
+	if auth != "Bearer secret" {
+		t.Errorf("Authorization = %q", auth)
+	}
+}
+
+func TestOpenAICompatibleStreamError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: {\"error\":{\"message\":\"model not loaded\"}}\n\n"))
+	}))
+	defer srv.Close()
+	call := NewOpenAICompatibleProvider(srv.URL, "llama3.2")
+	err := call(context.Background(), &CollectWriter{}, []types.Messages{{Role: types.RoleUser, Content: "Hi"}}, types.GenerationOptions{}, "", &types.LLMResponse{})
+	if err == nil {
+		t.Fatal("expected the in stream error to be returned")
+	}
+}
+
+func TestAddLocalModel(t *testing.T) {
+	t.Setenv("LOCAL_LLM_MODEL", "llama3.2")
+	t.Setenv("LOCAL_LLM_LEVELS", "easy, medium")
+	s := &LLMStruct{Chains: map[types.Level][]string{
+		types.Easy:   {"Gpt 4o"},
+		types.Medium: {"Gemini 2.5 flash"},
+	}}
+	if err := addLocalModel(s, "http://localhost:11434/v1"); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Models) != 1 || s.Models[0].ModelName != "Local llama3.2" {
+		t.Fatalf("models = %+v", s.Models)
+	}
+	if s.Chains[types.Easy][0] != "Local llama3.2" || s.Chains[types.Medium][0] != "Local llama3.2" || len(s.Chains[types.Medium]) != 2 {
+		t.Errorf("chains = %v", s.Chains)
+	}
+	if len(s.Chains[types.High]) != 0 {
+		t.Errorf("high should be untouched, got %v", s.Chains[types.High])
+	}
+
+	t.Setenv("LOCAL_LLM_LEVELS", "easy, hard")
+	if err := addLocalModel(&LLMStruct{Chains: map[types.Level][]string{}}, "http://localhost:11434/v1"); err == nil || !strings.Contains(err.Error(), `"hard"`) {
+		t.Errorf("unknown level: err = %v", err)
+	}
+}
@@ -0,0 +1,210 @@
+package llm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/Prateek-Gupta001/AI_Gateway/types"
+)
+
+// Registry is the model registry file MODEL_REGISTRY points to (see model_registry.example.json).
+// It describes every model the gateway can route to, so adding or swapping one is a config change.
+type Registry struct {
+	Models []ModelConfig `json:"models"`
+	// Chains are optional. Without them every level's chain is the models listing that level,
+	// in the order they appear in the file.
+	Chains map[types.Level][]string `json:"chains,omitempty"`
+}
+
+type ModelConfig struct {
+	Name     string `json:"name"`
+	Provider string `json:"provider"`
+	// Endpoint is the base url of the API, the provider's public one when left empty.
+	// It is required for openai_compatible.
+	Endpoint string        `json:"endpoint,omitempty"`
+	Model    string        `json:"model"`
+	Levels   []types.Level `json:"levels"`
+	// APIKeyEnv is the env var holding the API key, the key itself never goes in the file.
+	APIKeyEnv       string  `json:"api_key_env,omitempty"`
+	Pricing         Pricing `json:"pricing"`
+	ContextWindow   int     `json:"context_window,omitempty"`
+	MaxOutputTokens int     `json:"max_output_tokens,omitempty"`
+	TimeoutMs       int     `json:"timeout_ms,omitempty"`
+}
+
+// Pricing is in dollars per million tokens.
+type Pricing struct {
+	InputPerMillion  float64 `json:"input_per_million"`
+	OutputPerMillion float64 `json:"output_per_million"`
+}
+
+// Cost is what a call with these token counts costs in dollars.
+func (p Pricing) Cost(inputTokens, outputTokens int) float64 {
+	return (float64(inputTokens)*p.InputPerMillion + float64(outputTokens)*p.OutputPerMillion) / 1e6
+}
+
+const (
+	ProviderOpenAI           = "openai"
+	ProviderGemini           = "gemini"
+	ProviderAnthropic        = "anthropic"
+	ProviderOpenAICompatible = "openai_compatible"
+	ProviderMock             = "mock"
+)
+
+// keyRequired is which providers can't be called without an API key.
+var keyRequired = map[string]bool{
+	ProviderOpenAI:           true,
+	ProviderGemini:           true,
+	ProviderAnthropic:        true,
+	ProviderOpenAICompatible: false,
+	ProviderMock:             false,
+}
+
+func LoadRegistry(path string) (Registry, error) {
+	var reg Registry
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return reg, err
+	}
+	if err := json.Unmarshal(data, &reg); err != nil {
+		return reg, fmt.Errorf("could not parse model registry %s: %w", path, err)
+	}
+	if err := reg.Validate(); err != nil {
+		return reg, fmt.Errorf("invalid model registry %s: %w", path, err)
+	}
+	return reg, nil
+}
+
+// Validate checks the whole registry and returns every problem it finds, not just the first one.
+func (r Registry) Validate() error {
+	var errs []error
+	if len(r.Models) == 0 {
+		errs = append(errs, errors.New("no models configured"))
+	}
+	names := map[string]bool{}
+	for i, m := range r.Models {
+		name := m.Name
+		if name == "" {
+			errs = append(errs, fmt.Errorf("model %d: name is required", i))
+			name = fmt.Sprintf("#%d", i)
+		} else if names[name] {
+			errs = append(errs, fmt.Errorf("model %q: duplicate name", name))
+		}
+		names[name] = true
+
+		required, known := keyRequired[m.Provider]
+		if !known {
+			errs = append(errs, fmt.Errorf("model %q: unknown provider type %q (expected one of openai, gemini, anthropic, openai_compatible, mock)", name, m.Provider))
+		}
+		if m.Model == "" && m.Provider != ProviderMock {
+			errs = append(errs, fmt.Errorf("model %q: model id is required", name))
+		}
+		if m.Provider == ProviderOpenAICompatible && m.Endpoint == "" {
+			errs = append(errs, fmt.Errorf("model %q: endpoint is required for openai_compatible", name))
+		}
+		if required && m.APIKeyEnv == "" {
+			errs = append(errs, fmt.Errorf("model %q: api_key_env is required for %s", name, m.Provider))
+		}
+		if m.APIKeyEnv != "" && os.Getenv(m.APIKeyEnv) == "" {
+			errs = append(errs, fmt.Errorf("model %q: env var %s is not set", name, m.APIKeyEnv))
+		}
+		if len(m.Levels) == 0 {
+			errs = append(errs, fmt.Errorf("model %q: at least one level is required", name))
+		}
+		for _, l := range m.Levels {
+			if !slices.Contains(types.AllLevels, l) {
+				errs = append(errs, fmt.Errorf("model %q: unknown level %q", name, l))
+			}
+		}
+		if m.Pricing.InputPerMillion < 0 || m.Pricing.OutputPerMillion < 0 {
+			errs = append(errs, fmt.Errorf("model %q: pricing can't be negative", name))
+		}
+		if m.ContextWindow < 0 || m.MaxOutputTokens < 0 || m.TimeoutMs < 0 {
+			errs = append(errs, fmt.Errorf("model %q: context_window, max_output_tokens and timeout_ms can't be negative", name))
+		}
+	}
+	for level, chain := range r.Chains {
+		if !slices.Contains(types.AllLevels, level) {
+			errs = append(errs, fmt.Errorf("chains: unknown level %q", level))
+		}
+		for _, name := range chain {
+			if !names[name] {
+				errs = append(errs, fmt.Errorf("chains: level %s refers to unknown model %q", level, name))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// NewLLMStructFromRegistry builds the LLMStruct described by a validated registry.
+func NewLLMStructFromRegistry(reg Registry) (*LLMStruct, error) {
+	if err := reg.Validate(); err != nil {
+		return nil, err
+	}
+	s := &LLMStruct{Chains: map[types.Level][]string{}}
+	for _, m := range reg.Models {
+		s.Models = append(s.Models, llmModel{
+			ModelName:       m.Name,
+			Levels:          m.Levels,
+			ApiKey:          os.Getenv(m.APIKeyEnv),
+			Call:            newProvider(m),
+			Pricing:         m.Pricing,
+			ContextWindow:   m.ContextWindow,
+			MaxOutputTokens: m.MaxOutputTokens,
+			Timeout:         time.Duration(m.TimeoutMs) * time.Millisecond,
+		})
+		if reg.Chains == nil {
+			for _, l := range m.Levels {
+				s.Chains[l] = append(s.Chains[l], m.Name)
+			}
+		}
+	}
+	for level, chain := range reg.Chains {
+		s.Chains[level] = chain
+	}
+	return s, nil
+}
+
+// Reload swaps in the models and chains of a new registry. Requests that are already running
+// keep going with the models they started with, and the circuit breakers of models that are
+// still around keep their history.
+func (s *LLMStruct) Reload(reg Registry) error {
+	next, err := NewLLMStructFromRegistry(reg)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Models = next.Models
+	s.Chains = next.Chains
+	return nil
+}
+
+func newProvider(m ModelConfig) LLMProvider {
+	switch m.Provider {
+	case ProviderOpenAI:
+		return NewOpenAIProvider(orDefault(m.Endpoint, OpenAIBaseURL), m.Model)
+	case ProviderGemini:
+		return NewGeminiProvider(orDefault(m.Endpoint, GeminiBaseURL), m.Model)
+	case ProviderAnthropic:
+		maxTokens := m.MaxOutputTokens
+		if maxTokens == 0 {
+			maxTokens = 4096
+		}
+		return NewAnthropicProvider(orDefault(m.Endpoint, AnthropicBaseURL), m.Model, maxTokens)
+	case ProviderOpenAICompatible:
+		return NewOpenAICompatibleProvider(m.Endpoint, m.Model)
+	}
+	return MockCallGptAPI
+}
+
+func orDefault(s string, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
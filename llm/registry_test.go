@@ -0,0 +1,137 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Prateek-Gupta001/AI_Gateway/types"
+)
+
+func TestLoadRegistryExample(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "sk-test")
+	t.Setenv("GEMINI_API_KEY", "gm-test")
+	t.Setenv("ANTHROPIC_API_KEY", "an-test")
+	reg, err := LoadRegistry("../model_registry.example.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewLLMStructFromRegistry(reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Models) != 4 || s.Models[1].ApiKey != "sk-test" || s.Models[3].Timeout != 2*time.Minute {
+		t.Errorf("models = %+v", s.Models)
+	}
+	if got := s.chain(types.Easy); len(got) != 3 || got[0].ModelName != "Llama 3.2 (local)" {
+		t.Errorf("easy chain = %v", got)
+	}
+}
+
+func TestRegistryValidation(t *testing.T) {
+	t.Setenv("MISSING_KEY_FOR_TEST", "")
+	reg := Registry{
+		Models: []ModelConfig{
+			{Name: "a", Provider: "cohere", Model: "x", Levels: []types.Level{types.Easy}},
+			{Name: "b", Provider: ProviderOpenAI, Model: "gpt-4o", Levels: []types.Level{"hard"}},
+			{Name: "c", Provider: ProviderGemini, Model: "gemini", Levels: []types.Level{types.High}, APIKeyEnv: "MISSING_KEY_FOR_TEST"},
+			{Name: "d", Provider: ProviderOpenAICompatible, Model: "llama3.2", Levels: []types.Level{types.Easy}},
+		},
+		Chains: map[types.Level][]string{types.High: {"c", "nope"}},
+	}
+	err := reg.Validate()
+	if err == nil {
+		t.Fatal("expected the registry to be invalid")
+	}
+	for _, want := range []string{
+		`unknown provider type "cohere"`,
+		`"b": api_key_env is required for openai`,
+		`"b": unknown level "hard"`,
+		`env var MISSING_KEY_FOR_TEST is not set`,
+		`endpoint is required for openai_compatible`,
+		`unknown model "nope"`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error is missing %q:\n%v", want, err)
+		}
+	}
+}
+
+func TestRegistryChainsFromLevels(t *testing.T) {
+	s, err := NewLLMStructFromRegistry(Registry{Models: []ModelConfig{
+		{Name: "local", Provider: ProviderOpenAICompatible, Endpoint: "http://localhost:11434/v1", Model: "llama3.2", Levels: []types.Level{types.Easy, types.Medium}},
+		{Name: "mock", Provider: ProviderMock, Levels: []types.Level{types.Easy}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Chains[types.Easy]; len(got) != 2 || got[0] != "local" || got[1] != "mock" {
+		t.Errorf("easy chain = %v", got)
+	}
+	if got := s.Chains[types.Medium]; len(got) != 1 || got[0] != "local" {
+		t.Errorf("medium chain = %v", got)
+	}
+	//a model listed for several levels is reported with all of them
+	if st := s.ProviderStats()[0]; st.Model != "local" || len(st.Levels) != 2 || st.Levels[1] != types.Medium {
+		t.Errorf("stats = %+v", st)
+	}
+}
+
+func TestGenerateResponseReportsRoutedLevel(t *testing.T) {
+	var calls []string
+	s := &LLMStruct{
+		Models: []llmModel{{ModelName: "multi", Levels: []types.Level{types.Medium}, Call: fakeProvider("multi", nil, &calls)}},
+		Chains: map[types.Level][]string{types.Medium: {"multi"}, types.High: {"multi"}},
+	}
+	for _, level := range []types.Level{types.Medium, types.High} {
+		res := &types.LLMResponse{}
+		if err := s.GenerateResponse(context.Background(), &CollectWriter{}, nil, types.GenerationOptions{}, level, res); err != nil {
+			t.Fatal(err)
+		}
+		if res.Level != level {
+			t.Errorf("routed at %s, reported %s", level, res.Level)
+		}
+	}
+}
+
+func TestGenerateResponseModelLimits(t *testing.T) {
+	var seenMaxTokens int
+	var hadDeadline bool
+	capture := func(ctx context.Context, sw StreamWriter, messages []types.Messages, opts types.GenerationOptions, apikey string, res *types.LLMResponse) error {
+		seenMaxTokens = opts.MaxTokens
+		_, hadDeadline = ctx.Deadline()
+		return nil
+	}
+	tooSmall := func(ctx context.Context, sw StreamWriter, messages []types.Messages, opts types.GenerationOptions, apikey string, res *types.LLMResponse) error {
+		return errors.New("should have been skipped")
+	}
+	s := &LLMStruct{
+		Models: []llmModel{
+			{ModelName: "small", Levels: []types.Level{types.Easy}, Call: tooSmall, ContextWindow: 5},
+			{ModelName: "big", Levels: []types.Level{types.Easy}, Call: capture, MaxOutputTokens: 100, Timeout: time.Second},
+		},
+		Chains: map[types.Level][]string{types.Easy: {"small", "big"}},
+	}
+	res := &types.LLMResponse{}
+	messages := []types.Messages{{Role: types.RoleUser, Content: strings.Repeat("word ", 20)}}
+	if err := s.GenerateResponse(context.Background(), &CollectWriter{}, messages, types.GenerationOptions{MaxTokens: 500}, types.Easy, res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Model != "big" || seenMaxTokens != 100 || !hadDeadline {
+		t.Errorf("model = %s, max tokens = %d, deadline = %v", res.Model, seenMaxTokens, hadDeadline)
+	}
+}
+
+func TestGenerateResponseContextWindowError(t *testing.T) {
+	var calls []string
+	s := &LLMStruct{
+		Models: []llmModel{{ModelName: "small", Levels: []types.Level{types.Easy}, Call: fakeProvider("small", nil, &calls), ContextWindow: 5}},
+	}
+	messages := []types.Messages{{Role: types.RoleUser, Content: strings.Repeat("word ", 20)}}
+	err := s.GenerateResponse(context.Background(), &CollectWriter{}, messages, types.GenerationOptions{}, types.Easy, &types.LLMResponse{})
+	if !errors.Is(err, ErrContextWindow) || len(calls) != 0 {
+		t.Errorf("err = %v, calls = %v", err, calls)
+	}
+}
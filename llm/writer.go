@@ -0,0 +1,293 @@
+package llm
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Prateek-Gupta001/AI_Gateway/types"
+)
+
+// StreamWriter is what every LLMProvider writes its output through, so the provider
+// doesn't need to know which wire format the client asked for.
+type StreamWriter interface {
+	WriteDelta(text string) error
+	WriteUsage(inputTokens, outputTokens, totalTokens int)
+	WriteFinish(reason string)
+	WriteError(err error) error
+	// Started reports if anything has been sent to the client yet. Once it has, errors
+	// can only be reported in band with WriteError.
+	Started() bool
+	Close() error
+}
+
+func startStream(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+}
+
+// SSEWriter writes the gateway's own stream format (types.StreamEvent), this is what /chat speaks.
+// Every event goes out as "event: <type>" followed by the json encoded event on the data line.
+type SSEWriter struct {
+	w            http.ResponseWriter
+	flusher      http.Flusher
+	started      bool
+	finishReason string
+	usage        *types.StreamUsage
+	meta         types.StreamMeta
+}
+
+func NewSSEWriter(w http.ResponseWriter, requestId string) *SSEWriter {
+	flusher, _ := w.(http.Flusher)
+	return &SSEWriter{
+		w:       w,
+		flusher: flusher,
+		meta:    types.StreamMeta{RequestId: requestId},
+	}
+}
+
+func (s *SSEWriter) writeEvent(event types.StreamEvent) error {
+	if !s.started {
+		startStream(s.w)
+		s.started = true
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+		return err
+	}
+	if s.flusher != nil {
+		s.flusher.Flush()
+	}
+	return nil
+}
+
+func (s *SSEWriter) WriteDelta(text string) error {
+	return s.writeEvent(types.StreamEvent{Type: types.EventDelta, Text: text})
+}
+
+func (s *SSEWriter) WriteUsage(inputTokens, outputTokens, totalTokens int) {
+	//providers like gemini send the usage with every chunk, so only the last one is sent out on Close
+	s.usage = &types.StreamUsage{
+		InputTokens:  inputTokens,
+		OutputTokens: outputTokens,
+		TotalTokens:  totalTokens,
+	}
+}
+
+func (s *SSEWriter) WriteFinish(reason string) {
+	s.finishReason = reason
+}
+
+// SetMeta fills in what gets sent along with the done event.
+func (s *SSEWriter) SetMeta(model string, level types.Level) {
+	s.meta.Model = model
+	s.meta.Level = level
+}
+
+// SetCacheHit marks the stream as a replayed cache answer in the done event.
+func (s *SSEWriter) SetCacheHit(cachedQuery string, score float32) {
+	s.meta.CacheHit = true
+	s.meta.CachedQuery = cachedQuery
+	s.meta.Score = score
+}
+
+func (s *SSEWriter) WriteError(err error) error {
+	if werr := s.writeEvent(types.StreamEvent{Type: types.EventError, Error: err.Error()}); werr != nil {
+		return werr
+	}
+	return s.writeEvent(types.StreamEvent{Type: types.EventDone, StreamMeta: &s.meta})
+}
+
+func (s *SSEWriter) Started() bool { return s.started }
+
+// Close writes the usage, finish and done events.
+func (s *SSEWriter) Close() error {
+	if s.usage != nil {
+		if err := s.writeEvent(types.StreamEvent{Type: types.EventUsage, Usage: s.usage}); err != nil {
+			return err
+		}
+	}
+	reason := s.finishReason
+	if reason == "" {
+		reason = FinishStop
+	}
+	if err := s.writeEvent(types.StreamEvent{Type: types.EventFinish, FinishReason: reason}); err != nil {
+		return err
+	}
+	return s.writeEvent(types.StreamEvent{Type: types.EventDone, StreamMeta: &s.meta})
+}
+
+// ModelSetter is implemented by the writers that name the model in what they send before the
+// answer is done. GenerateResponse tells them which model is answering before it calls it.
+type ModelSetter interface {
+	SetModel(model string)
+}
+
+// OpenAIStreamWriter writes the output as standard chat.completion.chunk SSE events.
+type OpenAIStreamWriter struct {
+	w            http.ResponseWriter
+	flusher      http.Flusher
+	id           string
+	model        string
+	created      int64
+	includeUsage bool
+	started      bool
+	finishReason string
+	usage        *OpenAIUsage
+}
+
+func NewOpenAIStreamWriter(w http.ResponseWriter, id string, model string, includeUsage bool) *OpenAIStreamWriter {
+	flusher, _ := w.(http.Flusher)
+	return &OpenAIStreamWriter{
+		w:            w,
+		flusher:      flusher,
+		id:           id,
+		model:        model,
+		created:      time.Now().Unix(),
+		includeUsage: includeUsage,
+	}
+}
+
+// SetModel changes the model the chunks from now on are sent with.
+func (o *OpenAIStreamWriter) SetModel(model string) { o.model = model }
+
+func (o *OpenAIStreamWriter) writeChunk(chunk OpenAIChunk) error {
+	if !o.started {
+		startStream(o.w)
+		o.started = true
+	}
+	chunk.ID = o.id
+	chunk.Object = "chat.completion.chunk"
+	chunk.Created = o.created
+	chunk.Model = o.model
+	data, err := json.Marshal(chunk)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(o.w, "data: %s\n\n", data); err != nil {
+		return err
+	}
+	if o.flusher != nil {
+		o.flusher.Flush()
+	}
+	return nil
+}
+
+func (o *OpenAIStreamWriter) WriteDelta(text string) error {
+	delta := OpenAIDelta{Content: text}
+	if !o.started {
+		//the first chunk carries the role, just like OpenAI does it
+		delta.Role = string(types.RoleAssistant)
+	}
+	return o.writeChunk(OpenAIChunk{
+		Choices: []OpenAIChoice{{Index: 0, Delta: delta}},
+	})
+}
+
+func (o *OpenAIStreamWriter) WriteUsage(inputTokens, outputTokens, totalTokens int) {
+	o.usage = &OpenAIUsage{
+		PromptTokens:     inputTokens,
+		CompletionTokens: outputTokens,
+		TotalTokens:      totalTokens,
+	}
+}
+
+func (o *OpenAIStreamWriter) WriteFinish(reason string) {
+	o.finishReason = reason
+}
+
+// WriteError sends the error the way OpenAI does it mid stream, followed by the [DONE] marker.
+func (o *OpenAIStreamWriter) WriteError(err error) error {
+	if !o.started {
+		startStream(o.w)
+		o.started = true
+	}
+	data, merr := json.Marshal(map[string]any{
+		"error": map[string]any{"message": err.Error(), "type": "upstream_error", "code": nil},
+	})
+	if merr != nil {
+		return merr
+	}
+	if _, werr := fmt.Fprintf(o.w, "data: %s\n\ndata: [DONE]\n\n", data); werr != nil {
+		return werr
+	}
+	if o.flusher != nil {
+		o.flusher.Flush()
+	}
+	return nil
+}
+
+func (o *OpenAIStreamWriter) Started() bool { return o.started }
+
+// Close writes the finish chunk, the usage chunk (if the client asked for it) and the [DONE] marker.
+func (o *OpenAIStreamWriter) Close() error {
+	reason := o.finishReason
+	if reason == "" {
+		reason = FinishStop
+	}
+	if err := o.writeChunk(OpenAIChunk{
+		Choices: []OpenAIChoice{{Index: 0, Delta: OpenAIDelta{}, FinishReason: &reason}},
+	}); err != nil {
+		return err
+	}
+	if o.includeUsage && o.usage != nil {
+		if err := o.writeChunk(OpenAIChunk{Choices: []OpenAIChoice{}, Usage: o.usage}); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprint(o.w, "data: [DONE]\n\n"); err != nil {
+		return err
+	}
+	if o.flusher != nil {
+		o.flusher.Flush()
+	}
+	return nil
+}
+
+// CollectWriter doesn't write anything to the client. The text is already being collected
+// in types.LLMResponse by the providers, this just keeps track of the rest.
+type CollectWriter struct {
+	FinishReason string
+	InputTokens  int
+	OutputTokens int
+	TotalTokens  int
+}
+
+func (c *CollectWriter) WriteDelta(text string) error { return nil }
+
+func (c *CollectWriter) WriteUsage(inputTokens, outputTokens, totalTokens int) {
+	c.InputTokens = inputTokens
+	c.OutputTokens = outputTokens
+	c.TotalTokens = totalTokens
+}
+
+func (c *CollectWriter) WriteFinish(reason string) { c.FinishReason = reason }
+
+func (c *CollectWriter) WriteError(err error) error { return nil }
+
+func (c *CollectWriter) Started() bool { return false }
+
+func (c *CollectWriter) Close() error { return nil }
+
+const (
+	FinishStop   = "stop"
+	FinishLength = "length"
+)
+
+// normalizeFinishReason maps the provider specific finish reasons onto the OpenAI ones.
+func normalizeFinishReason(reason string) string {
+	switch reason {
+	case "", "STOP", "stop", "completed", "end_turn", "stop_sequence":
+		return FinishStop
+	case "MAX_TOKENS", "length", "max_output_tokens", "max_tokens":
+		return FinishLength
+	case "SAFETY", "RECITATION", "content_filter":
+		return "content_filter"
+	}
+	return reason
+}
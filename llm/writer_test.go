@@ -0,0 +1,98 @@
+package llm
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Prateek-Gupta001/AI_Gateway/types"
+)
+
+// readEvents parses the gateway SSE stream back into events, checking that the
+// "event:" line always matches the type inside the data.
+func readEvents(t *testing.T, stream string) []types.StreamEvent {
+	t.Helper()
+	var events []types.StreamEvent
+	var name string
+	scanner := bufio.NewScanner(strings.NewReader(stream))
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			var event types.StreamEvent
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event); err != nil {
+				t.Fatalf("could not unmarshal event %q: %v", line, err)
+			}
+			if string(event.Type) != name {
+				t.Fatalf("event line said %q but data said %q", name, event.Type)
+			}
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+func TestSSEWriter(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	sw := NewSSEWriter(recorder, "req-1")
+	if sw.Started() {
+		t.Fatal("writer should not have started before the first event")
+	}
+	sw.WriteDelta("Hello")
+	sw.WriteDelta(" there")
+	sw.WriteUsage(1, 2, 3)
+	sw.WriteUsage(4, 5, 9) //only the last usage should go out
+	sw.WriteFinish(normalizeFinishReason("MAX_TOKENS"))
+	sw.SetMeta("Gemini", types.High)
+	if err := sw.Close(); err != nil {
+		t.Fatalf("Close returned %v", err)
+	}
+
+	if ct := recorder.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	events := readEvents(t, recorder.Body.String())
+	want := []types.StreamEventType{types.EventDelta, types.EventDelta, types.EventUsage, types.EventFinish, types.EventDone}
+	if len(events) != len(want) {
+		t.Fatalf("got %d events, want %d: %+v", len(events), len(want), events)
+	}
+	for i, e := range events {
+		if e.Type != want[i] {
+			t.Errorf("event %d is %q, want %q", i, e.Type, want[i])
+		}
+	}
+	if events[0].Text+events[1].Text != "Hello there" {
+		t.Errorf("deltas = %q %q", events[0].Text, events[1].Text)
+	}
+	if events[2].Usage == nil || events[2].Usage.TotalTokens != 9 {
+		t.Errorf("usage = %+v", events[2].Usage)
+	}
+	if events[3].FinishReason != FinishLength {
+		t.Errorf("finish reason = %q", events[3].FinishReason)
+	}
+	done := events[4]
+	if done.StreamMeta == nil || done.RequestId != "req-1" || done.Model != "Gemini" || done.Level != types.High {
+		t.Errorf("done meta = %+v", done.StreamMeta)
+	}
+}
+
+func TestSSEWriterError(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	sw := NewSSEWriter(recorder, "req-2")
+	sw.WriteDelta("partial")
+	if err := sw.WriteError(errors.New("upstream went away")); err != nil {
+		t.Fatalf("WriteError returned %v", err)
+	}
+	events := readEvents(t, recorder.Body.String())
+	if len(events) != 3 || events[1].Type != types.EventError || events[2].Type != types.EventDone {
+		t.Fatalf("unexpected events %+v", events)
+	}
+	if events[1].Error != "upstream went away" {
+		t.Errorf("error = %q", events[1].Error)
+	}
+}
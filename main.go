@@ -2,13 +2,16 @@ package main
 
 import (
 	"context"
+	"database/sql"
 	"log/slog"
 	"os"
 	"path/filepath"
 	"strconv"
+	"time"
 
 	"github.com/Prateek-Gupta001/AI_Gateway/api"
 	"github.com/Prateek-Gupta001/AI_Gateway/cache"
+	"github.com/Prateek-Gupta001/AI_Gateway/classifier"
 	"github.com/Prateek-Gupta001/AI_Gateway/embed"
 	"github.com/Prateek-Gupta001/AI_Gateway/llm"
 	"github.com/Prateek-Gupta001/AI_Gateway/store"
@@ -46,15 +49,153 @@ func main() {
 		slog.Error("Got this error while trying to intialise the postgres db ", "error", err2.Error())
 		panic(err2)
 	}
-	llm := llm.NewLLMStruct()
-	cache := cache.NewQdrantCache()
-	go cache.ReviseCache(ctx)
+	llms, err := llm.NewLLMStruct()
+	if err != nil {
+		slog.Error("Got this error while trying to set up the models", "error", err)
+		panic(err)
+	}
+	if path := os.Getenv("MODEL_REGISTRY"); path != "" {
+		reg, err := llm.LoadRegistry(path)
+		if err != nil {
+			slog.Error("Got this error while trying to load the model registry", "error", err)
+			panic(err)
+		}
+		llms, err = llm.NewLLMStructFromRegistry(reg)
+		if err != nil {
+			slog.Error("Got this error while trying to build the models from the registry", "error", err)
+			panic(err)
+		}
+		slog.Info("Models loaded from the registry", "models", len(reg.Models))
+	}
+	vectorCache := newVectorCache(ctx, store.DB())
 	embed := embed.NewEmbeddingService(3, 1000)
-	server := api.NewAIGateway(":9000", store, llm, cache, embed, 1)
+	server := api.NewAIGateway(":9000", store, llms, vectorCache, embed)
+	server.CacheReplay = cacheReplayConfig()
+	server.CacheContextDepth, _ = strconv.Atoi(os.Getenv("CACHE_CONTEXT_DEPTH"))
+	//the cache section of GATEWAY_CONFIG can set ttls per tenant, model and level on top of this
+	server.CacheTTL.DefaultSeconds, _ = strconv.Atoi(os.Getenv("CACHE_TTL_SECONDS"))
+	server.CacheTTL.Sliding = os.Getenv("CACHE_SLIDING_TTL") == "true"
+	vectorCache.SetSliding(server.CacheTTL.Sliding)
+	if env := os.Getenv("EXACT_CACHE_SIZE"); env != "" {
+		size, _ := strconv.Atoi(env)
+		ttlSeconds, err := strconv.Atoi(os.Getenv("EXACT_CACHE_TTL_SECONDS"))
+		if err != nil {
+			ttlSeconds = 3600
+		}
+		server.ExactCache = cache.NewExactCache(size, time.Duration(ttlSeconds)*time.Second)
+	}
+	if path := os.Getenv("TIME_SENSITIVITY_CONFIG"); path != "" {
+		cfg, err := classifier.LoadDynamicConfig(path)
+		if err != nil {
+			slog.Error("Got this error while trying to load the time sensitivity config", "error", err)
+			panic(err)
+		}
+		detector, err := classifier.NewDynamicDetector(cfg)
+		if err != nil {
+			slog.Error("Got this error while trying to build the time sensitivity detector", "error", err)
+			panic(err)
+		}
+		server.TimeSensitivity = detector
+	}
+	if path := os.Getenv("CLASSIFIER_CONFIG"); path != "" {
+		cfg, err := classifier.LoadConfig(path)
+		if err != nil {
+			slog.Error("Got this error while trying to load the classifier config", "error", err)
+			panic(err)
+		}
+		c, err := classifier.New(ctx, cfg, embed)
+		if err != nil {
+			slog.Error("Got this error while trying to build the classifier", "error", err)
+			panic(err)
+		}
+		server.Classifier = c
+		slog.Info("Classifier loaded from config", "type", cfg.Type)
+	}
+	if path := os.Getenv("GATEWAY_CONFIG"); path != "" {
+		if err := server.LoadConfigFile(path); err != nil {
+			slog.Error("Got this error while trying to load the gateway config", "error", err)
+			panic(err)
+		}
+		//the file is polled for changes, 0 turns that off and leaves only POST /admin/config/reload
+		watchSeconds := 5
+		if env := os.Getenv("GATEWAY_CONFIG_WATCH_SECONDS"); env != "" {
+			watchSeconds, _ = strconv.Atoi(env)
+		}
+		if watchSeconds > 0 {
+			go server.WatchConfig(ctx, time.Duration(watchSeconds)*time.Second)
+		}
+	}
+	server.RequireAPIKey = os.Getenv("REQUIRE_API_KEY") == "true"
+	server.AdminToken = os.Getenv("ADMIN_TOKEN")
+	server.OpenAdmin = os.Getenv("ADMIN_OPEN") == "true"
+	if server.AdminToken == "" && server.OpenAdmin {
+		slog.Warn("ADMIN_TOKEN is not set and ADMIN_OPEN=true, the /admin endpoints are open to anyone who can reach the gateway")
+	} else if server.AdminToken == "" {
+		slog.Warn("ADMIN_TOKEN is not set, the /admin endpoints are disabled")
+	}
+	go server.RunRateLimiter(ctx, time.Minute)
 	slog.Info("Server is running on port 9000!")
 	server.Run()
 }
 
+// newVectorCache picks the semantic cache backend with CACHE_BACKEND: qdrant (the default),
+// postgres to keep it in the store's database with pgvector, or memory to run without either.
+func newVectorCache(ctx context.Context, db *sql.DB) cache.Cache {
+	//expired entries are never served, the sweep only frees the space they take
+	sweep := time.Hour
+	if seconds, err := strconv.Atoi(os.Getenv("CACHE_SWEEP_SECONDS")); err == nil && seconds > 0 {
+		sweep = time.Duration(seconds) * time.Second
+	}
+	switch backend := os.Getenv("CACHE_BACKEND"); backend {
+	case "", "qdrant":
+		c := cache.NewQdrantCache()
+		go c.ReviseCache(ctx, sweep)
+		return c
+	case "postgres":
+		c, err := cache.NewPgVectorCache(db, 384)
+		if err != nil {
+			slog.Error("Got this error while trying to set up the pgvector cache", "error", err)
+			panic(err)
+		}
+		go c.ReviseCache(ctx, sweep)
+		return c
+	case "memory":
+		capacity, err := strconv.Atoi(os.Getenv("MEMORY_CACHE_CAPACITY"))
+		if err != nil {
+			capacity = 10000
+		}
+		c := cache.NewMemoryCache(capacity)
+		path := os.Getenv("MEMORY_CACHE_SNAPSHOT")
+		if path != "" {
+			if err := c.LoadSnapshot(path); err != nil {
+				slog.Error("Got this error while trying to load the memory cache snapshot, starting empty", "path", path, "error", err)
+			}
+		}
+		interval := time.Minute
+		if seconds, err := strconv.Atoi(os.Getenv("MEMORY_CACHE_SNAPSHOT_SECONDS")); err == nil && seconds > 0 {
+			interval = time.Duration(seconds) * time.Second
+		}
+		go c.ReviseCache(ctx, interval, path)
+		slog.Info("Using the in memory cache", "capacity", capacity, "snapshot", path, "entries", c.Len())
+		return c
+	default:
+		slog.Error("Unknown CACHE_BACKEND, expected qdrant, postgres or memory", "backend", backend)
+		panic("unknown cache backend " + backend)
+	}
+}
+
+// cacheReplayConfig reads how cache hits should be sent back from the env.
+// CACHE_REPLAY_STREAM=true replays them as SSE, CACHE_REPLAY_CHUNK_WORDS and CACHE_REPLAY_DELAY_MS control the pacing.
+func cacheReplayConfig() api.CacheReplayConfig {
+	chunkWords, _ := strconv.Atoi(os.Getenv("CACHE_REPLAY_CHUNK_WORDS"))
+	delayMs, _ := strconv.Atoi(os.Getenv("CACHE_REPLAY_DELAY_MS"))
+	return api.CacheReplayConfig{
+		Stream:     os.Getenv("CACHE_REPLAY_STREAM") == "true",
+		ChunkWords: chunkWords,
+		Delay:      time.Duration(delayMs) * time.Millisecond,
+	}
+}
+
 func returnOpts() *slog.HandlerOptions {
 	return &slog.HandlerOptions{
 		Level:     slog.LevelInfo,
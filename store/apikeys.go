@@ -0,0 +1,121 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/Prateek-Gupta001/AI_Gateway/types"
+)
+
+var ErrKeyNotFound = errors.New("api key not found")
+
+// execer is what both *sql.DB and *sql.Tx can run the key queries on.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func insertAPIKey(ctx context.Context, db execer, key types.APIKey, hash string) error {
+	//the tenant and the user are created on their first key
+	if _, err := db.ExecContext(ctx, `INSERT INTO Tenants (id) VALUES ($1) ON CONFLICT (id) DO NOTHING`, key.TenantId); err != nil {
+		return err
+	}
+	if _, err := db.ExecContext(ctx, `INSERT INTO Account (user_id) VALUES ($1) ON CONFLICT (user_id) DO NOTHING`, key.UserId); err != nil {
+		return err
+	}
+	_, err := db.ExecContext(ctx, `INSERT INTO Api_Keys (id, tenant_id, user_id, name, prefix, key_hash, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		key.Id, key.TenantId, key.UserId, key.Name, key.Prefix, hash, key.CreatedAt)
+	return err
+}
+
+func (s *PostgresStore) CreateAPIKey(ctx context.Context, key types.APIKey, hash string) error {
+	ctx, cancelctx := context.WithTimeout(ctx, time.Second*3)
+	defer cancelctx()
+	return insertAPIKey(ctx, s.db, key, hash)
+}
+
+const apiKeyColumns = `id, tenant_id, user_id, name, prefix, created_at, revoked_at`
+
+func scanAPIKey(row interface{ Scan(...any) error }) (types.APIKey, error) {
+	var key types.APIKey
+	var revokedAt sql.NullTime
+	if err := row.Scan(&key.Id, &key.TenantId, &key.UserId, &key.Name, &key.Prefix, &key.CreatedAt, &revokedAt); err != nil {
+		return key, err
+	}
+	if revokedAt.Valid {
+		key.RevokedAt = &revokedAt.Time
+	}
+	return key, nil
+}
+
+// GetAPIKeyByHash looks a key up by its hash. Revoked keys are returned too, the caller decides.
+func (s *PostgresStore) GetAPIKeyByHash(ctx context.Context, hash string) (types.APIKey, error) {
+	ctx, cancelctx := context.WithTimeout(ctx, time.Second*3)
+	defer cancelctx()
+	key, err := scanAPIKey(s.db.QueryRowContext(ctx, `SELECT `+apiKeyColumns+` FROM Api_Keys WHERE key_hash = $1`, hash))
+	if err == sql.ErrNoRows {
+		return key, ErrKeyNotFound
+	}
+	return key, err
+}
+
+// ListAPIKeys returns the keys of a tenant, or of every tenant when tenantId is empty.
+func (s *PostgresStore) ListAPIKeys(ctx context.Context, tenantId string) ([]types.APIKey, error) {
+	ctx, cancelctx := context.WithTimeout(ctx, time.Second*3)
+	defer cancelctx()
+	rows, err := s.db.QueryContext(ctx, `SELECT `+apiKeyColumns+` FROM Api_Keys
+	WHERE $1 = '' OR tenant_id = $1 ORDER BY created_at`, tenantId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	keys := []types.APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// RotateAPIKey revokes the old key and creates its replacement (same tenant, user and name) in one transaction.
+// key only needs its id, prefix and creation time set, the rest is copied from the old key.
+func (s *PostgresStore) RotateAPIKey(ctx context.Context, oldId string, key types.APIKey, hash string) (types.APIKey, error) {
+	ctx, cancelctx := context.WithTimeout(ctx, time.Second*3)
+	defer cancelctx()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return key, err
+	}
+	defer tx.Rollback()
+	old, err := scanAPIKey(tx.QueryRowContext(ctx, `UPDATE Api_Keys SET revoked_at = now()
+	WHERE id = $1 AND revoked_at IS NULL RETURNING `+apiKeyColumns, oldId))
+	if err == sql.ErrNoRows {
+		return key, ErrKeyNotFound
+	}
+	if err != nil {
+		return key, err
+	}
+	key.TenantId, key.UserId, key.Name = old.TenantId, old.UserId, old.Name
+	if err := insertAPIKey(ctx, tx, key, hash); err != nil {
+		return key, err
+	}
+	return key, tx.Commit()
+}
+
+func (s *PostgresStore) RevokeAPIKey(ctx context.Context, id string) error {
+	ctx, cancelctx := context.WithTimeout(ctx, time.Second*3)
+	defer cancelctx()
+	res, err := s.db.ExecContext(ctx, `UPDATE Api_Keys SET revoked_at = now() WHERE id = $1 AND revoked_at IS NULL`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrKeyNotFound
+	}
+	return nil
+}
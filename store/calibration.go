@@ -0,0 +1,34 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/Prateek-Gupta001/AI_Gateway/types"
+)
+
+// SampleCacheHits picks up to limit semantic cache hits at random whose similarity was between
+// low and high, so the hits close to the threshold can be checked by hand.
+func (s *PostgresStore) SampleCacheHits(ctx context.Context, low float32, high float32, limit int) ([]types.CacheHitSample, error) {
+	ctx, cancelctx := context.WithTimeout(ctx, time.Second*3)
+	defer cancelctx()
+	query := `SELECT id, user_query, COALESCE(cached_query, ''), cache_score, llm_response, COALESCE(cache_entry_id, ''), COALESCE(feedback, 0)
+	FROM Requests
+	WHERE cache_tier = $1 AND cache_score >= $2 AND cache_score <= $3
+	ORDER BY random()
+	LIMIT $4`
+	rows, err := s.db.QueryContext(ctx, query, types.CacheTierSemantic, low, high, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	samples := []types.CacheHitSample{}
+	for rows.Next() {
+		var h types.CacheHitSample
+		if err := rows.Scan(&h.RequestId, &h.Query, &h.CachedQuery, &h.Score, &h.Answer, &h.CacheEntryId, &h.Feedback); err != nil {
+			return nil, err
+		}
+		samples = append(samples, h)
+	}
+	return samples, rows.Err()
+}
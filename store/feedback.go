@@ -0,0 +1,33 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/Prateek-Gupta001/AI_Gateway/types"
+)
+
+var ErrRequestNotFound = errors.New("request not found")
+
+// SetFeedback records a user's feedback on one of their own requests. It returns the feedback it
+// replaced, so the counts on the cache entry can be corrected, and the entry the request is tied to.
+func (s *PostgresStore) SetFeedback(ctx context.Context, requestId string, userId string, feedback types.Feedback) (types.Feedback, string, error) {
+	ctx, cancelctx := context.WithTimeout(ctx, time.Second*3)
+	defer cancelctx()
+	query := `UPDATE Requests r SET feedback = $3
+	FROM (SELECT id, feedback FROM Requests WHERE id = $1 AND user_id = $2 FOR UPDATE) old
+	WHERE r.id = old.id
+	RETURNING COALESCE(old.feedback, 0), COALESCE(r.cache_entry_id, '')`
+	var previous types.Feedback
+	var cacheEntryId string
+	err := s.db.QueryRowContext(ctx, query, requestId, userId, feedback).Scan(&previous, &cacheEntryId)
+	if err == sql.ErrNoRows {
+		return types.FeedbackNone, "", ErrRequestNotFound
+	}
+	if err != nil {
+		return types.FeedbackNone, "", err
+	}
+	return previous, cacheEntryId, nil
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
+	"sort"
 	"time"
 
 	_ "github.com/lib/pq"
@@ -15,9 +16,20 @@ import (
 
 type Storage interface {
 	SubmitInsertRequest(context.Context, types.Request)
-	SubmitIncrementUserTokens(context.Context, string, int, types.Level)
+	SubmitIncrementUserTokens(context.Context, string, int, float64, types.Level)
 	GetAnalytics() (types.AnalyticsResponse, error)
 	GetAllRequests() ([]*types.Request, error)
+	GetRateLimitOverrides(context.Context) (map[string]types.RateLimitOverride, error)
+	GetBudgetStatus(context.Context, string) (types.BudgetStatus, error)
+	SetBudget(context.Context, types.Budget) error
+	ResetBudgetUsage(context.Context, string, string) error
+	CreateAPIKey(ctx context.Context, key types.APIKey, hash string) error
+	GetAPIKeyByHash(ctx context.Context, hash string) (types.APIKey, error)
+	ListAPIKeys(ctx context.Context, tenantId string) ([]types.APIKey, error)
+	RotateAPIKey(ctx context.Context, oldId string, key types.APIKey, hash string) (types.APIKey, error)
+	RevokeAPIKey(ctx context.Context, id string) error
+	SetFeedback(ctx context.Context, requestId string, userId string, feedback types.Feedback) (types.Feedback, string, error)
+	SampleCacheHits(ctx context.Context, low float32, high float32, limit int) ([]types.CacheHitSample, error)
 }
 
 type PostgresStore struct {
@@ -26,6 +38,11 @@ type PostgresStore struct {
 	IncrementTokenChan chan types.IncTokenPayload
 }
 
+// DB is the connection pool, for the pgvector cache to share.
+func (s *PostgresStore) DB() *sql.DB {
+	return s.db
+}
+
 func (s *PostgresStore) StoreWorker(id int) {
 	slog.Info("Starting StoreWorker", "id", id)
 	for {
@@ -37,7 +54,7 @@ func (s *PostgresStore) StoreWorker(id int) {
 				slog.Error("Got this error while trying to insert the request Id", "id", uid, "error", err)
 			} //TODO: Add id (from context!)
 		case val := <-s.IncrementTokenChan:
-			err := s.IncrementUserTokens(val.Ctx, val.UserId, val.Tokens, val.Level)
+			err := s.IncrementUserTokens(val.Ctx, val.UserId, val.Tokens, val.Cost, val.Level)
 			if err != nil {
 				uid, _ := val.Ctx.Value(types.UserIdKey).(string)
 				slog.Error("Got this error while trying to increment user tokens", "id", uid, "error", err)
@@ -59,12 +76,13 @@ func (s *PostgresStore) SubmitInsertRequest(ctx context.Context, request types.R
 
 }
 
-func (s *PostgresStore) SubmitIncrementUserTokens(ctx context.Context, userId string, tokens int, level types.Level) {
+func (s *PostgresStore) SubmitIncrementUserTokens(ctx context.Context, userId string, tokens int, cost float64, level types.Level) {
 
 	select {
 	case s.IncrementTokenChan <- types.IncTokenPayload{
 		UserId: userId,
 		Tokens: tokens,
+		Cost:   cost,
 		Level:  level,
 		Ctx:    ctx,
 	}:
@@ -139,6 +157,81 @@ func (s *PostgresStore) Init() error {
 		slog.Info("Got this error while trying to create table accounts", "error", err2.Error())
 		return err2
 	}
+	//columns added after the table was first created
+	query4 := `ALTER TABLE Requests ADD COLUMN IF NOT EXISTS level_reason TEXT`
+	if _, err4 := s.db.Exec(query4); err4 != nil {
+		slog.Info("Got this error while trying to add the level_reason column", "error", err4.Error())
+		return err4
+	}
+	//per user rate limits, null means the default from the config
+	query5 := `ALTER TABLE Account ADD COLUMN IF NOT EXISTS requests_per_minute INT, ADD COLUMN IF NOT EXISTS tokens_per_minute INT`
+	if _, err5 := s.db.Exec(query5); err5 != nil {
+		slog.Info("Got this error while trying to add the rate limit columns", "error", err5.Error())
+		return err5
+	}
+	//budgets, null means no limit
+	query6 := `ALTER TABLE Account ADD COLUMN IF NOT EXISTS daily_token_budget BIGINT,
+	ADD COLUMN IF NOT EXISTS monthly_token_budget BIGINT,
+	ADD COLUMN IF NOT EXISTS daily_dollar_budget DOUBLE PRECISION,
+	ADD COLUMN IF NOT EXISTS monthly_dollar_budget DOUBLE PRECISION,
+	ADD COLUMN IF NOT EXISTS budget_action TEXT`
+	if _, err6 := s.db.Exec(query6); err6 != nil {
+		slog.Info("Got this error while trying to add the budget columns", "error", err6.Error())
+		return err6
+	}
+	//what every user spent per day and per month, checked against the budgets
+	query7 := `CREATE TABLE IF NOT EXISTS Budget_Usage(
+	user_id varchar(50) REFERENCES Account(user_id),
+	period TEXT NOT NULL,
+	period_start DATE NOT NULL,
+	tokens BIGINT NOT NULL default 0,
+	dollars DOUBLE PRECISION NOT NULL default 0,
+	primary key (user_id, period, period_start)
+	)`
+	if _, err7 := s.db.Exec(query7); err7 != nil {
+		slog.Info("Got this error while trying to create table budget usage", "error", err7.Error())
+		return err7
+	}
+	query8 := `CREATE TABLE IF NOT EXISTS Tenants(
+	id varchar(50) primary key,
+	created_at TIMESTAMPTZ NOT NULL default now()
+	)`
+	if _, err8 := s.db.Exec(query8); err8 != nil {
+		slog.Info("Got this error while trying to create table tenants", "error", err8.Error())
+		return err8
+	}
+	//only the sha256 of a key is kept, the key itself is shown once when it is created
+	query9 := `CREATE TABLE IF NOT EXISTS Api_Keys(
+	id UUID primary key,
+	tenant_id varchar(50) NOT NULL REFERENCES Tenants(id),
+	user_id varchar(50) NOT NULL REFERENCES Account(user_id),
+	name TEXT NOT NULL default '',
+	prefix TEXT NOT NULL,
+	key_hash TEXT NOT NULL UNIQUE,
+	created_at TIMESTAMPTZ NOT NULL default now(),
+	revoked_at TIMESTAMPTZ
+	)`
+	if _, err9 := s.db.Exec(query9); err9 != nil {
+		slog.Info("Got this error while trying to create table api keys", "error", err9.Error())
+		return err9
+	}
+	query10 := `ALTER TABLE Requests ADD COLUMN IF NOT EXISTS cache_tier TEXT`
+	if _, err10 := s.db.Exec(query10); err10 != nil {
+		slog.Info("Got this error while trying to add the cache_tier column", "error", err10.Error())
+		return err10
+	}
+	query11 := `ALTER TABLE Requests ADD COLUMN IF NOT EXISTS cache_entry_id TEXT,
+	ADD COLUMN IF NOT EXISTS feedback SMALLINT`
+	if _, err11 := s.db.Exec(query11); err11 != nil {
+		slog.Info("Got this error while trying to add the cache_entry_id and feedback columns", "error", err11.Error())
+		return err11
+	}
+	query12 := `ALTER TABLE Requests ADD COLUMN IF NOT EXISTS cached_query TEXT,
+	ADD COLUMN IF NOT EXISTS cache_score REAL`
+	if _, err12 := s.db.Exec(query12); err12 != nil {
+		slog.Info("Got this error while trying to add the cached_query and cache_score columns", "error", err12.Error())
+		return err12
+	}
 	slog.Info("Tables have been created!")
 	return nil
 }
@@ -146,12 +239,12 @@ func (s *PostgresStore) Init() error {
 // This function creates the userId if it doesn't exist in the db and then fetches it
 //I guess this should be included in the increment tokens function only ...
 
-func (s *PostgresStore) IncrementUserTokens(ctx context.Context, userId string, tokens int, level types.Level) error {
+func (s *PostgresStore) IncrementUserTokens(ctx context.Context, userId string, tokens int, cost float64, level types.Level) error {
 	var complex_tokens, simple_tokens int
 	switch level {
 	case types.Easy:
 		simple_tokens = tokens
-	case types.High:
+	case types.High, types.Medium:
 		complex_tokens = tokens
 	}
 
@@ -194,13 +287,139 @@ func (s *PostgresStore) IncrementUserTokens(ctx context.Context, userId string,
 		return err
 	}
 	slog.Info("tokens incremented!", "account", acc)
+	const usageQuery = `
+	INSERT INTO Budget_Usage (user_id, period, period_start, tokens, dollars)
+	VALUES ($1, 'daily', $2, $4, $5), ($1, 'monthly', $3, $4, $5)
+	ON CONFLICT (user_id, period, period_start) DO UPDATE
+	SET
+		tokens  = Budget_Usage.tokens  + EXCLUDED.tokens,
+		dollars = Budget_Usage.dollars + EXCLUDED.dollars
+	`
+	day, month := periodStarts(time.Now())
+	if _, err := s.db.ExecContext(ctx, usageQuery, userId, day, month, tokens, cost); err != nil {
+		return err
+	}
 	return nil
 }
 
+// periodStarts returns the start of the current budget day and month, in UTC.
+func periodStarts(now time.Time) (time.Time, time.Time) {
+	now = now.UTC()
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return day, month
+}
+
+// GetBudgetStatus returns a user's budget and what they have spent in the current day and month.
+// Users without an account get an empty budget, which means no limits.
+func (s *PostgresStore) GetBudgetStatus(ctx context.Context, userId string) (types.BudgetStatus, error) {
+	const query = `
+	SELECT
+		COALESCE(a.daily_token_budget, 0),
+		COALESCE(a.monthly_token_budget, 0),
+		COALESCE(a.daily_dollar_budget, 0),
+		COALESCE(a.monthly_dollar_budget, 0),
+		COALESCE(a.budget_action, ''),
+		COALESCE(d.tokens, 0),
+		COALESCE(d.dollars, 0),
+		COALESCE(m.tokens, 0),
+		COALESCE(m.dollars, 0)
+	FROM Account a
+	LEFT JOIN Budget_Usage d ON d.user_id = a.user_id AND d.period = 'daily' AND d.period_start = $2
+	LEFT JOIN Budget_Usage m ON m.user_id = a.user_id AND m.period = 'monthly' AND m.period_start = $3
+	WHERE a.user_id = $1
+	`
+	status := types.BudgetStatus{Budget: types.Budget{UserId: userId}}
+	day, month := periodStarts(time.Now())
+	err := s.db.QueryRowContext(ctx, query, userId, day, month).Scan(
+		&status.Budget.DailyTokens,
+		&status.Budget.MonthlyTokens,
+		&status.Budget.DailyDollars,
+		&status.Budget.MonthlyDollars,
+		&status.Budget.Action,
+		&status.Daily.Tokens,
+		&status.Daily.Dollars,
+		&status.Monthly.Tokens,
+		&status.Monthly.Dollars,
+	)
+	if err == sql.ErrNoRows {
+		return status, nil
+	}
+	return status, err
+}
+
+// SetBudget creates the account if needed and replaces its budget. Zero limits are stored as null.
+func (s *PostgresStore) SetBudget(ctx context.Context, b types.Budget) error {
+	const query = `
+	INSERT INTO Account (user_id, daily_token_budget, monthly_token_budget, daily_dollar_budget, monthly_dollar_budget, budget_action)
+	VALUES ($1, NULLIF($2, 0), NULLIF($3, 0), NULLIF($4, 0), NULLIF($5, 0), $6)
+	ON CONFLICT (user_id) DO UPDATE
+	SET
+		daily_token_budget    = EXCLUDED.daily_token_budget,
+		monthly_token_budget  = EXCLUDED.monthly_token_budget,
+		daily_dollar_budget   = EXCLUDED.daily_dollar_budget,
+		monthly_dollar_budget = EXCLUDED.monthly_dollar_budget,
+		budget_action         = EXCLUDED.budget_action
+	`
+	ctx, cancelctx := context.WithTimeout(ctx, time.Second*3)
+	defer cancelctx()
+	_, err := s.db.ExecContext(ctx, query, b.UserId, b.DailyTokens, b.MonthlyTokens, b.DailyDollars, b.MonthlyDollars, string(b.Action))
+	return err
+}
+
+// ResetBudgetUsage zeroes what a user has spent in the current period ("daily", "monthly" or "all").
+func (s *PostgresStore) ResetBudgetUsage(ctx context.Context, userId string, period string) error {
+	day, month := periodStarts(time.Now())
+	const query = `
+	UPDATE Budget_Usage SET tokens = 0, dollars = 0
+	WHERE user_id = $1 AND ((period = 'daily' AND period_start = $2 AND $4) OR (period = 'monthly' AND period_start = $3 AND $5))
+	`
+	daily := period == "daily" || period == "all"
+	monthly := period == "monthly" || period == "all"
+	if !daily && !monthly {
+		return fmt.Errorf("unknown budget period %q", period)
+	}
+	ctx, cancelctx := context.WithTimeout(ctx, time.Second*3)
+	defer cancelctx()
+	_, err := s.db.ExecContext(ctx, query, userId, day, month, daily, monthly)
+	return err
+}
+
+// GetRateLimitOverrides returns the users that have their own rate limits set, keyed by user id.
+func (s *PostgresStore) GetRateLimitOverrides(ctx context.Context) (map[string]types.RateLimitOverride, error) {
+	ctx, cancelctx := context.WithTimeout(ctx, time.Second*3)
+	defer cancelctx()
+	rows, err := s.db.QueryContext(ctx, `SELECT user_id, requests_per_minute, tokens_per_minute FROM Account
+	WHERE requests_per_minute IS NOT NULL OR tokens_per_minute IS NOT NULL`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	overrides := map[string]types.RateLimitOverride{}
+	for rows.Next() {
+		var userId string
+		var requests, tokens sql.NullInt64
+		if err := rows.Scan(&userId, &requests, &tokens); err != nil {
+			return nil, err
+		}
+		var o types.RateLimitOverride
+		if requests.Valid {
+			v := int(requests.Int64)
+			o.RequestsPerMinute = &v
+		}
+		if tokens.Valid {
+			v := int(tokens.Int64)
+			o.TokensPerMinute = &v
+		}
+		overrides[userId] = o
+	}
+	return overrides, rows.Err()
+}
+
 func (s *PostgresStore) InsertRequest(ctx context.Context, request types.Request) error {
 	slog.Info("Adding a request into the db!")
-	query := `INSERT INTO Requests(id, cacheable, user_id, user_query, llm_response, input_tokens, output_tokens, total_tokens, time_taken, model, cache_hit, level)
-	VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+	query := `INSERT INTO Requests(id, cacheable, user_id, user_query, llm_response, input_tokens, output_tokens, total_tokens, time_taken, model, cache_hit, level, level_reason, cache_tier, cache_entry_id, cached_query, cache_score)
+	VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, NULLIF($14, ''), NULLIF($15, ''), NULLIF($16, ''), NULLIF($17::real, 0))
 	`
 	ctx, cancelctx := context.WithTimeout(ctx, time.Second*3)
 	defer cancelctx()
@@ -217,6 +436,11 @@ func (s *PostgresStore) InsertRequest(ctx context.Context, request types.Request
 		request.Model,
 		request.CacheHit,
 		request.Level,
+		request.LevelReason,
+		request.CacheTier,
+		request.CacheEntryId,
+		request.CachedQuery,
+		request.CacheScore,
 	); err != nil {
 		slog.Info("Got an error while trying to insert this request into the postgres db", "error", err, "request", request)
 		return err
@@ -234,13 +458,43 @@ func (s *PostgresStore) GetAnalytics() (types.AnalyticsResponse, error) {
 	}
 	var CostSaved float64
 	var CacheHitNum, CacheMissNum int64
+	var ExactHitNum, SemanticHitNum, CoalescedHitNum int64
 	var TotalCacheMissTime, TotalCacheHitTime int64
 	var TotalCacheMissTokens, TotalCacheHitTokens int
+	feedback := map[string]*types.CacheEntryFeedback{}
 
 	slog.Info("Number of requests", "num", len(reqs))
 	for _, r := range reqs {
+		if r.CacheEntryId != "" && r.Feedback != types.FeedbackNone {
+			f, ok := feedback[r.CacheEntryId]
+			if !ok {
+				f = &types.CacheEntryFeedback{CacheEntryId: r.CacheEntryId, Query: r.UserQuery}
+				if r.CachedQuery != "" {
+					f.Query = r.CachedQuery
+				}
+				feedback[r.CacheEntryId] = f
+			}
+			//the request that put the answer in the cache is the one with the entry's query
+			if !r.CacheHit {
+				f.Query = r.UserQuery
+			}
+			if r.Feedback == types.FeedbackUp {
+				f.Up++
+			} else {
+				f.Down++
+			}
+		}
 		if r.CacheHit == true {
 			CacheHitNum++
+			//hits from before the exact tier existed have no tier, they were all semantic
+			switch r.CacheTier {
+			case types.CacheTierExact:
+				ExactHitNum++
+			case types.CacheTierCoalesced:
+				CoalescedHitNum++
+			default:
+				SemanticHitNum++
+			}
 			TotalCacheHitTokens += r.TotalToken
 			TotalCacheHitTime += r.Time.Milliseconds()
 			// TimeSaved += time.Duration(AvgTimeTakenPerToken*r.TotalToken) - AvgTimeTakenByCachedRequest
@@ -263,10 +517,14 @@ func (s *PostgresStore) GetAnalytics() (types.AnalyticsResponse, error) {
 	// TimeSaved := float64(AvgTimeTakenPerTokenOnCacheMiss)*(float64(TotalCacheHitTokens)+float64(TotalCacheMissTokens)) - float64(TotalCacheHitTime+TotalCacheMissTime)
 	//Time saved is total time taken if all requests were cache miss - total time taken in reality
 	CacheHitPercentage := float64(CacheHitNum) / float64(len(reqs)) * 100
-	slog.Info("Costs Saved", "num", CostSaved, "No. of Cache hits", CacheHitNum, "CacheHitPercentage", CacheHitPercentage)
+	slog.Info("Costs Saved", "num", CostSaved, "No. of Cache hits", CacheHitNum, "CacheHitPercentage", CacheHitPercentage, "exact", ExactHitNum, "semantic", SemanticHitNum, "coalesced", CoalescedHitNum)
 	return types.AnalyticsResponse{
-		CostSaved:          CostSaved,
-		CacheHitPercentage: CacheHitPercentage,
+		CostSaved:              CostSaved,
+		CacheHitPercentage:     CacheHitPercentage,
+		ExactHitPercentage:     float64(ExactHitNum) / float64(len(reqs)) * 100,
+		SemanticHitPercentage:  float64(SemanticHitNum) / float64(len(reqs)) * 100,
+		CoalescedHitPercentage: float64(CoalescedHitNum) / float64(len(reqs)) * 100,
+		CacheFeedback:          sortedFeedback(feedback),
 		// TimeSaved:          time.Duration(TimeSaved * float64(time.Millisecond)),
 		Msg: "Here are the analytics!",
 	}, nil
@@ -320,6 +578,24 @@ func (s *PostgresStore) GetAnalytics() (types.AnalyticsResponse, error) {
 // 	}, nil
 // }
 
+// sortedFeedback puts the entries with the most thumbs down first, then the least liked.
+func sortedFeedback(feedback map[string]*types.CacheEntryFeedback) []types.CacheEntryFeedback {
+	out := make([]types.CacheEntryFeedback, 0, len(feedback))
+	for _, f := range feedback {
+		out = append(out, *f)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Down != out[j].Down {
+			return out[i].Down > out[j].Down
+		}
+		if out[i].Up != out[j].Up {
+			return out[i].Up < out[j].Up
+		}
+		return out[i].CacheEntryId < out[j].CacheEntryId
+	})
+	return out
+}
+
 func (s *PostgresStore) GetAllRequests() ([]*types.Request, error) {
 	query := `SELECT 
 	id, 
@@ -333,7 +609,13 @@ func (s *PostgresStore) GetAllRequests() ([]*types.Request, error) {
 	time_taken,
 	model,
 	cache_hit,
-	level
+	level,
+	COALESCE(level_reason, ''),
+	COALESCE(cache_tier, ''),
+	COALESCE(cache_entry_id, ''),
+	COALESCE(feedback, 0),
+	COALESCE(cached_query, ''),
+	COALESCE(cache_score, 0)
 	FROM Requests`
 	row, err := s.db.Query(query)
 	if err != nil {
@@ -358,6 +640,12 @@ func (s *PostgresStore) GetAllRequests() ([]*types.Request, error) {
 			&r.Model,
 			&r.CacheHit,
 			&r.Level,
+			&r.LevelReason,
+			&r.CacheTier,
+			&r.CacheEntryId,
+			&r.Feedback,
+			&r.CachedQuery,
+			&r.CacheScore,
 		)
 		if err != nil {
 			slog.Info("Got this error while trying to get all requests", "error", err)
@@ -3,6 +3,7 @@ package types
 import (
 	"bytes"
 	"context"
+	"math"
 	"time"
 )
 
@@ -24,11 +25,57 @@ var AllLevels = []Level{
 
 type ctxKey int
 
-const UserIdKey ctxKey = iota
+const (
+	UserIdKey ctxKey = iota
+	// IdentityKey holds the *Identity an API key resolved to, set by the auth middleware.
+	IdentityKey
+)
+
+// Identity is who a request belongs to once its API key has been verified.
+type Identity struct {
+	KeyId    string
+	TenantId string
+	UserId   string
+}
+
+// IdentityFromContext returns the verified identity of the request, nil when there is none.
+func IdentityFromContext(ctx context.Context) *Identity {
+	id, _ := ctx.Value(IdentityKey).(*Identity)
+	return id
+}
+
+// CacheScope is who a cached answer is shared with.
+type CacheScope string
+
+const (
+	// ScopePrivate answers are only served back to the user who asked.
+	ScopePrivate CacheScope = "private"
+	// ScopeTenant answers are shared by every user of the tenant, the default.
+	ScopeTenant CacheScope = "tenant"
+	// ScopeGlobal answers are shared by everyone who also asks for the global scope.
+	ScopeGlobal CacheScope = "global"
+)
+
+func (c CacheScope) Valid() bool {
+	return c == ScopePrivate || c == ScopeTenant || c == ScopeGlobal
+}
+
+// APIKey is everything stored about a key except its hash. The key itself is only ever
+// shown once, when it is created or rotated.
+type APIKey struct {
+	Id        string     `json:"id"`
+	TenantId  string     `json:"tenant_id"`
+	UserId    string     `json:"user_id"`
+	Name      string     `json:"name"`
+	Prefix    string     `json:"prefix"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
 
 type IncTokenPayload struct {
 	UserId string
 	Tokens int
+	Cost   float64
 	Level  Level
 	Ctx    context.Context
 }
@@ -49,9 +96,42 @@ const (
 type AnalyticsResponse struct {
 	CostSaved          float64
 	CacheHitPercentage float64
-	Msg                string
+	// the two tiers of CacheHitPercentage, answered by the in process exact match cache or the vector search
+	ExactHitPercentage    float64
+	SemanticHitPercentage float64
+	// requests that shared the provider call of an identical one in flight, also counted in CacheHitPercentage
+	CoalescedHitPercentage float64
+	// CacheFeedback is the feedback given on the answers of every cache entry that got any, the most disliked first
+	CacheFeedback []CacheEntryFeedback
+	Msg           string
 }
 
+type CacheEntryFeedback struct {
+	CacheEntryId string
+	Query        string //the query the entry was cached under
+	Up           int
+	Down         int
+}
+
+// Feedback is a user's thumbs up or down on an answer.
+type Feedback int
+
+const (
+	FeedbackNone Feedback = 0
+	FeedbackUp   Feedback = 1
+	FeedbackDown Feedback = -1
+)
+
+// CacheTier is which cache answered a request.
+type CacheTier string
+
+const (
+	CacheTierExact    CacheTier = "exact"
+	CacheTierSemantic CacheTier = "semantic"
+	// CacheTierCoalesced is a request that arrived while an identical one was being answered and got its stream.
+	CacheTierCoalesced CacheTier = "coalesced"
+)
+
 type LLMResponse struct {
 	LLMRes       *bytes.Buffer
 	InputTokens  int
@@ -59,6 +139,7 @@ type LLMResponse struct {
 	TotalTokens  int
 	Model        string
 	Level        Level
+	Cost         float64 //in dollars, from the pricing of the model that answered
 }
 
 type EmbeddingResult struct {
@@ -78,17 +159,98 @@ type Messages struct {
 	Content string `json:"content"`
 }
 
+// GenerationOptions are the sampling knobs that get forwarded to whichever provider serves the request.
+type GenerationOptions struct {
+	Temperature *float64 `json:"temperature,omitempty"`
+	MaxTokens   int      `json:"max_tokens,omitempty"`
+}
+
 type RequestStruct struct {
-	UserId    string     `json:"userId"`
-	Messages  []Messages `json:"messages"`
-	CacheFlag bool
+	UserId   string     `json:"userId"`
+	Messages []Messages `json:"messages"`
+	Stream   *bool      `json:"stream,omitempty"` //nil means stream, like it always has
+	// CacheScope is empty for the default (tenant) scope.
+	CacheScope CacheScope `json:"cache_scope,omitempty"`
+	CacheFlag  bool
+	GenerationOptions
+}
+
+func (r *RequestStruct) Streaming() bool {
+	return r.Stream == nil || *r.Stream
+}
+
+// ChatResponse is the whole answer in one json document, sent back on /chat when stream is false.
+type ChatResponse struct {
+	RequestId    string  `json:"request_id"`
+	Response     string  `json:"response"`
+	InputTokens  int     `json:"input_tokens"`
+	OutputTokens int     `json:"output_tokens"`
+	TotalTokens  int     `json:"total_tokens"`
+	Model        string  `json:"model,omitempty"`
+	Level        Level   `json:"level,omitempty"`
+	FinishReason string  `json:"finish_reason"`
+	CacheHit     bool    `json:"cache_hit"`
+	CachedQuery  string  `json:"cached_query,omitempty"`
+	Score        float32 `json:"score,omitempty"`
 }
 
 type CacheResponse struct {
+	Id           string //of the cache entry
 	InputTokens  int
 	OutputTokens int
 	CachedAnswer string
 	CachedQuery  string
+	Score        float32
+}
+
+type BudgetAction string
+
+const (
+	BudgetReject    BudgetAction = "reject"
+	BudgetDowngrade BudgetAction = "downgrade"
+)
+
+// Budget is a user's spending limit. A limit of 0 means no limit. The periods are calendar
+// days and months in UTC.
+type Budget struct {
+	UserId         string       `json:"user_id"`
+	DailyTokens    int64        `json:"daily_tokens"`
+	MonthlyTokens  int64        `json:"monthly_tokens"`
+	DailyDollars   float64      `json:"daily_dollars"`
+	MonthlyDollars float64      `json:"monthly_dollars"`
+	Action         BudgetAction `json:"action"`
+}
+
+type BudgetUsage struct {
+	Tokens  int64   `json:"tokens"`
+	Dollars float64 `json:"dollars"`
+}
+
+type BudgetStatus struct {
+	Budget  Budget      `json:"budget"`
+	Daily   BudgetUsage `json:"daily"`
+	Monthly BudgetUsage `json:"monthly"`
+}
+
+// Exceeded reports which limit, if any, has been used up.
+func (b BudgetStatus) Exceeded() (bool, string) {
+	switch {
+	case b.Budget.DailyTokens > 0 && b.Daily.Tokens >= b.Budget.DailyTokens:
+		return true, "daily token budget"
+	case b.Budget.MonthlyTokens > 0 && b.Monthly.Tokens >= b.Budget.MonthlyTokens:
+		return true, "monthly token budget"
+	case b.Budget.DailyDollars > 0 && b.Daily.Dollars >= b.Budget.DailyDollars:
+		return true, "daily dollar budget"
+	case b.Budget.MonthlyDollars > 0 && b.Monthly.Dollars >= b.Budget.MonthlyDollars:
+		return true, "monthly dollar budget"
+	}
+	return false, ""
+}
+
+// RateLimitOverride replaces the default per user limits, nil keeps the default.
+type RateLimitOverride struct {
+	RequestsPerMinute *int
+	TokensPerMinute   *int
 }
 
 type Account struct {
@@ -110,5 +272,78 @@ type Request struct {
 	Time         time.Duration
 	Model        string
 	CacheHit     bool
+	CacheTier    CacheTier //empty on a cache miss
 	Level        Level
+	LevelReason  string
+	// CacheEntryId is the cache entry that answered the request, or that its answer was cached as
+	CacheEntryId string
+	Feedback     Feedback
+	// CachedQuery and CacheScore are the query a cache hit matched and how similar it was
+	CachedQuery string
+	CacheScore  float32
+}
+
+// CacheHitSample is a semantic cache hit picked out for someone to check if its answer fit.
+type CacheHitSample struct {
+	RequestId    string   `json:"request_id"`
+	Query        string   `json:"query"`
+	CachedQuery  string   `json:"cached_query"`
+	Score        float32  `json:"score"`
+	Answer       string   `json:"answer"`
+	CacheEntryId string   `json:"cache_entry_id,omitempty"`
+	Feedback     Feedback `json:"feedback,omitempty"`
+}
+
+// StreamEventType is the "event:" name of every SSE event the gateway sends on /chat.
+type StreamEventType string
+
+const (
+	EventDelta  StreamEventType = "delta"
+	EventUsage  StreamEventType = "usage"
+	EventFinish StreamEventType = "finish"
+	EventError  StreamEventType = "error"
+	EventDone   StreamEventType = "done"
+)
+
+// StreamEvent is the one stream format clients have to parse, no matter which provider answered.
+type StreamEvent struct {
+	Type         StreamEventType `json:"type"`
+	Text         string          `json:"text,omitempty"`
+	Usage        *StreamUsage    `json:"usage,omitempty"`
+	FinishReason string          `json:"finish_reason,omitempty"`
+	Error        string          `json:"error,omitempty"`
+	*StreamMeta
+}
+
+type StreamUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+	TotalTokens  int `json:"total_tokens"`
+}
+
+// StreamMeta is sent along with the done event.
+type StreamMeta struct {
+	RequestId   string  `json:"request_id,omitempty"`
+	Model       string  `json:"model,omitempty"`
+	Level       Level   `json:"level,omitempty"`
+	CacheHit    bool    `json:"cache_hit"`
+	CachedQuery string  `json:"cached_query,omitempty"`
+	Score       float32 `json:"score,omitempty"`
+}
+
+// CosineSimilarity returns the cosine similarity of the two embeddings, 0 if they can't be compared.
+func (e Embedding) CosineSimilarity(other Embedding) float32 {
+	if len(e) != len(other) || len(e) == 0 {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range e {
+		dot += float64(e[i]) * float64(other[i])
+		normA += float64(e[i]) * float64(e[i])
+		normB += float64(other[i]) * float64(other[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return float32(dot / (math.Sqrt(normA) * math.Sqrt(normB)))
 }